@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const addonLabel = "knest.smartx.com/addon"
+
+type helmAddon struct {
+	Repo      string
+	Chart     string
+	Version   string
+	Namespace string
+	Values    []string
+}
+
+// addonCatalog are the embedded addons with tested versions of their Helm charts.
+var addonCatalog = map[string]helmAddon{
+	"metrics-server": {
+		Repo:      "https://kubernetes-sigs.github.io/metrics-server",
+		Chart:     "metrics-server",
+		Version:   "3.8.2",
+		Namespace: "kube-system",
+		Values:    []string{"args={--kubelet-insecure-tls}"},
+	},
+	"ingress-nginx": {
+		Repo:      "https://kubernetes.github.io/ingress-nginx",
+		Chart:     "ingress-nginx",
+		Version:   "4.3.0",
+		Namespace: "ingress-nginx",
+	},
+	"openebs-localpv": {
+		Repo:      "https://openebs.github.io/dynamic-localpv-provisioner",
+		Chart:     "localpv-provisioner",
+		Version:   "3.3.0",
+		Namespace: "openebs",
+		Values:    []string{"hostpathClass.isDefaultClass=true"},
+	},
+}
+
+// checkHelm makes sure the helm binary which installs the Helm chart addons is available.
+func checkHelm() error {
+	if _, err := exec.LookPath("helm"); err != nil {
+		return fmt.Errorf("helm is required to manage Helm chart addons, install it from https://helm.sh/docs/intro/install/: %s", err)
+	}
+	return nil
+}
+
+func addonNames() []string {
+	var names []string
+	for name := range addonCatalog {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// installHelmAddon installs a catalog addon by name, or a local chart archive by path, into the nested cluster.
+func installHelmAddon(kubeconfig *clientcmdapi.Config, nameOrPath string) error {
+	name := nameOrPath
+	helmArgs := []string{"upgrade", "--install"}
+	if addon, ok := addonCatalog[nameOrPath]; ok {
+		helmArgs = append(helmArgs, name, addon.Chart, "--repo", addon.Repo, "--version", addon.Version, "--namespace", addon.Namespace)
+		for _, value := range addon.Values {
+			helmArgs = append(helmArgs, "--set", value)
+		}
+	} else {
+		if _, err := os.Stat(nameOrPath); err != nil {
+			return fmt.Errorf("addon %q is neither in the catalog nor a local chart archive", nameOrPath)
+		}
+		name = helmReleaseName(nameOrPath)
+		helmArgs = append(helmArgs, name, nameOrPath, "--namespace", name)
+	}
+	helmArgs = append(helmArgs, "--create-namespace")
+
+	return withKubeconfigFile(kubeconfig, func(kubeconfigFilePath string) error {
+		return runCommand(exec.Command("helm", append(helmArgs, "--kubeconfig", kubeconfigFilePath)...))
+	})
+}
+
+func uninstallHelmAddon(kubeconfig *clientcmdapi.Config, name string) error {
+	namespace := name
+	if addon, ok := addonCatalog[name]; ok {
+		namespace = addon.Namespace
+	}
+	return withKubeconfigFile(kubeconfig, func(kubeconfigFilePath string) error {
+		return runCommand(exec.Command("helm", "uninstall", name, "--namespace", namespace, "--kubeconfig", kubeconfigFilePath))
+	})
+}
+
+// helmReleaseName returns the release name of a local chart archive, e.g. "foo" for "charts/foo-1.2.3.tgz".
+func helmReleaseName(chartPath string) string {
+	name := strings.TrimSuffix(filepath.Base(chartPath), filepath.Ext(chartPath))
+	// The version starts at the first dash followed by a digit, and may contain dashes itself, e.g. "1.2.3-rc.1".
+	for i := 1; i < len(name)-1; i++ {
+		if name[i] == '-' && name[i+1] >= '0' && name[i+1] <= '9' {
+			return name[:i]
+		}
+	}
+	return name
+}
+
+type helmRelease struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Chart     string `json:"chart"`
+	Status    string `json:"status"`
+}
+
+func listHelmAddons(kubeconfig *clientcmdapi.Config) ([]helmRelease, error) {
+	var releases []helmRelease
+	err := withKubeconfigFile(kubeconfig, func(kubeconfigFilePath string) error {
+		buf := &bytes.Buffer{}
+		listCmd := exec.Command("helm", "list", "--all-namespaces", "--output", "json", "--kubeconfig", kubeconfigFilePath)
+		listCmd.Stdout = buf
+		if err := runCommand(listCmd); err != nil {
+			return err
+		}
+		return json.Unmarshal(buf.Bytes(), &releases)
+	})
+	return releases, err
+}
+
+// readAddonManifests concatenates all YAML manifests in the directory in lexical order.
+func readAddonManifests(dir string) ([]byte, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileNames []string
+	for _, entry := range entries {
+		if ext := filepath.Ext(entry.Name()); !entry.IsDir() && (ext == ".yaml" || ext == ".yml") {
+			fileNames = append(fileNames, entry.Name())
+		}
+	}
+	sort.Strings(fileNames)
+	if len(fileNames) == 0 {
+		return nil, fmt.Errorf("no manifests found in %q", dir)
+	}
+
+	buf := &bytes.Buffer{}
+	for _, fileName := range fileNames {
+		data, err := os.ReadFile(filepath.Join(dir, fileName))
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(buf, "---\n%s\n", data)
+	}
+	return buf.Bytes(), nil
+}
+
+// applyManifestsAddon applies the manifests in the directory to the nested cluster through a ClusterResourceSet.
+func applyManifestsAddon(namespace string, clusterName string, dir string) error {
+	manifests, err := readAddonManifests(dir)
+	if err != nil {
+		return fmt.Errorf("read addon manifests: %s", err)
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(absDir)
+
+	objs := newClusterResourceSet(namespace, clusterName, fmt.Sprintf("addon-%s", name), manifests)
+	for _, obj := range objs {
+		labels := obj.GetLabels()
+		labels[addonLabel] = name
+		obj.SetLabels(labels)
+	}
+	return applyObjectsServerSide(objs)
+}
+
+// deleteManifestsAddon deletes the resources of a manifests addon from the nested cluster along with its CRS.
+func deleteManifestsAddon(kubeconfig *clientcmdapi.Config, namespace string, clusterName string, name string) error {
+	resourceName := fmt.Sprintf("%s-addon-%s", clusterName, name)
+	clusterResourceSet, err := getObject("clusterresourcesets.addons.cluster.x-k8s.io", resourceName, "--namespace", namespace)
+	if err != nil {
+		return fmt.Errorf("get addon ClusterResourceSet: %s", err)
+	}
+	resources, _, _ := unstructured.NestedSlice(clusterResourceSet.Object, "spec", "resources")
+	var configMapNames []string
+	for _, resource := range resources {
+		resource, _ := resource.(map[string]interface{})
+		if configMapName, _ := resource["name"].(string); resource["kind"] == "ConfigMap" && configMapName != "" {
+			configMapNames = append(configMapNames, configMapName)
+		}
+	}
+
+	for _, configMapName := range configMapNames {
+		configMap, err := getObject("configmap", configMapName, "--namespace", namespace)
+		if err != nil {
+			return fmt.Errorf("get addon manifests: %s", err)
+		}
+		data, _, _ := unstructured.NestedStringMap(configMap.Object, "data")
+		for _, manifests := range data {
+			if err := runNestedKubectl(kubeconfig, strings.NewReader(manifests), "delete", "-f", "-", "--ignore-not-found"); err != nil {
+				return fmt.Errorf("delete addon resources: %s", err)
+			}
+		}
+	}
+
+	if err := runCommand(exec.Command("kubectl", "delete", "clusterresourcesets.addons.cluster.x-k8s.io", resourceName, "--namespace", namespace, "--ignore-not-found")); err != nil {
+		return fmt.Errorf("delete addon ClusterResourceSet: %s", err)
+	}
+	if len(configMapNames) > 0 {
+		if err := runCommand(exec.Command("kubectl", append([]string{"delete", "configmaps", "--namespace", namespace, "--ignore-not-found"}, configMapNames...)...)); err != nil {
+			return fmt.Errorf("delete addon manifests: %s", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestHelmReleaseName(t *testing.T) {
+	tests := []struct {
+		chartPath string
+		want      string
+	}{{
+		chartPath: "charts/foo-1.2.3.tgz",
+		want:      "foo",
+	}, {
+		chartPath: "./charts/my-app-0.1.0.tgz",
+		want:      "my-app",
+	}, {
+		chartPath: "my-app-1.2.3-rc.1.tgz",
+		want:      "my-app",
+	}, {
+		chartPath: "/tmp/foo.tgz",
+		want:      "foo",
+	}, {
+		chartPath: "nginx-k8s.tgz",
+		want:      "nginx-k8s",
+	}, {
+		chartPath: "foo-1.2.3",
+		want:      "foo",
+	}}
+
+	for _, tt := range tests {
+		if got := helmReleaseName(tt.chartPath); got != tt.want {
+			t.Errorf("helmReleaseName(%q) = %q, want %q", tt.chartPath, got, tt.want)
+		}
+	}
+}
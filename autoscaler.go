@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	autoscalerMinSizeAnnotation        = "cluster.x-k8s.io/cluster-api-autoscaler-node-group-min-size"
+	autoscalerMaxSizeAnnotation        = "cluster.x-k8s.io/cluster-api-autoscaler-node-group-max-size"
+	autoscalerCPUCapacityAnnotation    = "capacity.cluster-autoscaler.kubernetes.io/cpu"
+	autoscalerMemoryCapacityAnnotation = "capacity.cluster-autoscaler.kubernetes.io/memory"
+)
+
+func renderClusterAutoscaler(namespace string, clusterName string) (*bytes.Buffer, error) {
+	clusterAutoscalerTemplateData := struct {
+		Name      string
+		Namespace string
+		Version   string
+	}{
+		Name:      clusterName,
+		Namespace: namespace,
+		Version:   ClusterAutoscalerVersion,
+	}
+
+	clusterAutoscalerDataBuf := &bytes.Buffer{}
+	if err := template.Must(template.New("cluster-autoscaler.yaml").ParseFS(templatesFS, "templates/cluster-autoscaler.yaml")).Execute(clusterAutoscalerDataBuf, clusterAutoscalerTemplateData); err != nil {
+		return nil, err
+	}
+	return clusterAutoscalerDataBuf, nil
+}
+
+// enableAutoscaler annotates the MachineDeployments with node group sizes and deploys cluster-autoscaler.
+func enableAutoscaler(namespace string, clusterName string, machineDeploymentNames []string, minSize int, maxSize int) error {
+	if minSize < 0 || maxSize < minSize || maxSize == 0 {
+		return fmt.Errorf("invalid autoscaling range: min %d, max %d", minSize, maxSize)
+	}
+
+	for _, name := range machineDeploymentNames {
+		annotations := []string{
+			fmt.Sprintf("%s=%d", autoscalerMinSizeAnnotation, minSize),
+			fmt.Sprintf("%s=%d", autoscalerMaxSizeAnnotation, maxSize),
+		}
+		// Without nodes to learn from, the autoscaler needs the machine capacity to scale a node group from zero.
+		if minSize == 0 {
+			cpu, memory, err := getMachineCapacity(namespace, name)
+			if err != nil {
+				return err
+			}
+			annotations = append(annotations,
+				fmt.Sprintf("%s=%s", autoscalerCPUCapacityAnnotation, cpu),
+				fmt.Sprintf("%s=%s", autoscalerMemoryCapacityAnnotation, memory))
+		}
+		if err := runCommand(exec.Command("kubectl", append([]string{"annotate", "machinedeployment.cluster.x-k8s.io", name, "--namespace", namespace, "--overwrite"}, annotations...)...)); err != nil {
+			return fmt.Errorf("annotate MachineDeployment %q: %s", name, err)
+		}
+	}
+
+	clusterAutoscalerDataBuf, err := renderClusterAutoscaler(namespace, clusterName)
+	if err != nil {
+		return err
+	}
+	applyCmd := exec.Command("kubectl", "apply", "-f", "-")
+	applyCmd.Stdin = clusterAutoscalerDataBuf
+	if err := runCommand(applyCmd); err != nil {
+		return fmt.Errorf("deploy cluster-autoscaler: %s", err)
+	}
+	return nil
+}
+
+// getMachineCapacity returns the CPU cores and memory size of the machines of a MachineDeployment.
+func getMachineCapacity(namespace string, machineDeploymentName string) (string, string, error) {
+	machineDeployment, err := getObject("machinedeployment.cluster.x-k8s.io", machineDeploymentName, "--namespace", namespace)
+	if err != nil {
+		return "", "", fmt.Errorf("get MachineDeployment %q: %s", machineDeploymentName, err)
+	}
+	machineTemplateName, _, _ := unstructured.NestedString(machineDeployment.Object, "spec", "template", "spec", "infrastructureRef", "name")
+	machineTemplate, err := getObject("virtinkmachinetemplate.infrastructure.cluster.x-k8s.io", machineTemplateName, "--namespace", namespace)
+	if err != nil {
+		return "", "", fmt.Errorf("get VirtinkMachineTemplate %q: %s", machineTemplateName, err)
+	}
+
+	instancePath := []string{"spec", "template", "spec", "virtualMachineTemplate", "spec", "instance"}
+	cpu := int64(1)
+	for _, field := range []string{"sockets", "coresPerSocket", "threadsPerCore"} {
+		if count, ok, _ := unstructured.NestedInt64(machineTemplate.Object, append(instancePath, "cpu", field)...); ok && count > 0 {
+			cpu *= count
+		}
+	}
+	memory, _, _ := unstructured.NestedString(machineTemplate.Object, append(instancePath, "memory", "size")...)
+	if memory == "" {
+		return "", "", fmt.Errorf("VirtinkMachineTemplate %q has no memory size", machineTemplateName)
+	}
+	return fmt.Sprint(cpu), memory, nil
+}
+
+// disableAutoscaler removes the node group size annotations, and cluster-autoscaler if removeDeployment is set.
+func disableAutoscaler(namespace string, clusterName string, machineDeploymentNames []string, removeDeployment bool) error {
+	for _, name := range machineDeploymentNames {
+		if err := runCommand(exec.Command("kubectl", "annotate", "machinedeployment.cluster.x-k8s.io", name, "--namespace", namespace,
+			fmt.Sprintf("%s-", autoscalerMinSizeAnnotation),
+			fmt.Sprintf("%s-", autoscalerMaxSizeAnnotation),
+			fmt.Sprintf("%s-", autoscalerCPUCapacityAnnotation),
+			fmt.Sprintf("%s-", autoscalerMemoryCapacityAnnotation))); err != nil {
+			return fmt.Errorf("annotate MachineDeployment %q: %s", name, err)
+		}
+	}
+
+	if removeDeployment {
+		if err := deleteClusterAutoscaler(namespace, clusterName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteClusterAutoscaler(namespace string, clusterName string) error {
+	clusterAutoscalerDataBuf, err := renderClusterAutoscaler(namespace, clusterName)
+	if err != nil {
+		return err
+	}
+	deleteCmd := exec.Command("kubectl", "delete", "-f", "-", "--ignore-not-found")
+	deleteCmd.Stdin = clusterAutoscalerDataBuf
+	if err := runCommand(deleteCmd); err != nil {
+		return fmt.Errorf("delete cluster-autoscaler: %s", err)
+	}
+	return nil
+}
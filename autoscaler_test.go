@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestRenderClusterAutoscaler(t *testing.T) {
+	buf, err := renderClusterAutoscaler("tenant", "foo")
+	if err != nil {
+		t.Fatalf("renderClusterAutoscaler() error = %v", err)
+	}
+	objs, err := decodeObjects(buf)
+	if err != nil {
+		t.Fatalf("decode cluster-autoscaler: %v", err)
+	}
+
+	kinds := map[string]bool{}
+	for _, obj := range objs {
+		kinds[obj.GetKind()] = true
+		if obj.GetNamespace() != "tenant" {
+			t.Errorf("%s %s is in namespace %q, want %q", obj.GetKind(), obj.GetName(), obj.GetNamespace(), "tenant")
+		}
+		if obj.GetKind() == "RoleBinding" {
+			if kind, _, _ := unstructured.NestedString(obj.Object, "roleRef", "kind"); kind != "Role" {
+				t.Errorf("RoleBinding refers to a %s, want a Role", kind)
+			}
+		}
+		if obj.GetKind() == "Deployment" {
+			containers, _, _ := unstructured.NestedSlice(obj.Object, "spec", "template", "spec", "containers")
+			args, _, _ := unstructured.NestedStringSlice(containers[0].(map[string]interface{}), "args")
+			found := false
+			for _, arg := range args {
+				if arg == "--node-group-auto-discovery=clusterapi:namespace=tenant,clusterName=foo" {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("cluster-autoscaler args = %v, want node group auto discovery in the cluster namespace", args)
+			}
+		}
+	}
+	for _, kind := range []string{"ServiceAccount", "Role", "RoleBinding", "Deployment"} {
+		if !kinds[kind] {
+			t.Errorf("renderClusterAutoscaler() has no %s", kind)
+		}
+	}
+	for _, kind := range []string{"ClusterRole", "ClusterRoleBinding"} {
+		if kinds[kind] {
+			t.Errorf("renderClusterAutoscaler() has a %s", kind)
+		}
+	}
+}
@@ -0,0 +1,597 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// clonedMachineResetCommands wipe the Kubernetes state and identity of the source machine from a cloned rootfs.
+var clonedMachineResetCommands = []interface{}{
+	"kubeadm reset --force",
+	"rm -rf /etc/cni/net.d",
+	"rm -f /etc/machine-id && systemd-machine-id-setup",
+}
+
+// clonedAnnotationsToDrop are bound to the source cluster's endpoint or addons and don't apply to the clone.
+var clonedAnnotationsToDrop = []string{
+	"kubectl.kubernetes.io/last-applied-configuration",
+	controlPlaneHostAnnotation,
+	controlPlanePortAnnotation,
+	endpointHostAnnotation,
+	autoscalerMinSizeAnnotation,
+	autoscalerMaxSizeAnnotation,
+	autoscalerCPUCapacityAnnotation,
+	autoscalerMemoryCapacityAnnotation,
+}
+
+type cloneOptions struct {
+	SharedIPPoolName     string
+	MachineAddressRanges []*ipPoolRange
+	MachineIPPoolConfig  *ipPoolConfig
+}
+
+// clonedRootfs is the rootfs DataVolume of a source machine the machines of a cloned template are cloned from.
+type clonedRootfs struct {
+	MachineTemplate string
+	ClaimTemplate   string
+	VM              string
+	DataVolume      string
+}
+
+// clonedLabelKeys are the labels whose values refer to the source cluster or its objects.
+var clonedLabelKeys = map[string]bool{
+	clusterNameLabel:                   true,
+	"cluster.x-k8s.io/deployment-name": true,
+	"knest.smartx.com/control-plane":   true,
+}
+
+// clonedReferenceFields are the fields referring to the source cluster or its objects by name.
+var clonedReferenceFields = map[string][][]string{
+	"Cluster": {
+		{"spec", "controlPlaneRef", "name"},
+		{"spec", "infrastructureRef", "name"},
+	},
+	"KubeadmControlPlane": {
+		{"spec", "machineTemplate", "infrastructureRef", "name"},
+	},
+	"MachineDeployment": {
+		{"spec", "clusterName"},
+		{"spec", "template", "spec", "clusterName"},
+		{"spec", "template", "spec", "bootstrap", "configRef", "name"},
+		{"spec", "template", "spec", "infrastructureRef", "name"},
+	},
+	"MachineHealthCheck": {
+		{"spec", "clusterName"},
+	},
+}
+
+func renameClusterString(s string, srcName string, dstName string) string {
+	if s == srcName {
+		return dstName
+	}
+	if strings.HasPrefix(s, srcName+"-") {
+		return dstName + strings.TrimPrefix(s, srcName)
+	}
+	return s
+}
+
+// renameClusterLabels renames the values of clonedLabelKeys in all "labels" and "matchLabels" within the value.
+func renameClusterLabels(value interface{}, srcName string, dstName string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if labels, ok := item.(map[string]interface{}); ok && (key == "labels" || key == "matchLabels") {
+				for labelKey, labelValue := range labels {
+					if s, ok := labelValue.(string); ok && clonedLabelKeys[labelKey] {
+						labels[labelKey] = renameClusterString(s, srcName, dstName)
+					}
+				}
+				continue
+			}
+			renameClusterLabels(item, srcName, dstName)
+		}
+	case []interface{}:
+		for _, item := range v {
+			renameClusterLabels(item, srcName, dstName)
+		}
+	}
+}
+
+// renameClusterListField renames the field at itemFields of each item of the list at listFields.
+func renameClusterListField(obj *unstructured.Unstructured, srcName string, dstName string, listFields []string, itemFields ...string) error {
+	items, found, err := unstructured.NestedSlice(obj.Object, listFields...)
+	if !found || err != nil {
+		return err
+	}
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if value, found, _ := unstructured.NestedString(m, itemFields...); found {
+			if err := unstructured.SetNestedField(m, renameClusterString(value, srcName, dstName), itemFields...); err != nil {
+				return err
+			}
+		}
+	}
+	return unstructured.SetNestedSlice(obj.Object, items, listFields...)
+}
+
+// newClonedObject copies an object of the source cluster, renaming its references to the source cluster.
+func newClonedObject(obj *unstructured.Unstructured, srcName string, dstName string) (*unstructured.Unstructured, error) {
+	src := obj.DeepCopy()
+	cloned := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	cloned.SetAPIVersion(src.GetAPIVersion())
+	cloned.SetKind(src.GetKind())
+	cloned.SetNamespace(src.GetNamespace())
+	cloned.SetName(renameClusterString(src.GetName(), srcName, dstName))
+
+	if labels := src.GetLabels(); len(labels) > 0 {
+		for key, value := range labels {
+			if clonedLabelKeys[key] {
+				labels[key] = renameClusterString(value, srcName, dstName)
+			}
+		}
+		cloned.SetLabels(labels)
+	}
+	if annotations := src.GetAnnotations(); len(annotations) > 0 {
+		for _, key := range clonedAnnotationsToDrop {
+			delete(annotations, key)
+		}
+		if len(annotations) > 0 {
+			cloned.SetAnnotations(annotations)
+		}
+	}
+	for _, key := range []string{"spec", "data", "binaryData"} {
+		if value, ok := src.Object[key]; ok {
+			cloned.Object[key] = value
+		}
+	}
+	if cloned.GetKind() == "ConfigMap" {
+		return cloned, nil
+	}
+
+	renameClusterLabels(cloned.Object["spec"], srcName, dstName)
+	for _, fields := range clonedReferenceFields[cloned.GetKind()] {
+		if value, found, _ := unstructured.NestedString(cloned.Object, fields...); found {
+			if err := unstructured.SetNestedField(cloned.Object, renameClusterString(value, srcName, dstName), fields...); err != nil {
+				return nil, err
+			}
+		}
+	}
+	switch cloned.GetKind() {
+	case "VirtinkMachineTemplate":
+		if err := renameClusterListField(cloned, srcName, dstName, []string{"spec", "template", "spec", "volumeClaimTemplates"}, "metadata", "name"); err != nil {
+			return nil, err
+		}
+		if err := renameClusterListField(cloned, srcName, dstName, []string{"spec", "template", "spec", "virtualMachineTemplate", "spec", "volumes"}, "dataVolume", "volumeName"); err != nil {
+			return nil, err
+		}
+	case "ClusterResourceSet":
+		if err := renameClusterListField(cloned, srcName, dstName, []string{"spec", "resources"}, "name"); err != nil {
+			return nil, err
+		}
+	}
+	return cloned, nil
+}
+
+// getRootfsClaimTemplate returns the first non-blank volume claim template and the VM volume using it.
+func getRootfsClaimTemplate(machineTemplate *unstructured.Unstructured) (string, string, error) {
+	claimTemplates, _, _ := unstructured.NestedSlice(machineTemplate.Object, "spec", "template", "spec", "volumeClaimTemplates")
+	volumes, _, _ := unstructured.NestedSlice(machineTemplate.Object, "spec", "template", "spec", "virtualMachineTemplate", "spec", "volumes")
+	for _, claimTemplate := range claimTemplates {
+		c, _ := claimTemplate.(map[string]interface{})
+		if _, blank, _ := unstructured.NestedMap(c, "spec", "source", "blank"); blank {
+			continue
+		}
+		claimName, _, _ := unstructured.NestedString(c, "metadata", "name")
+		for _, volume := range volumes {
+			v, _ := volume.(map[string]interface{})
+			if dataVolumeName, _, _ := unstructured.NestedString(v, "dataVolume", "volumeName"); dataVolumeName == claimName {
+				volumeName, _, _ := unstructured.NestedString(v, "name")
+				return claimName, volumeName, nil
+			}
+		}
+	}
+	return "", "", fmt.Errorf("no rootfs DataVolume found in VirtinkMachineTemplate %q, only persistent clusters can be cloned", machineTemplate.GetName())
+}
+
+// findClonedRootfs picks a running machine of the VirtinkMachineTemplate to clone the rootfs from.
+func findClonedRootfs(machineTemplate *unstructured.Unstructured, machineVMs []*machineVM) (*clonedRootfs, error) {
+	claimName, volumeName, err := getRootfsClaimTemplate(machineTemplate)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range machineVMs {
+		if m.VM == nil || m.VirtinkMachine.GetAnnotations()["cluster.x-k8s.io/cloned-from-name"] != machineTemplate.GetName() {
+			continue
+		}
+		volumes, _, _ := unstructured.NestedSlice(m.VM.Object, "spec", "volumes")
+		for _, volume := range volumes {
+			v, _ := volume.(map[string]interface{})
+			if name, _, _ := unstructured.NestedString(v, "name"); name != volumeName {
+				continue
+			}
+			if dataVolumeName, _, _ := unstructured.NestedString(v, "dataVolume", "volumeName"); dataVolumeName != "" {
+				return &clonedRootfs{
+					MachineTemplate: machineTemplate.GetName(),
+					ClaimTemplate:   claimName,
+					VM:              m.VM.GetName(),
+					DataVolume:      dataVolumeName,
+				}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no machine with a rootfs DataVolume found for VirtinkMachineTemplate %q", machineTemplate.GetName())
+}
+
+// getClusterObjectsToClone returns the Cluster and the objects defining it, with the Cluster first.
+func getClusterObjectsToClone(namespace string, clusterName string) ([]*unstructured.Unstructured, error) {
+	cluster, err := getObject("clusters.cluster.x-k8s.io", clusterName, "--namespace", namespace)
+	if err != nil {
+		return nil, fmt.Errorf("get cluster: %s", err)
+	}
+	infraClusterName, _, _ := unstructured.NestedString(cluster.Object, "spec", "infrastructureRef", "name")
+	virtinkCluster, err := getObject("virtinkclusters.infrastructure.cluster.x-k8s.io", infraClusterName, "--namespace", namespace)
+	if err != nil {
+		return nil, fmt.Errorf("get VirtinkCluster: %s", err)
+	}
+	controlPlane, err := getControlPlane(namespace, clusterName)
+	if err != nil {
+		return nil, err
+	}
+	machineDeployments, err := getMachineDeployments(namespace, clusterName)
+	if err != nil {
+		return nil, err
+	}
+	objs := []*unstructured.Unstructured{cluster, virtinkCluster, controlPlane}
+
+	machineTemplateName, _, _ := unstructured.NestedString(controlPlane.Object, "spec", "machineTemplate", "infrastructureRef", "name")
+	machineTemplateNames := []string{machineTemplateName}
+	for _, machineDeployment := range machineDeployments {
+		objs = append(objs, machineDeployment)
+		machineTemplateName, _, _ := unstructured.NestedString(machineDeployment.Object, "spec", "template", "spec", "infrastructureRef", "name")
+		machineTemplateNames = append(machineTemplateNames, machineTemplateName)
+		configTemplateName, _, _ := unstructured.NestedString(machineDeployment.Object, "spec", "template", "spec", "bootstrap", "configRef", "name")
+		configTemplate, err := getObject("kubeadmconfigtemplates.bootstrap.cluster.x-k8s.io", configTemplateName, "--namespace", namespace)
+		if err != nil {
+			return nil, fmt.Errorf("get KubeadmConfigTemplate: %s", err)
+		}
+		objs = append(objs, configTemplate)
+	}
+	for _, name := range machineTemplateNames {
+		machineTemplate, err := getObject("virtinkmachinetemplates.infrastructure.cluster.x-k8s.io", name, "--namespace", namespace)
+		if err != nil {
+			return nil, fmt.Errorf("get VirtinkMachineTemplate: %s", err)
+		}
+		objs = append(objs, machineTemplate)
+	}
+
+	machineHealthChecks, err := getClusterOwnedObjects("machinehealthchecks.cluster.x-k8s.io", namespace, clusterName)
+	if err != nil {
+		return nil, fmt.Errorf("get MachineHealthChecks: %s", err)
+	}
+	objs = append(objs, machineHealthChecks...)
+
+	clusterResourceSets, err := getObjects("clusterresourcesets.addons.cluster.x-k8s.io", "--namespace", namespace, "--selector", fmt.Sprintf("%s=%s", clusterNameLabel, clusterName))
+	if err != nil {
+		return nil, fmt.Errorf("get ClusterResourceSets: %s", err)
+	}
+	for _, clusterResourceSet := range clusterResourceSets {
+		objs = append(objs, clusterResourceSet)
+		resources, _, _ := unstructured.NestedSlice(clusterResourceSet.Object, "spec", "resources")
+		for _, resource := range resources {
+			r, _ := resource.(map[string]interface{})
+			if r["kind"] != "ConfigMap" {
+				continue
+			}
+			name, _ := r["name"].(string)
+			configMap, err := getObject("configmap", name, "--namespace", namespace)
+			if err != nil {
+				return nil, fmt.Errorf("get ConfigMap of ClusterResourceSet %q: %s", clusterResourceSet.GetName(), err)
+			}
+			objs = append(objs, configMap)
+		}
+	}
+	return objs, nil
+}
+
+// getIPAMPoolRanges returns the ranges and the configuration of a live pool of the given IPAM provider.
+func getIPAMPoolRanges(provider string, namespace string, name string) ([]*ipPoolRange, *ipPoolConfig, error) {
+	pool, err := getObject(ipamPoolResources[provider], name, "--namespace", namespace)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get IP pool %q: %s", name, err)
+	}
+	config := &ipPoolConfig{}
+	prefix, _, _ := unstructured.NestedInt64(pool.Object, "spec", "prefix")
+	config.Prefix = int(prefix)
+	config.Gateway, _, _ = unstructured.NestedString(pool.Object, "spec", "gateway")
+	config.DNSServers, _, _ = unstructured.NestedStringSlice(pool.Object, "spec", "dnsServers")
+
+	if provider == metal3IPAMProvider {
+		ranges, _, err := getIPPoolRanges(pool)
+		return ranges, config, err
+	}
+	addresses, _, _ := unstructured.NestedStringSlice(pool.Object, "spec", "addresses")
+	ranges, err := parseIPPoolRanges(addresses)
+	return ranges, config, err
+}
+
+// cloneCluster creates a new persistent cluster from the spec and machine rootfses of an existing one.
+func cloneCluster(namespace string, srcName string, dstName string, options *cloneOptions) error {
+	if err := checkClusterNotStopped(namespace, srcName); err != nil {
+		return err
+	}
+	dstClusterOutput, err := getCommandOutput(exec.Command("kubectl", "get", "clusters.cluster.x-k8s.io", dstName, "--namespace", namespace, "--ignore-not-found"))
+	if err != nil {
+		return fmt.Errorf("get cluster: %s", err)
+	}
+	if len(dstClusterOutput) > 0 {
+		return fmt.Errorf("cluster %q already exists", dstName)
+	}
+
+	srcObjs, err := getClusterObjectsToClone(namespace, srcName)
+	if err != nil {
+		return err
+	}
+	srcCluster := srcObjs[0]
+	provider := srcCluster.GetAnnotations()[ipamProviderAnnotation]
+	if provider == "" {
+		provider = metal3IPAMProvider
+	}
+	srcIPPoolName := srcCluster.GetAnnotations()[ipPoolAnnotation]
+	if srcIPPoolName == "" {
+		return fmt.Errorf("cluster %q is not a persistent cluster created by knest", srcName)
+	}
+
+	ipPoolName := dstName
+	switch {
+	case options.SharedIPPoolName != "":
+		if provider != metal3IPAMProvider {
+			return fmt.Errorf("--ip-pool is only supported by the %s IPAM provider", metal3IPAMProvider)
+		}
+		if _, _, err := getSharedIPPool(namespace, options.SharedIPPoolName); err != nil {
+			return err
+		}
+		ipPoolName = options.SharedIPPoolName
+	case len(options.MachineAddressRanges) > 0:
+		srcRanges, srcConfig, err := getIPAMPoolRanges(provider, namespace, srcIPPoolName)
+		if err != nil {
+			return err
+		}
+		var addrs []string
+		for _, r := range append(srcRanges, options.MachineAddressRanges...) {
+			addrs = append(addrs, r.String())
+		}
+		if _, err := parseIPPoolRanges(addrs); err != nil {
+			return fmt.Errorf("machine addresses of the clone conflict with those of cluster %q: %s", srcName, err)
+		}
+
+		config := options.MachineIPPoolConfig
+		if config.Prefix == 0 {
+			config.Prefix = srcConfig.Prefix
+		}
+		if config.Gateway == "" {
+			config.Gateway = srcConfig.Gateway
+		}
+		if len(config.DNSServers) == 0 && provider == metal3IPAMProvider {
+			config.DNSServers = srcConfig.DNSServers
+		}
+		if err := validateIPPoolConfig(options.MachineAddressRanges, config); err != nil {
+			return err
+		}
+		if err := validateIPAMConfig(provider, options.MachineAddressRanges, config); err != nil {
+			return err
+		}
+		if err := checkIPPoolRangesAgainstNodes(options.MachineAddressRanges); err != nil {
+			return err
+		}
+	case srcIPPoolName != srcName:
+		ipPoolName = srcIPPoolName
+	default:
+		return fmt.Errorf("cluster %q has its own IP pool, --machine-addresses or --ip-pool is required for the clone", srcName)
+	}
+
+	machineVMs, err := getClusterMachineVMs(namespace, srcName)
+	if err != nil {
+		return err
+	}
+	var rootfses []*clonedRootfs
+	var dstObjs []*unstructured.Unstructured
+	for _, obj := range srcObjs {
+		dstObj, err := newClonedObject(obj, srcName, dstName)
+		if err != nil {
+			return err
+		}
+		switch obj.GetKind() {
+		case "Cluster":
+			unstructured.RemoveNestedField(dstObj.Object, "spec", "controlPlaneEndpoint")
+			if err := unstructured.SetNestedField(dstObj.Object, true, "spec", "paused"); err != nil {
+				return err
+			}
+			annotations := dstObj.GetAnnotations()
+			if annotations == nil {
+				annotations = map[string]string{}
+			}
+			annotations[ipPoolAnnotation] = ipPoolName
+			annotations[ipamProviderAnnotation] = provider
+			dstObj.SetAnnotations(annotations)
+		case "VirtinkCluster":
+			unstructured.RemoveNestedField(dstObj.Object, "spec", "controlPlaneEndpoint")
+		case "KubeadmControlPlane":
+			if err := prependPreKubeadmCommands(dstObj, "spec", "kubeadmConfigSpec", "preKubeadmCommands"); err != nil {
+				return err
+			}
+		case "KubeadmConfigTemplate":
+			if err := prependPreKubeadmCommands(dstObj, "spec", "template", "spec", "preKubeadmCommands"); err != nil {
+				return err
+			}
+		case "VirtinkMachineTemplate":
+			rootfs, err := findClonedRootfs(obj, machineVMs)
+			if err != nil {
+				return err
+			}
+			rootfses = append(rootfses, rootfs)
+			if err := unstructured.SetNestedField(dstObj.Object, ipPoolName, "spec", "template", "spec", "ipPoolRef", "name"); err != nil {
+				return err
+			}
+			if err := setClonedRootfsSource(dstObj, renameClusterString(rootfs.ClaimTemplate, srcName, dstName), clonedRootfsDataVolumeName(dstObj.GetName())); err != nil {
+				return err
+			}
+		}
+		dstObjs = append(dstObjs, dstObj)
+	}
+
+	if ipPoolName == dstName {
+		ipPoolData, err := renderIPAMPool(provider, dstName, namespace, dstName, options.MachineAddressRanges, options.MachineIPPoolConfig)
+		if err != nil {
+			return err
+		}
+		createIPPoolCmd := exec.Command("kubectl", "apply", "-f", "-")
+		createIPPoolCmd.Stdin = bytes.NewReader(ipPoolData)
+		if err := runCommand(createIPPoolCmd); err != nil {
+			return fmt.Errorf("create IPPool: %s", err)
+		}
+	}
+
+	fmt.Printf("Creating paused cluster %q from cluster %q\n", dstName, srcName)
+	if err := applyObjectsServerSide(dstObjs); err != nil {
+		return fmt.Errorf("create cluster resources: %s", err)
+	}
+	if err := cloneRootfsDataVolumes(namespace, srcName, dstName, rootfses); err != nil {
+		return err
+	}
+	return setClusterPaused(namespace, dstName, false)
+}
+
+func clonedRootfsDataVolumeName(machineTemplateName string) string {
+	return fmt.Sprintf("%s-rootfs-source", machineTemplateName)
+}
+
+func prependPreKubeadmCommands(obj *unstructured.Unstructured, fields ...string) error {
+	commands, _, _ := unstructured.NestedSlice(obj.Object, fields...)
+	return unstructured.SetNestedSlice(obj.Object, append(append([]interface{}{}, clonedMachineResetCommands...), commands...), fields...)
+}
+
+// setClonedRootfsSource makes the rootfs volume claim template of a VirtinkMachineTemplate clone the given DataVolume.
+func setClonedRootfsSource(machineTemplate *unstructured.Unstructured, claimName string, dataVolumeName string) error {
+	claimTemplatesPath := []string{"spec", "template", "spec", "volumeClaimTemplates"}
+	claimTemplates, _, err := unstructured.NestedSlice(machineTemplate.Object, claimTemplatesPath...)
+	if err != nil {
+		return err
+	}
+	for _, claimTemplate := range claimTemplates {
+		c, _ := claimTemplate.(map[string]interface{})
+		if name, _, _ := unstructured.NestedString(c, "metadata", "name"); name != claimName {
+			continue
+		}
+		if err := unstructured.SetNestedMap(c, map[string]interface{}{
+			"pvc": map[string]interface{}{
+				"namespace": machineTemplate.GetNamespace(),
+				"name":      dataVolumeName,
+			},
+		}, "spec", "source"); err != nil {
+			return err
+		}
+	}
+	return unstructured.SetNestedSlice(machineTemplate.Object, claimTemplates, claimTemplatesPath...)
+}
+
+// cloneRootfsDataVolumes clones the rootfs of the source machines, halting the source VMs meanwhile.
+func cloneRootfsDataVolumes(namespace string, srcName string, dstName string, rootfses []*clonedRootfs) error {
+	dstCluster, err := getObject("clusters.cluster.x-k8s.io", dstName, "--namespace", namespace)
+	if err != nil {
+		return fmt.Errorf("get cluster: %s", err)
+	}
+
+	var dataVolumes []*unstructured.Unstructured
+	for _, rootfs := range rootfses {
+		srcDataVolume, err := getObject("datavolumes.cdi.kubevirt.io", rootfs.DataVolume, "--namespace", namespace)
+		if err != nil {
+			return fmt.Errorf("get DataVolume: %s", err)
+		}
+		pvc, _, _ := unstructured.NestedMap(srcDataVolume.Object, "spec", "pvc")
+		dstMachineTemplateName := renameClusterString(rootfs.MachineTemplate, srcName, dstName)
+		dataVolume := &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": "cdi.kubevirt.io/v1beta1",
+				"kind":       "DataVolume",
+				"metadata": map[string]interface{}{
+					"name":      clonedRootfsDataVolumeName(dstMachineTemplateName),
+					"namespace": namespace,
+					"labels": map[string]interface{}{
+						clusterNameLabel: dstName,
+					},
+					"annotations": map[string]interface{}{
+						"cdi.kubevirt.io/storage.bind.immediate.requested": "true",
+					},
+					"ownerReferences": []interface{}{
+						map[string]interface{}{
+							"apiVersion": "cluster.x-k8s.io/v1beta1",
+							"kind":       "Cluster",
+							"name":       dstName,
+							"uid":        string(dstCluster.GetUID()),
+						},
+					},
+				},
+				"spec": map[string]interface{}{
+					"pvc": pvc,
+					"source": map[string]interface{}{
+						"pvc": map[string]interface{}{
+							"namespace": namespace,
+							"name":      rootfs.DataVolume,
+						},
+					},
+				},
+			},
+		}
+		dataVolumes = append(dataVolumes, dataVolume)
+	}
+
+	paused, err := isClusterPaused(namespace, srcName)
+	if err != nil {
+		return err
+	}
+	if !paused {
+		if err := setClusterPaused(namespace, srcName, true); err != nil {
+			return err
+		}
+		defer setClusterPaused(namespace, srcName, false)
+	}
+
+	runPolicies := map[string]string{}
+	restartVMs := func() {
+		for vmName, runPolicy := range runPolicies {
+			setVMRunPolicy(namespace, vmName, runPolicy)
+		}
+		runPolicies = nil
+	}
+	defer restartVMs()
+	for _, rootfs := range rootfses {
+		if _, ok := runPolicies[rootfs.VM]; ok {
+			continue
+		}
+		runPolicy, err := stopVM(namespace, rootfs.VM)
+		if err != nil {
+			return err
+		}
+		runPolicies[rootfs.VM] = runPolicy
+	}
+
+	if err := applyObjects(dataVolumes); err != nil {
+		return fmt.Errorf("create rootfs DataVolumes: %s", err)
+	}
+	var cloneErr error
+	for i, dataVolume := range dataVolumes {
+		fmt.Printf("Cloning rootfs of VM %q into DataVolume %q\n", rootfses[i].VM, dataVolume.GetName())
+		if cloneErr = waitForDataVolumeReady(namespace, dataVolume.GetName(), dataVolumeTimeout); cloneErr != nil {
+			break
+		}
+	}
+	restartVMs()
+	return cloneErr
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestRenameClusterString(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{{
+		s:    "src",
+		want: "dst",
+	}, {
+		s:    "src-md-0",
+		want: "dst-md-0",
+	}, {
+		s:    "src-cp-rootfs",
+		want: "dst-cp-rootfs",
+	}, {
+		s:    "srcx-md-0",
+		want: "srcx-md-0",
+	}, {
+		s:    "my-src",
+		want: "my-src",
+	}, {
+		s:    "",
+		want: "",
+	}}
+
+	for _, tt := range tests {
+		if got := renameClusterString(tt.s, "src", "dst"); got != tt.want {
+			t.Errorf("renameClusterString(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestNewClonedObject(t *testing.T) {
+	tests := []struct {
+		name string
+		obj  map[string]interface{}
+		want map[string]interface{}
+	}{{
+		name: "MachineDeployment",
+		obj: map[string]interface{}{
+			"apiVersion": "cluster.x-k8s.io/v1beta1",
+			"kind":       "MachineDeployment",
+			"metadata": map[string]interface{}{
+				"name":            "src-md-0",
+				"namespace":       "default",
+				"uid":             "uid",
+				"resourceVersion": "1",
+				"labels": map[string]interface{}{
+					clusterNameLabel: "src",
+					"app":            "src",
+				},
+				"annotations": map[string]interface{}{
+					"kubectl.kubernetes.io/last-applied-configuration": "{}",
+					autoscalerMinSizeAnnotation:                        "0",
+					autoscalerMaxSizeAnnotation:                        "3",
+					autoscalerCPUCapacityAnnotation:                    "2",
+					autoscalerMemoryCapacityAnnotation:                 "4Gi",
+					"description":                                      "src-md-0",
+				},
+			},
+			"spec": map[string]interface{}{
+				"clusterName": "src",
+				"selector": map[string]interface{}{
+					"matchLabels": map[string]interface{}{
+						clusterNameLabel:                   "src",
+						"cluster.x-k8s.io/deployment-name": "src-md-0",
+					},
+				},
+				"template": map[string]interface{}{
+					"metadata": map[string]interface{}{
+						"labels": map[string]interface{}{
+							clusterNameLabel: "src",
+						},
+					},
+					"spec": map[string]interface{}{
+						"clusterName": "src",
+						"version":     "src-version",
+						"bootstrap": map[string]interface{}{
+							"configRef": map[string]interface{}{
+								"kind": "KubeadmConfigTemplate",
+								"name": "src-md-0",
+							},
+						},
+						"infrastructureRef": map[string]interface{}{
+							"kind": "VirtinkMachineTemplate",
+							"name": "src-md-0",
+						},
+					},
+				},
+			},
+			"status": map[string]interface{}{
+				"replicas": int64(1),
+			},
+		},
+		want: map[string]interface{}{
+			"apiVersion": "cluster.x-k8s.io/v1beta1",
+			"kind":       "MachineDeployment",
+			"metadata": map[string]interface{}{
+				"name":      "dst-md-0",
+				"namespace": "default",
+				"labels": map[string]interface{}{
+					clusterNameLabel: "dst",
+					"app":            "src",
+				},
+				"annotations": map[string]interface{}{
+					"description": "src-md-0",
+				},
+			},
+			"spec": map[string]interface{}{
+				"clusterName": "dst",
+				"selector": map[string]interface{}{
+					"matchLabels": map[string]interface{}{
+						clusterNameLabel:                   "dst",
+						"cluster.x-k8s.io/deployment-name": "dst-md-0",
+					},
+				},
+				"template": map[string]interface{}{
+					"metadata": map[string]interface{}{
+						"labels": map[string]interface{}{
+							clusterNameLabel: "dst",
+						},
+					},
+					"spec": map[string]interface{}{
+						"clusterName": "dst",
+						"version":     "src-version",
+						"bootstrap": map[string]interface{}{
+							"configRef": map[string]interface{}{
+								"kind": "KubeadmConfigTemplate",
+								"name": "dst-md-0",
+							},
+						},
+						"infrastructureRef": map[string]interface{}{
+							"kind": "VirtinkMachineTemplate",
+							"name": "dst-md-0",
+						},
+					},
+				},
+			},
+		},
+	}, {
+		name: "ClusterResourceSet",
+		obj: map[string]interface{}{
+			"apiVersion": "addons.cluster.x-k8s.io/v1beta1",
+			"kind":       "ClusterResourceSet",
+			"metadata": map[string]interface{}{
+				"name":      "src-cni",
+				"namespace": "default",
+			},
+			"spec": map[string]interface{}{
+				"clusterSelector": map[string]interface{}{
+					"matchLabels": map[string]interface{}{
+						clusterNameLabel: "src",
+					},
+				},
+				"resources": []interface{}{
+					map[string]interface{}{"kind": "ConfigMap", "name": "src-cni"},
+					map[string]interface{}{"kind": "Secret", "name": "shared"},
+				},
+			},
+		},
+		want: map[string]interface{}{
+			"apiVersion": "addons.cluster.x-k8s.io/v1beta1",
+			"kind":       "ClusterResourceSet",
+			"metadata": map[string]interface{}{
+				"name":      "dst-cni",
+				"namespace": "default",
+			},
+			"spec": map[string]interface{}{
+				"clusterSelector": map[string]interface{}{
+					"matchLabels": map[string]interface{}{
+						clusterNameLabel: "dst",
+					},
+				},
+				"resources": []interface{}{
+					map[string]interface{}{"kind": "ConfigMap", "name": "dst-cni"},
+					map[string]interface{}{"kind": "Secret", "name": "shared"},
+				},
+			},
+		},
+	}, {
+		name: "ConfigMap",
+		obj: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":      "src-cni",
+				"namespace": "default",
+			},
+			"data": map[string]interface{}{
+				"cni.yaml": "name: src",
+			},
+			"binaryData": map[string]interface{}{
+				"chart.tgz": "c3Jj",
+			},
+		},
+		want: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":      "dst-cni",
+				"namespace": "default",
+			},
+			"data": map[string]interface{}{
+				"cni.yaml": "name: src",
+			},
+			"binaryData": map[string]interface{}{
+				"chart.tgz": "c3Jj",
+			},
+		},
+	}}
+
+	for _, tt := range tests {
+		got, err := newClonedObject(&unstructured.Unstructured{Object: tt.obj}, "src", "dst")
+		if err != nil {
+			t.Errorf("%s: newClonedObject() error = %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got.Object, tt.want) {
+			t.Errorf("%s: newClonedObject() = %v, want %v", tt.name, got.Object, tt.want)
+		}
+	}
+}
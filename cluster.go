@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// getClusterOwnedObjects returns the objects belonging to a cluster by label or by owner reference.
+func getClusterOwnedObjects(resource string, namespace string, clusterName string) ([]*unstructured.Unstructured, error) {
+	objs, err := getObjects(resource, "--namespace", namespace, "--selector", fmt.Sprintf("%s=%s", clusterNameLabel, clusterName))
+	if err != nil {
+		return nil, err
+	}
+	if len(objs) > 0 {
+		return objs, nil
+	}
+
+	allObjs, err := getObjects(resource, "--namespace", namespace)
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range allObjs {
+		for _, ownerRef := range obj.GetOwnerReferences() {
+			if ownerRef.Kind == "Cluster" && ownerRef.Name == clusterName {
+				objs = append(objs, obj)
+				break
+			}
+		}
+	}
+	return objs, nil
+}
+
+func getControlPlane(namespace string, clusterName string) (*unstructured.Unstructured, error) {
+	controlPlanes, err := getClusterOwnedObjects("kubeadmcontrolplanes.controlplane.cluster.x-k8s.io", namespace, clusterName)
+	if err != nil {
+		return nil, fmt.Errorf("get KubeadmControlPlanes: %s", err)
+	}
+	if len(controlPlanes) != 1 {
+		return nil, fmt.Errorf("expect exactly 1 KubeadmControlPlane for cluster %q but found %d", clusterName, len(controlPlanes))
+	}
+	return controlPlanes[0], nil
+}
+
+func getMachineDeployments(namespace string, clusterName string) ([]*unstructured.Unstructured, error) {
+	machineDeployments, err := getClusterOwnedObjects("machinedeployments.cluster.x-k8s.io", namespace, clusterName)
+	if err != nil {
+		return nil, fmt.Errorf("get MachineDeployments: %s", err)
+	}
+	return machineDeployments, nil
+}
+
+func getReplicas(obj *unstructured.Unstructured) int64 {
+	replicas, _, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+	return replicas
+}
+
+// replicasTimeout bounds the wait for new machines, which includes provisioning their volumes and booting them.
+const replicasTimeout = 30 * time.Minute
+
+// waitForReplicasReady polls the given object until all of its desired replicas are up to date and ready.
+func waitForReplicasReady(resource string, namespace string, name string, timeout time.Duration) error {
+	var obj *unstructured.Unstructured
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(5 * time.Second) {
+		var err error
+		obj, err = getObject(resource, name, "--namespace", namespace)
+		if err != nil {
+			return err
+		}
+		if areReplicasReady(obj) {
+			return nil
+		}
+	}
+	readyReplicas, _, _ := unstructured.NestedInt64(obj.Object, "status", "readyReplicas")
+	return fmt.Errorf("timed out after %s with %d of %d replicas of %q ready", timeout, readyReplicas, getReplicas(obj), name)
+}
+
+func areReplicasReady(obj *unstructured.Unstructured) bool {
+	replicas := getReplicas(obj)
+	statusReplicas, _, _ := unstructured.NestedInt64(obj.Object, "status", "replicas")
+	readyReplicas, _, _ := unstructured.NestedInt64(obj.Object, "status", "readyReplicas")
+	updatedReplicas, _, _ := unstructured.NestedInt64(obj.Object, "status", "updatedReplicas")
+	return statusReplicas == replicas && readyReplicas == replicas && updatedReplicas == replicas
+}
+
+func isClusterPaused(namespace string, clusterName string) (bool, error) {
+	cluster, err := getObject("clusters.cluster.x-k8s.io", clusterName, "--namespace", namespace)
+	if err != nil {
+		return false, fmt.Errorf("get cluster: %s", err)
+	}
+	paused, _, _ := unstructured.NestedBool(cluster.Object, "spec", "paused")
+	return paused, nil
+}
+
+// setClusterPaused pauses or resumes the reconciliation of a cluster and all of its objects by Cluster API.
+func setClusterPaused(namespace string, clusterName string, paused bool) error {
+	if err := runCommand(exec.Command("kubectl", "patch", "clusters.cluster.x-k8s.io", clusterName, "--namespace", namespace,
+		"--type", "merge", "--patch", fmt.Sprintf("{\"spec\":{\"paused\":%t}}", paused))); err != nil {
+		return fmt.Errorf("set cluster paused to %t: %s", paused, err)
+	}
+	return nil
+}
+
+type machineVM struct {
+	Machine        string
+	VirtinkMachine *unstructured.Unstructured
+	VM             *unstructured.Unstructured
+}
+
+// getClusterMachineVMs returns the VirtinkMachines of a cluster along with their Machines and VMs.
+func getClusterMachineVMs(namespace string, clusterName string) ([]*machineVM, error) {
+	virtinkMachines, err := getClusterOwnedObjects("virtinkmachines.infrastructure.cluster.x-k8s.io", namespace, clusterName)
+	if err != nil {
+		return nil, fmt.Errorf("get VirtinkMachines: %s", err)
+	}
+	vms, err := getObjects("virtualmachines.virt.virtink.smartx.com", "--namespace", namespace)
+	if err != nil {
+		return nil, fmt.Errorf("get VirtualMachines: %s", err)
+	}
+
+	var machineVMs []*machineVM
+	for _, virtinkMachine := range virtinkMachines {
+		m := &machineVM{
+			VirtinkMachine: virtinkMachine,
+		}
+		for _, ownerRef := range virtinkMachine.GetOwnerReferences() {
+			if ownerRef.Kind == "Machine" {
+				m.Machine = ownerRef.Name
+			}
+		}
+		for _, vm := range vms {
+			for _, ownerRef := range vm.GetOwnerReferences() {
+				if ownerRef.Kind == "VirtinkMachine" && ownerRef.Name == virtinkMachine.GetName() {
+					m.VM = vm
+				}
+			}
+			if m.VM == nil && vm.GetName() == virtinkMachine.GetName() {
+				m.VM = vm
+			}
+		}
+		machineVMs = append(machineVMs, m)
+	}
+	return machineVMs, nil
+}
+
+// getVMDataVolumes returns the names of the DataVolumes used by a Virtink VM.
+func getVMDataVolumes(vm *unstructured.Unstructured) []string {
+	volumes, _, _ := unstructured.NestedSlice(vm.Object, "spec", "volumes")
+	var dataVolumes []string
+	for _, volume := range volumes {
+		v, _ := volume.(map[string]interface{})
+		if name, _, _ := unstructured.NestedString(v, "dataVolume", "volumeName"); name != "" {
+			dataVolumes = append(dataVolumes, name)
+		}
+	}
+	return dataVolumes
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestAreReplicasReady(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   map[string]interface{}
+		status map[string]interface{}
+		want   bool
+	}{{
+		name:   "all ready",
+		spec:   map[string]interface{}{"replicas": int64(3)},
+		status: map[string]interface{}{"replicas": int64(3), "readyReplicas": int64(3), "updatedReplicas": int64(3)},
+		want:   true,
+	}, {
+		name:   "scaled to zero",
+		spec:   map[string]interface{}{"replicas": int64(0)},
+		status: map[string]interface{}{},
+		want:   true,
+	}, {
+		name:   "not ready",
+		spec:   map[string]interface{}{"replicas": int64(3)},
+		status: map[string]interface{}{"replicas": int64(3), "readyReplicas": int64(2), "updatedReplicas": int64(3)},
+	}, {
+		name:   "not updated",
+		spec:   map[string]interface{}{"replicas": int64(3)},
+		status: map[string]interface{}{"replicas": int64(3), "readyReplicas": int64(3), "updatedReplicas": int64(1)},
+	}, {
+		name:   "scaling down",
+		spec:   map[string]interface{}{"replicas": int64(1)},
+		status: map[string]interface{}{"replicas": int64(2), "readyReplicas": int64(1), "updatedReplicas": int64(1)},
+	}, {
+		name: "no status",
+		spec: map[string]interface{}{"replicas": int64(1)},
+	}}
+
+	for _, tt := range tests {
+		obj := &unstructured.Unstructured{Object: map[string]interface{}{"spec": tt.spec}}
+		if tt.status != nil {
+			obj.Object["status"] = tt.status
+		}
+		if got := areReplicasReady(obj); got != tt.want {
+			t.Errorf("%s: areReplicasReady() = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path"
+	"text/template"
+)
+
+// cniVersions are the tested versions of the supported CNI plugins, whose manifests are rendered into templates/cni by hack/update-cni-manifests.sh.
+var cniVersions = map[string]string{
+	"calico": "v3.24.1",
+	"cilium": "1.12.2",
+}
+
+// cniEncapsulations are the supported encapsulation modes of each CNI plugin, the first of which is the default.
+var cniEncapsulations = map[string][]string{
+	"calico": {"ipip", "vxlan"},
+	"cilium": {"vxlan", "geneve"},
+}
+
+func validateCNI(cni string, encapsulation string) (string, error) {
+	encapsulations, ok := cniEncapsulations[cni]
+	if !ok {
+		return "", fmt.Errorf("unsupported CNI: %s", cni)
+	}
+	if encapsulation == "" {
+		return encapsulations[0], nil
+	}
+	for _, e := range encapsulations {
+		if e == encapsulation {
+			return encapsulation, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported encapsulation mode %q for CNI %q, supported modes are %v", encapsulation, cni, encapsulations)
+}
+
+func renderCNI(cni string, encapsulation string, podNetworkCIDRs []string) ([]byte, error) {
+	ipv4PodNetworkCIDR, ipv6PodNetworkCIDR := splitCIDRsByFamily(podNetworkCIDRs)
+	cniTemplateData := struct {
+		Encapsulation      string
+		IPv4PodNetworkCIDR string
+		IPv6PodNetworkCIDR string
+	}{
+		Encapsulation:      encapsulation,
+		IPv4PodNetworkCIDR: ipv4PodNetworkCIDR,
+		IPv6PodNetworkCIDR: ipv6PodNetworkCIDR,
+	}
+
+	manifestsFileName := fmt.Sprintf("templates/cni/%s-%s.yaml", cni, cniVersions[cni])
+	if !isTemplateFile(manifestsFileName) {
+		return nil, fmt.Errorf("manifests of %s %s are not embedded, render them by hack/update-cni-manifests.sh and rebuild knest", cni, cniVersions[cni])
+	}
+	cniTemplateFileNames := []string{manifestsFileName}
+	// The configuration of the plugin is rendered separately when it's not part of the manifests.
+	if configFileName := fmt.Sprintf("templates/cni-%s.yaml", cni); isTemplateFile(configFileName) {
+		cniTemplateFileNames = append(cniTemplateFileNames, configFileName)
+	}
+
+	cniDataBuf := &bytes.Buffer{}
+	for _, cniTemplateFileName := range cniTemplateFileNames {
+		cniDataBuf.WriteString("\n---\n")
+		if err := template.Must(template.New(path.Base(cniTemplateFileName)).ParseFS(templatesFS, cniTemplateFileName)).Execute(cniDataBuf, cniTemplateData); err != nil {
+			return nil, err
+		}
+	}
+	return cniDataBuf.Bytes(), nil
+}
+
+func isTemplateFile(name string) bool {
+	info, err := fs.Stat(templatesFS, name)
+	return err == nil && !info.IsDir()
+}
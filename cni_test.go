@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestValidateCNI(t *testing.T) {
+	tests := []struct {
+		cni           string
+		encapsulation string
+		want          string
+		wantErr       bool
+	}{{
+		cni:  "calico",
+		want: "ipip",
+	}, {
+		cni:  "cilium",
+		want: "vxlan",
+	}, {
+		cni:           "calico",
+		encapsulation: "vxlan",
+		want:          "vxlan",
+	}, {
+		cni:           "cilium",
+		encapsulation: "geneve",
+		want:          "geneve",
+	}, {
+		cni:           "cilium",
+		encapsulation: "ipip",
+		wantErr:       true,
+	}, {
+		cni:     "flannel",
+		wantErr: true,
+	}}
+
+	for _, tt := range tests {
+		got, err := validateCNI(tt.cni, tt.encapsulation)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateCNI(%q, %q) error = %v, wantErr %v", tt.cni, tt.encapsulation, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("validateCNI(%q, %q) = %q, want %q", tt.cni, tt.encapsulation, got, tt.want)
+		}
+	}
+}
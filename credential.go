@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"os/exec"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/validation/path"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	credentialLabel             = "knest.smartx.com/credential"
+	credentialUserAnnotation    = "knest.smartx.com/credential-user"
+	credentialExpiresAnnotation = "knest.smartx.com/credential-expires-at"
+)
+
+type scopedCredential struct {
+	User   string
+	Groups []string
+	TTL    time.Duration
+	Role   string
+	Type   string
+}
+
+// buildScopedKubeconfig builds a kubeconfig holding only the given credential, bound to its ClusterRole.
+func buildScopedKubeconfig(namespace string, clusterName string, credential *scopedCredential) (*clientcmdapi.Config, error) {
+	if err := validateScopedCredential(credential); err != nil {
+		return nil, err
+	}
+	adminKubeconfig, err := buildKubeconfig(namespace, clusterName)
+	if err != nil {
+		return nil, err
+	}
+	adminContext, ok := adminKubeconfig.Contexts[adminKubeconfig.CurrentContext]
+	if !ok {
+		return nil, fmt.Errorf("current context %q not found in kubeconfig", adminKubeconfig.CurrentContext)
+	}
+	cluster, ok := adminKubeconfig.Clusters[adminContext.Cluster]
+	if !ok {
+		return nil, fmt.Errorf("cluster %q not found in kubeconfig", adminContext.Cluster)
+	}
+	if err := pruneExpiredCredentialBindings(adminKubeconfig); err != nil {
+		return nil, err
+	}
+
+	authInfo := clientcmdapi.NewAuthInfo()
+	var subjects []interface{}
+	var expiresAt time.Time
+	switch credential.Type {
+	case "certificate":
+		certData, keyData, notAfter, err := signClientCertificate(namespace, clusterName, credential)
+		if err != nil {
+			return nil, fmt.Errorf("sign client certificate: %s", err)
+		}
+		authInfo.ClientCertificateData = certData
+		authInfo.ClientKeyData = keyData
+		expiresAt = notAfter
+		// Only the user is bound, so that deleting the binding revokes what it grants.
+		subjects = append(subjects, map[string]interface{}{
+			"apiGroup": "rbac.authorization.k8s.io",
+			"kind":     "User",
+			"name":     credential.User,
+		})
+	case "token":
+		token, err := createServiceAccountToken(adminKubeconfig, credential)
+		if err != nil {
+			return nil, fmt.Errorf("create ServiceAccount token: %s", err)
+		}
+		authInfo.Token = token
+		expiresAt = time.Now().Add(credential.TTL)
+		subjects = append(subjects, map[string]interface{}{
+			"kind":      "ServiceAccount",
+			"name":      credential.User,
+			"namespace": "default",
+		})
+	default:
+		return nil, fmt.Errorf("unsupported credential type: %s", credential.Type)
+	}
+
+	if credential.Role != "" {
+		if err := bindScopedCredential(adminKubeconfig, credential, subjects, expiresAt); err != nil {
+			return nil, err
+		}
+	}
+
+	name := fmt.Sprintf("%s@%s", credential.User, clusterName)
+	kubeconfig := clientcmdapi.NewConfig()
+	kubeconfig.Clusters[clusterName] = cluster
+	kubeconfig.AuthInfos[credential.User] = authInfo
+	kubeconfig.Contexts[name] = &clientcmdapi.Context{
+		Cluster:  clusterName,
+		AuthInfo: credential.User,
+	}
+	kubeconfig.CurrentContext = name
+	return kubeconfig, nil
+}
+
+// validateScopedCredential rejects reserved users and groups, whose permissions a ClusterRoleBinding can't revoke.
+func validateScopedCredential(credential *scopedCredential) error {
+	if credential.User == "" {
+		return fmt.Errorf("user is required")
+	}
+	if strings.HasPrefix(credential.User, "system:") {
+		return fmt.Errorf("invalid user %q: the \"system:\" prefix is reserved", credential.User)
+	}
+	switch credential.Type {
+	case "certificate":
+		for _, group := range credential.Groups {
+			if group == "" || strings.HasPrefix(group, "system:") {
+				return fmt.Errorf("invalid group %q: the \"system:\" prefix is reserved", group)
+			}
+		}
+	case "token":
+		if len(credential.Groups) > 0 {
+			return fmt.Errorf("groups are not supported by ServiceAccount tokens")
+		}
+		if errs := validation.IsDNS1123Subdomain(credential.User); len(errs) > 0 {
+			return fmt.Errorf("invalid ServiceAccount name %q: %s", credential.User, strings.Join(errs, ", "))
+		}
+	default:
+		return fmt.Errorf("unsupported credential type: %s", credential.Type)
+	}
+	if credential.Role != "" {
+		if errs := path.IsValidPathSegmentName(credential.Role); len(errs) > 0 {
+			return fmt.Errorf("invalid ClusterRole name %q: %s", credential.Role, strings.Join(errs, ", "))
+		}
+	}
+	if credential.TTL <= 0 {
+		return fmt.Errorf("invalid TTL: %s", credential.TTL)
+	}
+	return nil
+}
+
+// bindScopedCredential binds the subjects to the ClusterRole, recording the latest expiry of the binding.
+func bindScopedCredential(adminKubeconfig *clientcmdapi.Config, credential *scopedCredential, subjects []interface{}, expiresAt time.Time) error {
+	name := fmt.Sprintf("knest:%s:%s", credential.User, credential.Role)
+	bindings, err := getCredentialBindings(adminKubeconfig)
+	if err != nil {
+		return err
+	}
+	for _, binding := range bindings {
+		if binding.GetName() != name {
+			continue
+		}
+		if existing, err := time.Parse(time.RFC3339, binding.GetAnnotations()[credentialExpiresAnnotation]); err == nil && existing.After(expiresAt) {
+			expiresAt = existing
+		}
+	}
+
+	clusterRoleBinding := map[string]interface{}{
+		"apiVersion": "rbac.authorization.k8s.io/v1",
+		"kind":       "ClusterRoleBinding",
+		"metadata": map[string]interface{}{
+			"name": name,
+			"labels": map[string]interface{}{
+				credentialLabel: "true",
+			},
+			"annotations": map[string]interface{}{
+				credentialUserAnnotation:    credential.User,
+				credentialExpiresAnnotation: expiresAt.UTC().Format(time.RFC3339),
+			},
+		},
+		"roleRef": map[string]interface{}{
+			"apiGroup": "rbac.authorization.k8s.io",
+			"kind":     "ClusterRole",
+			"name":     credential.Role,
+		},
+		"subjects": subjects,
+	}
+	clusterRoleBindingData, err := marshalYAML(clusterRoleBinding)
+	if err != nil {
+		return err
+	}
+	if err := runNestedKubectl(adminKubeconfig, bytes.NewReader(clusterRoleBindingData), "apply", "-f", "-"); err != nil {
+		return fmt.Errorf("create ClusterRoleBinding: %s", err)
+	}
+	return nil
+}
+
+func getCredentialBindings(adminKubeconfig *clientcmdapi.Config) ([]unstructured.Unstructured, error) {
+	buf := &bytes.Buffer{}
+	if err := runNestedKubectlWithOutput(adminKubeconfig, nil, buf, "get", "clusterrolebindings", "--selector", fmt.Sprintf("%s=true", credentialLabel), "-o", "json"); err != nil {
+		return nil, fmt.Errorf("get ClusterRoleBindings: %s", err)
+	}
+	list := &unstructured.UnstructuredList{}
+	if err := list.UnmarshalJSON(buf.Bytes()); err != nil {
+		return nil, fmt.Errorf("decode ClusterRoleBindings: %s", err)
+	}
+	return list.Items, nil
+}
+
+func pruneExpiredCredentialBindings(adminKubeconfig *clientcmdapi.Config) error {
+	bindings, err := getCredentialBindings(adminKubeconfig)
+	if err != nil {
+		return err
+	}
+	for _, binding := range bindings {
+		expiresAt, err := time.Parse(time.RFC3339, binding.GetAnnotations()[credentialExpiresAnnotation])
+		if err != nil || expiresAt.After(time.Now()) {
+			continue
+		}
+		if err := runNestedKubectl(adminKubeconfig, nil, "delete", "clusterrolebinding", binding.GetName(), "--ignore-not-found"); err != nil {
+			return fmt.Errorf("delete expired ClusterRoleBinding %q: %s", binding.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// revokeScopedCredential deletes the ClusterRoleBindings and the ServiceAccount issued for a user.
+func revokeScopedCredential(namespace string, clusterName string, user string) error {
+	adminKubeconfig, err := buildKubeconfig(namespace, clusterName)
+	if err != nil {
+		return err
+	}
+	bindings, err := getCredentialBindings(adminKubeconfig)
+	if err != nil {
+		return err
+	}
+	for _, binding := range bindings {
+		if binding.GetAnnotations()[credentialUserAnnotation] != user {
+			continue
+		}
+		if err := runNestedKubectl(adminKubeconfig, nil, "delete", "clusterrolebinding", binding.GetName(), "--ignore-not-found"); err != nil {
+			return fmt.Errorf("delete ClusterRoleBinding %q: %s", binding.GetName(), err)
+		}
+	}
+	if err := runNestedKubectl(adminKubeconfig, nil, "delete", "serviceaccount", user, "--namespace", "default", "--ignore-not-found"); err != nil {
+		return fmt.Errorf("delete ServiceAccount %q: %s", user, err)
+	}
+	return nil
+}
+
+func signClientCertificate(namespace string, clusterName string, credential *scopedCredential) ([]byte, []byte, time.Time, error) {
+	caCertData, err := getSecretData(namespace, fmt.Sprintf("%s-ca", clusterName), "tls.crt")
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	caKeyData, err := getSecretData(namespace, fmt.Sprintf("%s-ca", clusterName), "tls.key")
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+
+	caCertBlock, _ := pem.Decode(caCertData)
+	if caCertBlock == nil {
+		return nil, nil, time.Time{}, fmt.Errorf("decode CA certificate")
+	}
+	caCert, err := x509.ParseCertificate(caCertBlock.Bytes)
+	if err != nil {
+		return nil, nil, time.Time{}, fmt.Errorf("parse CA certificate: %s", err)
+	}
+	caKeyBlock, _ := pem.Decode(caKeyData)
+	if caKeyBlock == nil {
+		return nil, nil, time.Time{}, fmt.Errorf("decode CA key")
+	}
+	caKey, err := parsePrivateKey(caKeyBlock.Bytes)
+	if err != nil {
+		return nil, nil, time.Time{}, fmt.Errorf("parse CA key: %s", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	now := time.Now()
+	notAfter := now.Add(credential.TTL)
+	if notAfter.After(caCert.NotAfter) {
+		notAfter = caCert.NotAfter
+	}
+	certTemplate := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName:   credential.User,
+			Organization: credential.Groups,
+		},
+		NotBefore:   now.Add(-5 * time.Minute),
+		NotAfter:    notAfter,
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, certTemplate, caCert, key.Public(), caKey)
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+
+	certData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	keyData := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certData, keyData, notAfter, nil
+}
+
+func parsePrivateKey(der []byte) (interface{}, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return x509.ParsePKCS8PrivateKey(der)
+}
+
+func createServiceAccountToken(adminKubeconfig *clientcmdapi.Config, credential *scopedCredential) (string, error) {
+	serviceAccountBuf := &bytes.Buffer{}
+	if err := runNestedKubectlWithOutput(adminKubeconfig, nil, serviceAccountBuf, "create", "serviceaccount", credential.User, "--namespace", "default", "--dry-run=client", "-o", "yaml"); err != nil {
+		return "", err
+	}
+	if err := runNestedKubectl(adminKubeconfig, serviceAccountBuf, "apply", "-f", "-"); err != nil {
+		return "", err
+	}
+
+	tokenBuf := &bytes.Buffer{}
+	if err := runNestedKubectlWithOutput(adminKubeconfig, nil, tokenBuf, "create", "token", credential.User, "--namespace", "default", "--duration", credential.TTL.String()); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(tokenBuf.String()), nil
+}
+
+func getSecretData(namespace string, name string, key string) ([]byte, error) {
+	encodedData, err := getCommandOutput(exec.Command("kubectl", "get", "secret", name, "--namespace", namespace, "-o", fmt.Sprintf("jsonpath={.data.%s}", strings.ReplaceAll(key, ".", "\\."))))
+	if err != nil {
+		return nil, fmt.Errorf("get secret %q: %s", name, err)
+	}
+	data, err := base64.StdEncoding.DecodeString(encodedData)
+	if err != nil {
+		return nil, fmt.Errorf("decode secret %q: %s", name, err)
+	}
+	return data, nil
+}
+
+// runNestedKubectl runs kubectl against a nested cluster with the given kubeconfig.
+func runNestedKubectl(kubeconfig *clientcmdapi.Config, stdin io.Reader, args ...string) error {
+	return runNestedKubectlWithOutput(kubeconfig, stdin, nil, args...)
+}
+
+func runNestedKubectlWithOutput(kubeconfig *clientcmdapi.Config, stdin io.Reader, stdout io.Writer, args ...string) error {
+	return withKubeconfigFile(kubeconfig, func(kubeconfigFilePath string) error {
+		cmd := exec.Command("kubectl", append([]string{"--kubeconfig", kubeconfigFilePath}, args...)...)
+		if stdin != nil {
+			cmd.Stdin = stdin
+		}
+		if stdout != nil {
+			cmd.Stdout = stdout
+		}
+		return runCommand(cmd)
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateScopedCredential(t *testing.T) {
+	tests := []struct {
+		name       string
+		credential scopedCredential
+		wantErr    bool
+	}{{
+		name:       "certificate",
+		credential: scopedCredential{User: "alice@example.com", Groups: []string{"dev"}, TTL: time.Hour, Role: "view", Type: "certificate"},
+	}, {
+		name:       "token",
+		credential: scopedCredential{User: "alice", TTL: time.Hour, Role: "edit", Type: "token"},
+	}, {
+		name:       "without role",
+		credential: scopedCredential{User: "alice", TTL: time.Hour, Type: "certificate"},
+	}, {
+		name:       "system group",
+		credential: scopedCredential{User: "alice", Groups: []string{"dev", "system:masters"}, TTL: time.Hour, Role: "view", Type: "certificate"},
+		wantErr:    true,
+	}, {
+		name:       "empty group",
+		credential: scopedCredential{User: "alice", Groups: []string{""}, TTL: time.Hour, Type: "certificate"},
+		wantErr:    true,
+	}, {
+		name:       "system user",
+		credential: scopedCredential{User: "system:kube-controller-manager", TTL: time.Hour, Type: "certificate"},
+		wantErr:    true,
+	}, {
+		name:       "no user",
+		credential: scopedCredential{TTL: time.Hour, Type: "certificate"},
+		wantErr:    true,
+	}, {
+		name:       "token with groups",
+		credential: scopedCredential{User: "alice", Groups: []string{"dev"}, TTL: time.Hour, Type: "token"},
+		wantErr:    true,
+	}, {
+		name:       "invalid ServiceAccount name",
+		credential: scopedCredential{User: "alice@example.com", TTL: time.Hour, Type: "token"},
+		wantErr:    true,
+	}, {
+		name:       "invalid role",
+		credential: scopedCredential{User: "alice", TTL: time.Hour, Role: "../admin", Type: "certificate"},
+		wantErr:    true,
+	}, {
+		name:       "invalid TTL",
+		credential: scopedCredential{User: "alice", Type: "certificate"},
+		wantErr:    true,
+	}, {
+		name:       "unsupported type",
+		credential: scopedCredential{User: "alice", TTL: time.Hour, Type: "password"},
+		wantErr:    true,
+	}}
+
+	for _, tt := range tests {
+		if err := validateScopedCredential(&tt.credential); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateScopedCredential() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// maxClusterResourceSetConfigMapSize keeps each ConfigMap of a ClusterResourceSet well below the 1MiB limit.
+const maxClusterResourceSetConfigMapSize = 768 * 1024
+
+var yamlDocumentSeparator = regexp.MustCompile(`(?m)^---[ \t]*$`)
+
+// newClusterResourceSet returns the ConfigMaps of the manifests and a ClusterResourceSet applying them once.
+func newClusterResourceSet(namespace string, clusterName string, name string, manifests []byte) []*unstructured.Unstructured {
+	resourceName := fmt.Sprintf("%s-%s", clusterName, name)
+	labels := map[string]interface{}{
+		clusterNameLabel: clusterName,
+	}
+
+	var objs []*unstructured.Unstructured
+	var resources []interface{}
+	for i, chunk := range splitManifests(manifests, maxClusterResourceSetConfigMapSize) {
+		configMapName := resourceName
+		if i > 0 {
+			configMapName = fmt.Sprintf("%s-%d", resourceName, i)
+		}
+		objs = append(objs, &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":      configMapName,
+				"namespace": namespace,
+				"labels":    labels,
+			},
+			"data": map[string]interface{}{
+				fmt.Sprintf("%s.yaml", name): string(chunk),
+			},
+		}})
+		resources = append(resources, map[string]interface{}{
+			"kind": "ConfigMap",
+			"name": configMapName,
+		})
+	}
+
+	clusterResourceSet := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "addons.cluster.x-k8s.io/v1beta1",
+		"kind":       "ClusterResourceSet",
+		"metadata": map[string]interface{}{
+			"name":      resourceName,
+			"namespace": namespace,
+			"labels":    labels,
+		},
+		"spec": map[string]interface{}{
+			"strategy": "ApplyOnce",
+			"clusterSelector": map[string]interface{}{
+				"matchLabels": map[string]interface{}{
+					clusterNameLabel: clusterName,
+				},
+			},
+			"resources": resources,
+		},
+	}}
+
+	return append(objs, clusterResourceSet)
+}
+
+// splitManifests groups the YAML documents of the manifests into chunks of at most maxSize bytes, in order.
+// A single document larger than maxSize gets a chunk of its own.
+func splitManifests(manifests []byte, maxSize int) [][]byte {
+	var chunks [][]byte
+	var chunk []byte
+	for _, doc := range yamlDocumentSeparator.Split(string(manifests), -1) {
+		doc := bytes.TrimSpace([]byte(doc))
+		if len(doc) == 0 {
+			continue
+		}
+		if len(chunk) > 0 && len(chunk)+len("\n---\n")+len(doc)+1 > maxSize {
+			chunks = append(chunks, append(chunk, '\n'))
+			chunk = nil
+		}
+		if len(chunk) > 0 {
+			chunk = append(chunk, "\n---\n"...)
+		}
+		chunk = append(chunk, doc...)
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, append(chunk, '\n'))
+	}
+	return chunks
+}
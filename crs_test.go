@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitManifests(t *testing.T) {
+	tests := []struct {
+		name      string
+		manifests string
+		maxSize   int
+		want      []string
+	}{{
+		name:      "single chunk",
+		manifests: "a: 1\n---\nb: 2\n",
+		maxSize:   100,
+		want:      []string{"a: 1\n---\nb: 2\n"},
+	}, {
+		name:      "empty documents",
+		manifests: "---\na: 1\n---\n\n---  \nb: 2\n---\n",
+		maxSize:   100,
+		want:      []string{"a: 1\n---\nb: 2\n"},
+	}, {
+		name:      "split at document boundaries",
+		manifests: "a: 1\n---\nb: 2\n---\nc: 3\n",
+		maxSize:   15,
+		want:      []string{"a: 1\n---\nb: 2\n", "c: 3\n"},
+	}, {
+		name:      "oversized document",
+		manifests: "a: 1\n---\nb: 22222222222222222222\n---\nc: 3\n",
+		maxSize:   15,
+		want:      []string{"a: 1\n", "b: 22222222222222222222\n", "c: 3\n"},
+	}, {
+		name:      "separator inside a document",
+		manifests: "a: |\n  ---\n  b\n",
+		maxSize:   100,
+		want:      []string{"a: |\n  ---\n  b\n"},
+	}}
+
+	for _, tt := range tests {
+		var got []string
+		for _, chunk := range splitManifests([]byte(tt.manifests), tt.maxSize) {
+			got = append(got, string(chunk))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: splitManifests() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNewClusterResourceSet(t *testing.T) {
+	manifests := make([]byte, 0, maxClusterResourceSetConfigMapSize*2)
+	for len(manifests) < maxClusterResourceSetConfigMapSize*3/2 {
+		manifests = append(manifests, "kind: ConfigMap\n---\n"...)
+	}
+
+	objs := newClusterResourceSet("default", "foo", "cni", manifests)
+	if len(objs) != 3 {
+		t.Fatalf("newClusterResourceSet() returned %d objects, want 3", len(objs))
+	}
+	var configMapNames []string
+	for _, obj := range objs[:2] {
+		configMapNames = append(configMapNames, obj.GetName())
+		data := obj.Object["data"].(map[string]interface{})["cni.yaml"].(string)
+		if len(data) > maxClusterResourceSetConfigMapSize {
+			t.Errorf("ConfigMap %s has %d bytes of data, want at most %d", obj.GetName(), len(data), maxClusterResourceSetConfigMapSize)
+		}
+	}
+	if want := []string{"foo-cni", "foo-cni-1"}; !reflect.DeepEqual(configMapNames, want) {
+		t.Errorf("newClusterResourceSet() ConfigMaps = %v, want %v", configMapNames, want)
+	}
+
+	clusterResourceSet := objs[2]
+	if clusterResourceSet.GetKind() != "ClusterResourceSet" || clusterResourceSet.GetName() != "foo-cni" {
+		t.Errorf("newClusterResourceSet() = %s %s, want ClusterResourceSet foo-cni", clusterResourceSet.GetKind(), clusterResourceSet.GetName())
+	}
+	want := []interface{}{
+		map[string]interface{}{"kind": "ConfigMap", "name": "foo-cni"},
+		map[string]interface{}{"kind": "ConfigMap", "name": "foo-cni-1"},
+	}
+	if got := clusterResourceSet.Object["spec"].(map[string]interface{})["resources"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("newClusterResourceSet() resources = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	endpointHostAnnotation = "knest.smartx.com/endpoint-host"
+
+	loadBalancerTimeout = 5 * time.Minute
+)
+
+type controlPlaneEndpoint struct {
+	Server        string
+	TLSServerName string
+}
+
+// getControlPlaneEndpoint returns the endpoint to access the nested API server from outside of the host cluster.
+func getControlPlaneEndpoint(namespace string, clusterName string) (*controlPlaneEndpoint, error) {
+	cluster, err := getObject("clusters.cluster.x-k8s.io", clusterName, "--namespace", namespace)
+	if err != nil {
+		return nil, fmt.Errorf("get cluster: %s", err)
+	}
+	if host := cluster.GetAnnotations()[controlPlaneHostAnnotation]; host != "" {
+		server := fmt.Sprintf("https://%s", host)
+		if port := cluster.GetAnnotations()[controlPlanePortAnnotation]; port != "" && port != "443" {
+			server = fmt.Sprintf("https://%s", net.JoinHostPort(host, port))
+		}
+		return &controlPlaneEndpoint{
+			Server: server,
+		}, nil
+	}
+
+	controlPlane, err := getControlPlane(namespace, clusterName)
+	if err != nil {
+		return nil, err
+	}
+	certSANs, _, _ := unstructured.NestedStringSlice(controlPlane.Object, "spec", "kubeadmConfigSpec", "clusterConfiguration", "apiServer", "certSANs")
+
+	service, err := getObject("service", clusterName, "--namespace", namespace)
+	if err != nil {
+		return nil, fmt.Errorf("get control plane service: %s", err)
+	}
+
+	serviceType, _, _ := unstructured.NestedString(service.Object, "spec", "type")
+	clusterIP, _, _ := unstructured.NestedString(service.Object, "spec", "clusterIP")
+	ports, _, _ := unstructured.NestedSlice(service.Object, "spec", "ports")
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("no port found in control plane service")
+	}
+	port, _ := ports[0].(map[string]interface{})
+
+	// The ClusterIP is always in the API server certificate, so verify against it unless host is an extra SAN.
+	newEndpoint := func(host string, port int64) *controlPlaneEndpoint {
+		endpoint := &controlPlaneEndpoint{
+			Server:        fmt.Sprintf("https://%s", net.JoinHostPort(host, strconv.FormatInt(port, 10))),
+			TLSServerName: clusterIP,
+		}
+		for _, san := range certSANs {
+			if san == host {
+				endpoint.TLSServerName = ""
+			}
+		}
+		return endpoint
+	}
+
+	switch serviceType {
+	case "NodePort":
+		nodePort, _, _ := unstructured.NestedInt64(port, "nodePort")
+		host := cluster.GetAnnotations()[endpointHostAnnotation]
+		if host == "" {
+			host, err = selectNodePortHost(nodePort)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return newEndpoint(host, nodePort), nil
+	case "LoadBalancer":
+		servicePort, _, _ := unstructured.NestedInt64(port, "port")
+		deadline := time.Now().Add(loadBalancerTimeout)
+		for {
+			ingresses, _, _ := unstructured.NestedSlice(service.Object, "status", "loadBalancer", "ingress")
+			if len(ingresses) > 0 {
+				ingress, _ := ingresses[0].(map[string]interface{})
+				host, _, _ := unstructured.NestedString(ingress, "ip")
+				if host == "" {
+					host, _, _ = unstructured.NestedString(ingress, "hostname")
+				}
+				if host != "" {
+					return newEndpoint(host, servicePort), nil
+				}
+			}
+
+			if time.Now().After(deadline) {
+				return nil, fmt.Errorf("timed out waiting for load balancer of control plane service to be assigned, make sure a load balancer implementation is available in the host cluster, or use 'knest proxy' instead")
+			}
+			fmt.Println("Waiting for load balancer of control plane service to be assigned...")
+			time.Sleep(5 * time.Second)
+			service, err = getObject("service", clusterName, "--namespace", namespace)
+			if err != nil {
+				return nil, fmt.Errorf("get control plane service: %s", err)
+			}
+		}
+	case "ClusterIP":
+		servicePort, _, _ := unstructured.NestedInt64(port, "port")
+		fmt.Printf("Hint: the ClusterIP of the control plane service is only reachable inside the host cluster, use 'knest proxy %s' to access it from outside\n", clusterName)
+		return &controlPlaneEndpoint{
+			Server: fmt.Sprintf("https://%s", net.JoinHostPort(clusterIP, strconv.FormatInt(servicePort, 10))),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported control plane service type: %s", serviceType)
+	}
+}
+
+// selectNodePortHost returns the first host, among the host API server and nodes, on which the NodePort is reachable.
+func selectNodePortHost(nodePort int64) (string, error) {
+	infraKubeconfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(clientcmd.NewDefaultClientConfigLoadingRules(), nil).ClientConfig()
+	if err != nil {
+		return "", fmt.Errorf("load infra kubeconfig: %s", err)
+	}
+	infraHost, err := url.Parse(infraKubeconfig.Host)
+	if err != nil {
+		return "", fmt.Errorf("parse infra host: %s", err)
+	}
+
+	nodes, err := getObjects("nodes")
+	if err != nil {
+		return "", fmt.Errorf("get nodes: %s", err)
+	}
+	return selectReachableHost(nodePortHostCandidates(infraHost.Hostname(), nodes), nodePort), nil
+}
+
+// nodePortHostCandidates returns the host of the host API server followed by the InternalIPs of the nodes.
+func nodePortHostCandidates(infraHost string, nodes []*unstructured.Unstructured) []string {
+	candidates := []string{infraHost}
+	for _, node := range nodes {
+		addresses, _, _ := unstructured.NestedSlice(node.Object, "status", "addresses")
+		for _, address := range addresses {
+			a, _ := address.(map[string]interface{})
+			if a["type"] == "InternalIP" {
+				if ip, ok := a["address"].(string); ok {
+					candidates = append(candidates, ip)
+				}
+			}
+		}
+	}
+	return candidates
+}
+
+// selectReachableHost returns the first candidate on which the port is reachable, falling back to the first candidate.
+func selectReachableHost(candidates []string, port int64) string {
+	for _, candidate := range candidates {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(candidate, strconv.FormatInt(port, 10)), 2*time.Second)
+		if err == nil {
+			conn.Close()
+			return candidate
+		}
+	}
+
+	fmt.Printf("Warning: node port %d is not reachable on any of %v, use --endpoint-host to specify a reachable host\n", port, candidates)
+	return candidates[0]
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestNodePortHostCandidates(t *testing.T) {
+	newNode := func(addresses ...map[string]interface{}) *unstructured.Unstructured {
+		var items []interface{}
+		for _, address := range addresses {
+			items = append(items, address)
+		}
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"kind":   "Node",
+			"status": map[string]interface{}{"addresses": items},
+		}}
+	}
+
+	tests := []struct {
+		name  string
+		nodes []*unstructured.Unstructured
+		want  []string
+	}{{
+		name: "no nodes",
+		want: []string{"api.example.com"},
+	}, {
+		name: "internal IPs of every node",
+		nodes: []*unstructured.Unstructured{
+			newNode(
+				map[string]interface{}{"type": "Hostname", "address": "node-1"},
+				map[string]interface{}{"type": "InternalIP", "address": "10.0.0.1"},
+				map[string]interface{}{"type": "ExternalIP", "address": "203.0.113.1"},
+			),
+			newNode(
+				map[string]interface{}{"type": "InternalIP", "address": "10.0.0.2"},
+				map[string]interface{}{"type": "InternalIP", "address": "fd00::2"},
+			),
+		},
+		want: []string{"api.example.com", "10.0.0.1", "10.0.0.2", "fd00::2"},
+	}, {
+		name:  "node without addresses",
+		nodes: []*unstructured.Unstructured{newNode()},
+		want:  []string{"api.example.com"},
+	}}
+
+	for _, tt := range tests {
+		if got := nodePortHostCandidates("api.example.com", tt.nodes); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: nodePortHostCandidates() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSelectReachableHost(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+	port := int64(listener.Addr().(*net.TCPAddr).Port)
+
+	tests := []struct {
+		name       string
+		candidates []string
+		want       string
+	}{{
+		name:       "host API server reachable",
+		candidates: []string{"127.0.0.1", "127.0.0.2"},
+		want:       "127.0.0.1",
+	}, {
+		name:       "falls back to a reachable node",
+		candidates: []string{"127.0.0.2", "127.0.0.1"},
+		want:       "127.0.0.1",
+	}, {
+		name:       "falls back to the host API server when nothing is reachable",
+		candidates: []string{"127.0.0.2", "127.0.0.3"},
+		want:       "127.0.0.2",
+	}}
+
+	for _, tt := range tests {
+		if got := selectReachableHost(tt.candidates, port); got != tt.want {
+			t.Errorf("%s: selectReachableHost() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
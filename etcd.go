@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"text/template"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	etcdHelperImage  = "busybox:1.36"
+	etcdKubectlImage = "bitnami/kubectl:1.24"
+
+	// etcdScheduledBackupPodName is fixed, as the backups of a cluster never run concurrently.
+	etcdScheduledBackupPodName = "knest-etcd-backup-scheduled"
+	// etcdImagePlaceholder is replaced by the image of the running etcd member on every scheduled backup.
+	etcdImagePlaceholder = "registry.k8s.io/etcd"
+)
+
+// etcdMember is a stacked etcd member of a nested control plane, as run by kubeadm in a static pod.
+type etcdMember struct {
+	PodName  string
+	NodeName string
+	Image    string
+	Name     string
+	PeerURL  string
+	DataDir  string
+}
+
+// getEtcdMember returns a running etcd member of a nested cluster along with the flags it was started with.
+func getEtcdMember(kubeconfig *clientcmdapi.Config) (*etcdMember, error) {
+	buf := &bytes.Buffer{}
+	if err := runNestedKubectlWithOutput(kubeconfig, nil, buf, "get", "pods", "--namespace", "kube-system", "--selector", "component=etcd", "-o", "json"); err != nil {
+		return nil, fmt.Errorf("get etcd pods: %s", err)
+	}
+	list := &unstructured.UnstructuredList{}
+	if err := list.UnmarshalJSON(buf.Bytes()); err != nil {
+		return nil, fmt.Errorf("decode etcd pods: %s", err)
+	}
+
+	for _, pod := range list.Items {
+		if phase, _, _ := unstructured.NestedString(pod.Object, "status", "phase"); phase != "Running" {
+			continue
+		}
+		containers, _, _ := unstructured.NestedSlice(pod.Object, "spec", "containers")
+		if len(containers) == 0 {
+			continue
+		}
+		container, _ := containers[0].(map[string]interface{})
+		member := &etcdMember{
+			PodName: pod.GetName(),
+			DataDir: "/var/lib/etcd",
+		}
+		member.NodeName, _, _ = unstructured.NestedString(pod.Object, "spec", "nodeName")
+		member.Image, _, _ = unstructured.NestedString(container, "image")
+		command, _, _ := unstructured.NestedStringSlice(container, "command")
+		args, _, _ := unstructured.NestedStringSlice(container, "args")
+		for _, arg := range append(command, args...) {
+			key, value, ok := strings.Cut(arg, "=")
+			if !ok {
+				continue
+			}
+			switch key {
+			case "--name":
+				member.Name = value
+			case "--initial-advertise-peer-urls":
+				member.PeerURL = value
+			case "--data-dir":
+				member.DataDir = value
+			}
+		}
+		return member, nil
+	}
+	return nil, fmt.Errorf("no running etcd member found in the nested cluster")
+}
+
+func renderEtcdPod(templateName string, data interface{}) (*bytes.Buffer, error) {
+	buf := &bytes.Buffer{}
+	if err := template.Must(template.New(templateName).ParseFS(templatesFS, "templates/"+templateName)).Execute(buf, data); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func renderEtcdBackupPod(name string, nodeName string, etcdImage string) (*bytes.Buffer, error) {
+	return renderEtcdPod("etcd-backup-pod.yaml", struct {
+		Name        string
+		NodeName    string
+		EtcdImage   string
+		HelperImage string
+	}{
+		Name:        name,
+		NodeName:    nodeName,
+		EtcdImage:   etcdImage,
+		HelperImage: etcdHelperImage,
+	})
+}
+
+// backupEtcd saves an etcd snapshot on a control plane node by a helper pod and downloads it to outputPath.
+func backupEtcd(namespace string, clusterName string, outputPath string) error {
+	kubeconfig, err := buildKubeconfig(namespace, clusterName)
+	if err != nil {
+		return err
+	}
+	member, err := getEtcdMember(kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	podName := fmt.Sprintf("knest-etcd-backup-%s", time.Now().Format("20060102150405"))
+	podBuf, err := renderEtcdBackupPod(podName, member.NodeName, member.Image)
+	if err != nil {
+		return err
+	}
+	if err := runNestedKubectl(kubeconfig, podBuf, "apply", "-f", "-"); err != nil {
+		return fmt.Errorf("create etcd backup pod: %s", err)
+	}
+	defer runNestedKubectl(kubeconfig, nil, "delete", "pod", podName, "--namespace", "kube-system", "--ignore-not-found", "--wait=false")
+
+	fmt.Printf("Saving etcd snapshot on node %q...\n", member.NodeName)
+	if err := runNestedKubectl(kubeconfig, nil, "wait", "pod", podName, "--namespace", "kube-system", "--for", "condition=Ready", "--timeout", "5m"); err != nil {
+		return fmt.Errorf("wait for etcd backup pod: %s", err)
+	}
+
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+	if err := runNestedKubectlWithOutput(kubeconfig, nil, outputFile, "exec", podName, "--namespace", "kube-system", "--container", "backup", "--", "cat", "/backup/snapshot.db"); err != nil {
+		os.Remove(outputPath)
+		return fmt.Errorf("download etcd snapshot: %s", err)
+	}
+	return nil
+}
+
+// restoreEtcd restores the etcd of a nested cluster from a snapshot taken by backupEtcd.
+func restoreEtcd(namespace string, clusterName string, snapshotPath string) error {
+	if err := checkClusterNotStopped(namespace, clusterName); err != nil {
+		return err
+	}
+	snapshotFile, err := os.Open(snapshotPath)
+	if err != nil {
+		return err
+	}
+	defer snapshotFile.Close()
+
+	controlPlane, err := getControlPlane(namespace, clusterName)
+	if err != nil {
+		return err
+	}
+	if replicas := getReplicas(controlPlane); replicas != 1 {
+		return fmt.Errorf("restoring etcd requires a single control plane machine but cluster %q has %d, scale the control plane to 1 first", clusterName, replicas)
+	}
+
+	kubeconfig, err := buildKubeconfig(namespace, clusterName)
+	if err != nil {
+		return err
+	}
+	member, err := getEtcdMember(kubeconfig)
+	if err != nil {
+		return err
+	}
+	if member.Name == "" || member.PeerURL == "" {
+		return fmt.Errorf("unable to determine the name and peer URL of etcd member %q", member.PodName)
+	}
+
+	paused, err := isClusterPaused(namespace, clusterName)
+	if err != nil {
+		return err
+	}
+	if !paused {
+		if err := setClusterPaused(namespace, clusterName, true); err != nil {
+			return err
+		}
+		defer setClusterPaused(namespace, clusterName, false)
+	}
+
+	timestamp := time.Now().Format("20060102150405")
+	podName := fmt.Sprintf("knest-etcd-restore-%s", timestamp)
+	podBuf, err := renderEtcdPod("etcd-restore-pod.yaml", struct {
+		Name        string
+		NodeName    string
+		EtcdImage   string
+		HelperImage string
+		EtcdName    string
+		PeerURL     string
+		DataDir     string
+		Timestamp   string
+	}{
+		Name:        podName,
+		NodeName:    member.NodeName,
+		EtcdImage:   member.Image,
+		HelperImage: etcdHelperImage,
+		EtcdName:    member.Name,
+		PeerURL:     member.PeerURL,
+		DataDir:     member.DataDir,
+		Timestamp:   timestamp,
+	})
+	if err != nil {
+		return err
+	}
+	if err := runNestedKubectl(kubeconfig, podBuf, "apply", "-f", "-"); err != nil {
+		return fmt.Errorf("create etcd restore pod: %s", err)
+	}
+
+	if err := waitForNestedContainerRunning(kubeconfig, "kube-system", podName, "initContainerStatuses", "upload"); err != nil {
+		return err
+	}
+	fmt.Printf("Uploading etcd snapshot to node %q...\n", member.NodeName)
+	if err := runNestedKubectl(kubeconfig, snapshotFile, "exec", podName, "--namespace", "kube-system", "--container", "upload", "--stdin", "--",
+		"sh", "-c", "mkdir -p /host/var/lib/knest-etcd-restore && cat > /host/var/lib/knest-etcd-restore/snapshot.db.part && mv /host/var/lib/knest-etcd-restore/snapshot.db.part /host/var/lib/knest-etcd-restore/snapshot.db"); err != nil {
+		return fmt.Errorf("upload etcd snapshot: %s", err)
+	}
+
+	fmt.Println("Restoring etcd, the nested API server will be unavailable for a while...")
+	return waitForEtcdRestored(kubeconfig, podName)
+}
+
+// waitForNestedContainerRunning polls a pod in the nested cluster until the given container is running.
+func waitForNestedContainerRunning(kubeconfig *clientcmdapi.Config, namespace string, podName string, statusesField string, containerName string) error {
+	for i := 0; i < 60; i++ {
+		buf := &bytes.Buffer{}
+		if err := runNestedKubectlWithOutput(kubeconfig, nil, buf, "get", "pod", podName, "--namespace", namespace,
+			"-o", fmt.Sprintf("jsonpath={.%s[?(@.name==%q)].state.running}", statusesField, containerName)); err != nil {
+			return err
+		}
+		if buf.Len() > 0 {
+			return nil
+		}
+		time.Sleep(5 * time.Second)
+	}
+	return fmt.Errorf("timed out waiting for container %q of pod %q to be running", containerName, podName)
+}
+
+// waitForEtcdRestored waits until the restore pod, created after the snapshot, is gone from the API server.
+func waitForEtcdRestored(kubeconfig *clientcmdapi.Config, podName string) error {
+	return withKubeconfigFile(kubeconfig, func(kubeconfigFilePath string) error {
+		deadline := time.Now().Add(15 * time.Minute)
+		for time.Now().Before(deadline) {
+			time.Sleep(5 * time.Second)
+			out, err := exec.Command("kubectl", "--kubeconfig", kubeconfigFilePath, "get", "pod", podName, "--namespace", "kube-system",
+				"--ignore-not-found", "-o", "jsonpath={.status.phase}").Output()
+			if err != nil {
+				continue
+			}
+			switch phase := string(out); phase {
+			case "":
+				return nil
+			case "Failed":
+				return fmt.Errorf("etcd restore pod %q failed, check its logs for details", podName)
+			}
+		}
+		return fmt.Errorf("timed out waiting for etcd to be restored")
+	})
+}
+
+func etcdBackupScheduleName(clusterName string) string {
+	return fmt.Sprintf("%s-etcd-backup", clusterName)
+}
+
+// scheduleEtcdBackup deploys a CronJob backing up etcd into the PVC, keeping the latest retain snapshots.
+func scheduleEtcdBackup(namespace string, clusterName string, schedule string, pvcName string, retain int) error {
+	if retain <= 0 {
+		return fmt.Errorf("invalid number of etcd snapshots to retain: %d", retain)
+	}
+	if _, err := getObject("persistentvolumeclaims", pvcName, "--namespace", namespace); err != nil {
+		return fmt.Errorf("get PVC %q: %s", pvcName, err)
+	}
+
+	kubectlImage, err := resolveImageDigest(namespace, etcdKubectlImage, "kubectl", "version", "--client")
+	if err != nil {
+		return err
+	}
+	buf, err := renderEtcdBackupSchedule(namespace, clusterName, schedule, pvcName, retain, kubectlImage)
+	if err != nil {
+		return err
+	}
+
+	applyCmd := exec.Command("kubectl", "apply", "-f", "-")
+	applyCmd.Stdin = buf
+	if err := runCommand(applyCmd); err != nil {
+		return fmt.Errorf("deploy etcd backup CronJob: %s", err)
+	}
+	return nil
+}
+
+// resolveImageDigest pulls the image on the host cluster by running the command in a pod, and returns the image pinned by the pulled digest.
+func resolveImageDigest(namespace string, image string, command ...string) (string, error) {
+	podName := fmt.Sprintf("knest-resolve-image-%s", time.Now().Format("20060102150405"))
+	var commandArgs []interface{}
+	for _, arg := range command {
+		commandArgs = append(commandArgs, arg)
+	}
+	pod := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata": map[string]interface{}{
+			"name":      podName,
+			"namespace": namespace,
+		},
+		"spec": map[string]interface{}{
+			"restartPolicy": "Never",
+			"containers": []interface{}{
+				map[string]interface{}{
+					"name":    "resolve",
+					"image":   image,
+					"command": commandArgs,
+				},
+			},
+		},
+	}}
+	if err := applyObjects([]*unstructured.Unstructured{pod}); err != nil {
+		return "", fmt.Errorf("create pod to pull image %q: %s", image, err)
+	}
+	defer runCommand(exec.Command("kubectl", "delete", "pod", podName, "--namespace", namespace, "--ignore-not-found", "--wait=false"))
+
+	fmt.Printf("Pulling image %q...\n", image)
+	for deadline := time.Now().Add(5 * time.Minute); time.Now().Before(deadline); time.Sleep(5 * time.Second) {
+		imageID, err := getCommandOutput(exec.Command("kubectl", "get", "pod", podName, "--namespace", namespace, "-o", "jsonpath={.status.containerStatuses[0].imageID}"))
+		if err != nil {
+			return "", fmt.Errorf("get pod to pull image %q: %s", image, err)
+		}
+		if imageID = strings.TrimSpace(imageID); imageID != "" {
+			return pinImageDigest(image, imageID)
+		}
+	}
+	return "", fmt.Errorf("timed out pulling image %q", image)
+}
+
+// pinImageDigest returns the image pinned by the digest of the image ID reported by the container runtime.
+func pinImageDigest(image string, imageID string) (string, error) {
+	_, digest, ok := strings.Cut(imageID, "@")
+	if !ok || !strings.HasPrefix(digest, "sha256:") {
+		return "", fmt.Errorf("unable to pin image %q by image ID %q", image, imageID)
+	}
+	if i := strings.LastIndex(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	return fmt.Sprintf("%s@%s", image, digest), nil
+}
+
+// renderEtcdBackupSchedule renders the CronJob backing up etcd by a pod in the nested cluster, along with its script and pod manifest.
+func renderEtcdBackupSchedule(namespace string, clusterName string, schedule string, pvcName string, retain int, kubectlImage string) (*bytes.Buffer, error) {
+	// The pod is scheduled next to an etcd member by affinity, rather than to a node which may be replaced.
+	podBuf, err := renderEtcdBackupPod(etcdScheduledBackupPodName, "", etcdImagePlaceholder)
+	if err != nil {
+		return nil, err
+	}
+	data := struct {
+		Name         string
+		Namespace    string
+		Schedule     string
+		PVC          string
+		PruneFrom    int
+		KubectlImage string
+		PodName      string
+		PodManifest  string
+	}{
+		Name:         clusterName,
+		Namespace:    namespace,
+		Schedule:     schedule,
+		PVC:          pvcName,
+		PruneFrom:    retain + 1,
+		KubectlImage: kubectlImage,
+		PodName:      etcdScheduledBackupPodName,
+		PodManifest:  strings.TrimSpace(podBuf.String()),
+	}
+	funcs := template.FuncMap{
+		"indent": func(n int, s string) string {
+			return strings.Repeat(" ", n) + strings.ReplaceAll(s, "\n", "\n"+strings.Repeat(" ", n))
+		},
+	}
+	buf := &bytes.Buffer{}
+	if err := template.Must(template.New("etcd-backup-cronjob.yaml").Funcs(funcs).ParseFS(templatesFS, "templates/etcd-backup-cronjob.yaml")).Execute(buf, data); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func deleteEtcdBackupSchedule(namespace string, clusterName string) error {
+	if err := runCommand(exec.Command("kubectl", "delete", "cronjobs.batch,configmaps", etcdBackupScheduleName(clusterName), "--namespace", namespace, "--ignore-not-found")); err != nil {
+		return fmt.Errorf("delete etcd backup CronJob: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestPinImageDigest(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	tests := []struct {
+		image   string
+		imageID string
+		want    string
+		wantErr bool
+	}{{
+		image:   "bitnami/kubectl:1.24",
+		imageID: "docker.io/bitnami/kubectl@" + digest,
+		want:    "bitnami/kubectl:1.24@" + digest,
+	}, {
+		image:   "bitnami/kubectl:1.24",
+		imageID: "docker-pullable://bitnami/kubectl@" + digest,
+		want:    "bitnami/kubectl:1.24@" + digest,
+	}, {
+		image:   "bitnami/kubectl:1.24@sha256:" + strings.Repeat("b", 64),
+		imageID: "docker.io/bitnami/kubectl@" + digest,
+		want:    "bitnami/kubectl:1.24@" + digest,
+	}, {
+		image:   "bitnami/kubectl:1.24",
+		imageID: digest,
+		wantErr: true,
+	}, {
+		image:   "bitnami/kubectl:1.24",
+		imageID: "docker.io/bitnami/kubectl@md5:abc",
+		wantErr: true,
+	}}
+
+	for _, tt := range tests {
+		got, err := pinImageDigest(tt.image, tt.imageID)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("pinImageDigest(%q, %q) error = %v, wantErr %v", tt.image, tt.imageID, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("pinImageDigest(%q, %q) = %q, want %q", tt.image, tt.imageID, got, tt.want)
+		}
+	}
+}
+
+func TestRenderEtcdBackupPod(t *testing.T) {
+	tests := []struct {
+		name         string
+		nodeName     string
+		wantAffinity bool
+	}{{
+		name:     "one-off backup on the node of the member",
+		nodeName: "foo-cp-abcde",
+	}, {
+		name:         "scheduled backup next to any member",
+		wantAffinity: true,
+	}}
+
+	for _, tt := range tests {
+		buf, err := renderEtcdBackupPod("knest-etcd-backup", tt.nodeName, "registry.k8s.io/etcd:3.5.3-0")
+		if err != nil {
+			t.Fatalf("%s: renderEtcdBackupPod() error = %v", tt.name, err)
+		}
+		objs, err := decodeObjects(buf)
+		if err != nil || len(objs) != 1 {
+			t.Fatalf("%s: renderEtcdBackupPod() = %s, want a single pod: %v", tt.name, buf, err)
+		}
+		pod := objs[0]
+		if nodeName, _, _ := unstructured.NestedString(pod.Object, "spec", "nodeName"); nodeName != tt.nodeName {
+			t.Errorf("%s: nodeName = %q, want %q", tt.name, nodeName, tt.nodeName)
+		}
+		_, hasAffinity, _ := unstructured.NestedMap(pod.Object, "spec", "affinity", "podAffinity")
+		if hasAffinity != tt.wantAffinity {
+			t.Errorf("%s: has pod affinity %t, want %t", tt.name, hasAffinity, tt.wantAffinity)
+		}
+	}
+}
+
+func TestRenderEtcdBackupSchedule(t *testing.T) {
+	kubectlImage := "bitnami/kubectl:1.24@sha256:" + strings.Repeat("a", 64)
+	buf, err := renderEtcdBackupSchedule("default", "foo", "0 */6 * * *", "etcd-backups", 7, kubectlImage)
+	if err != nil {
+		t.Fatalf("renderEtcdBackupSchedule() error = %v", err)
+	}
+	objs, err := decodeObjects(buf)
+	if err != nil {
+		t.Fatalf("decode etcd backup schedule: %v", err)
+	}
+	configMap := findObject(objs, "ConfigMap", "foo-etcd-backup")
+	cronJob := findObject(objs, "CronJob", "foo-etcd-backup")
+	if configMap == nil || cronJob == nil {
+		t.Fatalf("renderEtcdBackupSchedule() = %s, want a ConfigMap and a CronJob", buf)
+	}
+
+	containers, _, _ := unstructured.NestedSlice(cronJob.Object, "spec", "jobTemplate", "spec", "template", "spec", "containers")
+	if image, _, _ := unstructured.NestedString(containers[0].(map[string]interface{}), "image"); image != kubectlImage {
+		t.Errorf("CronJob image = %q, want %q", image, kubectlImage)
+	}
+
+	script, _, _ := unstructured.NestedString(configMap.Object, "data", "backup.sh")
+	if strings.Contains(script, "<<") {
+		t.Errorf("backup.sh builds a manifest by a heredoc:\n%s", script)
+	}
+	if !strings.Contains(script, "tail -n +8") {
+		t.Errorf("backup.sh doesn't keep the latest 7 snapshots:\n%s", script)
+	}
+
+	podManifest, _, _ := unstructured.NestedString(configMap.Object, "data", "pod.yaml")
+	pods, err := decodeObjects(bytes.NewBufferString(podManifest))
+	if err != nil || len(pods) != 1 {
+		t.Fatalf("pod.yaml = %s, want a single pod: %v", podManifest, err)
+	}
+	if pods[0].GetName() != etcdScheduledBackupPodName || pods[0].GetNamespace() != "kube-system" {
+		t.Errorf("pod.yaml = %s/%s, want kube-system/%s", pods[0].GetNamespace(), pods[0].GetName(), etcdScheduledBackupPodName)
+	}
+	initContainers, _, _ := unstructured.NestedSlice(pods[0].Object, "spec", "initContainers")
+	if name, _, _ := unstructured.NestedString(initContainers[0].(map[string]interface{}), "name"); !strings.Contains(script, name+"=\"$IMAGE\"") {
+		t.Errorf("backup.sh doesn't set the image of container %q:\n%s", name, script)
+	}
+}
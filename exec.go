@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientauthenticationv1 "k8s.io/client-go/pkg/apis/clientauthentication/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// execCredentialTTL bounds how long kubectl caches a credential returned by the exec plugin.
+const execCredentialTTL = 5 * time.Minute
+
+// getExecCredential returns the admin credential from the kubeconfig secret with the user's host RBAC.
+func getExecCredential(namespace string, clusterName string, hostContext string) (*clientauthenticationv1.ExecCredential, error) {
+	getSecretCmd := exec.Command("kubectl", "get", "secret", fmt.Sprintf("%s-kubeconfig", clusterName), "--namespace", namespace, "-o", "jsonpath={.data.value}")
+	if hostContext != "" {
+		getSecretCmd.Args = append(getSecretCmd.Args, "--context", hostContext)
+	}
+	encodedKubeconfigData, err := getCommandOutput(getSecretCmd)
+	if err != nil {
+		return nil, fmt.Errorf("get kubeconfig: %s", err)
+	}
+	kubeconfigData, err := base64.StdEncoding.DecodeString(encodedKubeconfigData)
+	if err != nil {
+		return nil, fmt.Errorf("decode kubeconfig: %s", err)
+	}
+	return newExecCredential(kubeconfigData, os.Getenv("KUBERNETES_EXEC_INFO"), time.Now())
+}
+
+// newExecCredential returns the credential of the current user in the kubeconfig, in the API version kubectl asks for by execInfo.
+func newExecCredential(kubeconfigData []byte, execInfo string, now time.Time) (*clientauthenticationv1.ExecCredential, error) {
+	kubeconfig, err := clientcmd.Load(kubeconfigData)
+	if err != nil {
+		return nil, fmt.Errorf("load kubeconfig: %s", err)
+	}
+	context, ok := kubeconfig.Contexts[kubeconfig.CurrentContext]
+	if !ok {
+		return nil, fmt.Errorf("current context %q not found in kubeconfig", kubeconfig.CurrentContext)
+	}
+	authInfo, ok := kubeconfig.AuthInfos[context.AuthInfo]
+	if !ok {
+		return nil, fmt.Errorf("user %q not found in kubeconfig", context.AuthInfo)
+	}
+
+	apiVersion := clientauthenticationv1.SchemeGroupVersion.String()
+	if execInfo != "" {
+		var typeMeta metav1.TypeMeta
+		if err := json.Unmarshal([]byte(execInfo), &typeMeta); err == nil && typeMeta.APIVersion != "" {
+			apiVersion = typeMeta.APIVersion
+		}
+	}
+
+	expirationTimestamp := metav1.NewTime(now.Add(execCredentialTTL))
+	return &clientauthenticationv1.ExecCredential{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: apiVersion,
+			Kind:       "ExecCredential",
+		},
+		Status: &clientauthenticationv1.ExecCredentialStatus{
+			ExpirationTimestamp:   &expirationTimestamp,
+			Token:                 authInfo.Token,
+			ClientCertificateData: string(authInfo.ClientCertificateData),
+			ClientKeyData:         string(authInfo.ClientKeyData),
+		},
+	}, nil
+}
+
+// useExecCredential replaces all users of the kubeconfig with a "knest credential" exec plugin.
+func useExecCredential(kubeconfig *clientcmdapi.Config, namespace string, clusterName string, hostContext string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("get knest executable: %s", err)
+	}
+	if hostContext == "" {
+		hostKubeconfig, err := clientcmd.NewDefaultClientConfigLoadingRules().Load()
+		if err != nil {
+			return fmt.Errorf("load host kubeconfig: %s", err)
+		}
+		hostContext = hostKubeconfig.CurrentContext
+	}
+
+	args := []string{"credential", clusterName, "--target-namespace", namespace}
+	if hostContext != "" {
+		args = append(args, "--host-context", hostContext)
+	}
+	authInfo := clientcmdapi.NewAuthInfo()
+	authInfo.Exec = &clientcmdapi.ExecConfig{
+		APIVersion:      clientauthenticationv1.SchemeGroupVersion.String(),
+		Command:         executable,
+		Args:            args,
+		InteractiveMode: clientcmdapi.NeverExecInteractiveMode,
+	}
+	for name := range kubeconfig.AuthInfos {
+		kubeconfig.AuthInfos[name] = authInfo
+	}
+	return nil
+}
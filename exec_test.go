@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func TestNewExecCredential(t *testing.T) {
+	newKubeconfigData := func(currentContext string, authInfo *clientcmdapi.AuthInfo) []byte {
+		kubeconfig := clientcmdapi.NewConfig()
+		kubeconfig.Clusters["foo"] = &clientcmdapi.Cluster{Server: "https://10.0.0.1:6443"}
+		kubeconfig.AuthInfos["foo-admin"] = authInfo
+		kubeconfig.Contexts["foo-admin@foo"] = &clientcmdapi.Context{Cluster: "foo", AuthInfo: "foo-admin"}
+		kubeconfig.Contexts["orphan"] = &clientcmdapi.Context{Cluster: "foo", AuthInfo: "missing"}
+		kubeconfig.CurrentContext = currentContext
+		data, err := clientcmd.Write(*kubeconfig)
+		if err != nil {
+			t.Fatalf("write kubeconfig: %v", err)
+		}
+		return data
+	}
+	certAuthInfo := &clientcmdapi.AuthInfo{ClientCertificateData: []byte("cert"), ClientKeyData: []byte("key")}
+	now := time.Date(2022, 9, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		kubeconfigData []byte
+		execInfo       string
+		wantAPIVersion string
+		wantToken      string
+		wantCert       string
+		wantErr        bool
+	}{{
+		name:           "client certificate",
+		kubeconfigData: newKubeconfigData("foo-admin@foo", certAuthInfo),
+		wantAPIVersion: "client.authentication.k8s.io/v1",
+		wantCert:       "cert",
+	}, {
+		name:           "token",
+		kubeconfigData: newKubeconfigData("foo-admin@foo", &clientcmdapi.AuthInfo{Token: "token"}),
+		wantAPIVersion: "client.authentication.k8s.io/v1",
+		wantToken:      "token",
+	}, {
+		name:           "API version asked by kubectl",
+		kubeconfigData: newKubeconfigData("foo-admin@foo", certAuthInfo),
+		execInfo:       `{"apiVersion":"client.authentication.k8s.io/v1beta1","kind":"ExecCredential","spec":{"interactive":false}}`,
+		wantAPIVersion: "client.authentication.k8s.io/v1beta1",
+		wantCert:       "cert",
+	}, {
+		name:           "invalid exec info",
+		kubeconfigData: newKubeconfigData("foo-admin@foo", certAuthInfo),
+		execInfo:       "{",
+		wantAPIVersion: "client.authentication.k8s.io/v1",
+		wantCert:       "cert",
+	}, {
+		name:           "missing current context",
+		kubeconfigData: newKubeconfigData("missing", certAuthInfo),
+		wantErr:        true,
+	}, {
+		name:           "missing user",
+		kubeconfigData: newKubeconfigData("orphan", certAuthInfo),
+		wantErr:        true,
+	}, {
+		name:           "invalid kubeconfig",
+		kubeconfigData: []byte("{"),
+		wantErr:        true,
+	}}
+
+	for _, tt := range tests {
+		got, err := newExecCredential(tt.kubeconfigData, tt.execInfo, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: newExecCredential() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got.APIVersion != tt.wantAPIVersion || got.Kind != "ExecCredential" {
+			t.Errorf("%s: newExecCredential() type = %s %s, want %s ExecCredential", tt.name, got.APIVersion, got.Kind, tt.wantAPIVersion)
+		}
+		if got.Status.Token != tt.wantToken || got.Status.ClientCertificateData != tt.wantCert {
+			t.Errorf("%s: newExecCredential() status = %+v, want token %q and certificate %q", tt.name, got.Status, tt.wantToken, tt.wantCert)
+		}
+		if !got.Status.ExpirationTimestamp.Time.Equal(now.Add(execCredentialTTL)) {
+			t.Errorf("%s: newExecCredential() expires at %s, want %s", tt.name, got.Status.ExpirationTimestamp, now.Add(execCredentialTTL))
+		}
+	}
+}
+
+func TestUseExecCredential(t *testing.T) {
+	hostKubeconfigPath := filepath.Join(t.TempDir(), "config")
+	hostKubeconfig := clientcmdapi.NewConfig()
+	hostKubeconfig.Contexts["host"] = &clientcmdapi.Context{}
+	hostKubeconfig.CurrentContext = "host"
+	if err := clientcmd.WriteToFile(*hostKubeconfig, hostKubeconfigPath); err != nil {
+		t.Fatalf("write kubeconfig: %v", err)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatalf("get executable: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		hostKubeconfig string
+		hostContext    string
+		wantArgs       []string
+	}{{
+		name:           "given host context",
+		hostKubeconfig: hostKubeconfigPath,
+		hostContext:    "other",
+		wantArgs:       []string{"credential", "foo", "--target-namespace", "default", "--host-context", "other"},
+	}, {
+		name:           "current host context",
+		hostKubeconfig: hostKubeconfigPath,
+		wantArgs:       []string{"credential", "foo", "--target-namespace", "default", "--host-context", "host"},
+	}, {
+		name:           "no host context",
+		hostKubeconfig: filepath.Join(t.TempDir(), "missing"),
+		wantArgs:       []string{"credential", "foo", "--target-namespace", "default"},
+	}}
+
+	for _, tt := range tests {
+		t.Setenv("KUBECONFIG", tt.hostKubeconfig)
+		kubeconfig := clientcmdapi.NewConfig()
+		kubeconfig.AuthInfos["foo-admin"] = &clientcmdapi.AuthInfo{Token: "token"}
+		kubeconfig.AuthInfos["foo-viewer"] = &clientcmdapi.AuthInfo{Token: "token"}
+		if err := useExecCredential(kubeconfig, "default", "foo", tt.hostContext); err != nil {
+			t.Fatalf("%s: useExecCredential() error = %v", tt.name, err)
+		}
+		for name, authInfo := range kubeconfig.AuthInfos {
+			if authInfo.Token != "" || authInfo.Exec == nil {
+				t.Errorf("%s: user %q = %+v, want only the exec plugin", tt.name, name, authInfo)
+				continue
+			}
+			if authInfo.Exec.Command != executable || !reflect.DeepEqual(authInfo.Exec.Args, tt.wantArgs) {
+				t.Errorf("%s: user %q runs %s %v, want %s %v", tt.name, name, authInfo.Exec.Command, authInfo.Exec.Args, executable, tt.wantArgs)
+			}
+		}
+	}
+}
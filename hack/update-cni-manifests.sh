@@ -0,0 +1,70 @@
+#!/usr/bin/env bash
+
+# Renders the manifests of the supported CNI plugins at the versions in cni.go into templates/cni,
+# where they are embedded into knest and applied to nested clusters through ClusterResourceSets.
+
+set -o errexit
+set -o nounset
+set -o pipefail
+
+cd "$(dirname "${BASH_SOURCE[0]}")/.."
+
+CALICO_VERSION=$(sed -n 's/^\t"calico": "\(.*\)",$/\1/p' cni.go)
+CILIUM_VERSION=$(sed -n 's/^\t"cilium": "\(.*\)",$/\1/p' cni.go)
+
+# escape keeps the rendered manifests intact when they are executed as Go templates.
+escape() {
+  sed 's/{{/{{"{{"}}/g'
+}
+
+# replace replaces the lines matching the pattern, failing if there are none.
+replace() {
+  local file=$1 pattern=$2 replacement=$3
+  grep -q "${pattern}" "${file}" || { echo "no match for ${pattern} in ${file}" >&2; exit 1; }
+  sed -i "s|${pattern}|${replacement}|" "${file}"
+}
+
+calico="templates/cni/calico-${CALICO_VERSION}.yaml"
+{
+  cat <<EOT
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: tigera-operator
+  labels:
+    name: tigera-operator
+---
+EOT
+  # The Installation is rendered by knest from templates/cni-calico.yaml with the pod network of the cluster.
+  helm template calico tigera-operator \
+    --repo https://projectcalico.docs.tigera.io/charts \
+    --version "${CALICO_VERSION}" \
+    --namespace tigera-operator \
+    --include-crds \
+    --set installation.enabled=false
+} | escape >"${calico}"
+
+cilium="templates/cni/cilium-${CILIUM_VERSION}.yaml"
+# Hubble certificates are issued in the cluster rather than generated here and embedded into knest.
+helm template cilium cilium \
+  --repo https://helm.cilium.io \
+  --version "${CILIUM_VERSION}" \
+  --namespace kube-system \
+  --set tunnel=vxlan \
+  --set ipam.mode=cluster-pool \
+  --set ipv4.enabled=true \
+  --set ipv6.enabled=true \
+  --set 'ipam.operator.clusterPoolIPv4PodCIDRList={KNEST_IPV4_POD_NETWORK_CIDR}' \
+  --set 'ipam.operator.clusterPoolIPv6PodCIDRList={KNEST_IPV6_POD_NETWORK_CIDR}' \
+  --set hubble.tls.auto.method=cronJob \
+  | escape >"${cilium}"
+replace "${cilium}" '^  tunnel: .*$' '  tunnel: {{ .Encapsulation }}'
+replace "${cilium}" '^  enable-ipv4: "true"$' '  enable-ipv4: "{{ if .IPv4PodNetworkCIDR }}true{{ else }}false{{ end }}"'
+replace "${cilium}" '^  enable-ipv6: "true"$' '  enable-ipv6: "{{ if .IPv6PodNetworkCIDR }}true{{ else }}false{{ end }}"'
+replace "${cilium}" 'KNEST_IPV4_POD_NETWORK_CIDR' '{{ .IPv4PodNetworkCIDR }}'
+replace "${cilium}" 'KNEST_IPV6_POD_NETWORK_CIDR' '{{ .IPv6PodNetworkCIDR }}'
+
+if grep -l "PRIVATE KEY\|tls.key:" "${calico}" "${cilium}"; then
+  echo "refusing to embed private keys" >&2
+  exit 1
+fi
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"text/template"
+)
+
+const (
+	ipamProviderAnnotation = "knest.smartx.com/ipam-provider"
+
+	metal3IPAMProvider    = "metal3"
+	inClusterIPAMProvider = "in-cluster"
+
+	inClusterIPPoolVersion        = "v1alpha2"
+	virtinkProviderServiceAccount = "system:serviceaccount:capch-system:capch-controller-manager"
+)
+
+// ipamPoolResources are the pool resources of the supported IPAM providers.
+var ipamPoolResources = map[string]string{
+	metal3IPAMProvider:    "ippools.ipam.metal3.io",
+	inClusterIPAMProvider: "inclusterippools.ipam.cluster.x-k8s.io",
+}
+
+// isIPAMProviderInstalled returns whether the pool resource of the given IPAM provider exists on the host cluster.
+func isIPAMProviderInstalled(provider string) (bool, error) {
+	crdOutput, err := getCommandOutput(exec.Command("kubectl", "get", "crd", ipamPoolResources[provider], "--ignore-not-found"))
+	if err != nil {
+		return false, fmt.Errorf("get %s IPAM provider CRDs: %s", provider, err)
+	}
+	return len(crdOutput) > 0, nil
+}
+
+// installIPAMProvider installs the given IPAM provider on the host cluster if it's missing.
+func installIPAMProvider(provider string) error {
+	installed, err := isIPAMProviderInstalled(provider)
+	if err != nil {
+		return err
+	}
+	if installed {
+		return nil
+	}
+
+	switch provider {
+	case metal3IPAMProvider:
+		fmt.Println("Installing ip-address-manager")
+		if err := runCommand(exec.Command("kubectl", "create", "namespace", "capm3-system")); err != nil {
+			return fmt.Errorf("create ip-address-manager namespace: %s", err)
+		}
+		if err := runCommand(exec.Command("kubectl", "apply", "-f", fmt.Sprintf("https://github.com/metal3-io/ip-address-manager/releases/download/%s/ipam-components.yaml", IPAddressManagerVersion))); err != nil {
+			return fmt.Errorf("install ip-address-manager: %s", err)
+		}
+
+		fmt.Println("Waiting for ip-address-manager to be available...")
+		if err := runCommand(exec.Command("kubectl", "wait", "-n", "capm3-system", "deployment", "ipam-controller-manager", "--for", "condition=Available", "--timeout", "-1s")); err != nil {
+			return fmt.Errorf("wait for ip-address-manager to be available: %s", err)
+		}
+	case inClusterIPAMProvider:
+		fmt.Println("Installing Cluster API in-cluster IPAM provider")
+		if err := runCommand(exec.Command("clusterctl", "init", "--ipam", fmt.Sprintf("in-cluster:%s", InClusterIPAMProviderVersion), "--wait-providers")); err != nil {
+			return fmt.Errorf("install in-cluster IPAM provider: %s", err)
+		}
+	default:
+		return fmt.Errorf("unsupported IPAM provider: %s", provider)
+	}
+	return nil
+}
+
+// checkInClusterIPAMSupport makes sure InClusterIPPools are served and claimable by the infrastructure provider.
+func checkInClusterIPAMSupport() error {
+	versionsOutput, err := getCommandOutput(exec.Command("kubectl", "get", "crd", ipamPoolResources[inClusterIPAMProvider],
+		"-o", "jsonpath={.spec.versions[?(@.served==true)].name}"))
+	if err != nil {
+		return fmt.Errorf("get in-cluster IPAM provider CRDs: %s", err)
+	}
+	served := false
+	for _, version := range strings.Fields(versionsOutput) {
+		if version == inClusterIPPoolVersion {
+			served = true
+		}
+	}
+	if !served {
+		return fmt.Errorf("the installed in-cluster IPAM provider doesn't serve InClusterIPPool %s", inClusterIPPoolVersion)
+	}
+
+	canClaimOutput, _ := exec.Command("kubectl", "auth", "can-i", "create", "ipaddressclaims.ipam.cluster.x-k8s.io",
+		"--all-namespaces", "--as", virtinkProviderServiceAccount).Output()
+	if strings.TrimSpace(string(canClaimOutput)) != "yes" {
+		return fmt.Errorf("the installed cluster-api-provider-virtink doesn't support IPAddressClaims, use the %s IPAM provider instead", metal3IPAMProvider)
+	}
+	return nil
+}
+
+// validateIPAMConfig checks the IPPool configuration against the features of the given IPAM provider.
+func validateIPAMConfig(provider string, ranges []*ipPoolRange, config *ipPoolConfig) error {
+	switch provider {
+	case metal3IPAMProvider:
+		return nil
+	case inClusterIPAMProvider:
+		if len(config.DNSServers) > 0 || len(config.PreAllocations) > 0 {
+			return fmt.Errorf("DNS servers and pre-allocations are not supported by the in-cluster IPAM provider")
+		}
+		if config.Prefix == 0 {
+			for _, r := range ranges {
+				if r.Prefix != 0 {
+					config.Prefix = r.Prefix
+					break
+				}
+			}
+		}
+		if config.Prefix == 0 {
+			return fmt.Errorf("a network prefix length is required by the in-cluster IPAM provider")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported IPAM provider: %s", provider)
+	}
+}
+
+// renderIPAMPool renders the pool resource of the given IPAM provider for a cluster.
+func renderIPAMPool(provider string, name string, namespace string, clusterName string, ranges []*ipPoolRange, config *ipPoolConfig) ([]byte, error) {
+	if provider == metal3IPAMProvider {
+		return renderIPPool(name, namespace, clusterName, ranges, config)
+	}
+
+	data := struct {
+		Version     string
+		Name        string
+		Namespace   string
+		ClusterName string
+		Pools       []*ipPoolRange
+		Config      *ipPoolConfig
+	}{
+		Version:     inClusterIPPoolVersion,
+		Name:        name,
+		Namespace:   namespace,
+		ClusterName: clusterName,
+		Pools:       ranges,
+		Config:      config,
+	}
+
+	buf := &bytes.Buffer{}
+	if err := template.Must(template.New("inclusterippool.yaml").ParseFS(templatesFS, "templates/inclusterippool.yaml")).Execute(buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// newIPPoolRefPatch returns a kustomization pointing the machine templates to the pool of the IPAM provider.
+func newIPPoolRefPatch(provider string, ipPoolName string) ([]byte, error) {
+	ipPoolRef := map[string]interface{}{
+		"name": ipPoolName,
+	}
+	switch provider {
+	case metal3IPAMProvider:
+		ipPoolRef["apiGroup"] = "ipam.metal3.io"
+		ipPoolRef["kind"] = "IPPool"
+	case inClusterIPAMProvider:
+		ipPoolRef["apiGroup"] = "ipam.cluster.x-k8s.io"
+		ipPoolRef["kind"] = "InClusterIPPool"
+	default:
+		return nil, fmt.Errorf("unsupported IPAM provider: %s", provider)
+	}
+
+	return newKustomization("VirtinkMachineTemplate", map[string]interface{}{
+		"apiVersion": "infrastructure.cluster.x-k8s.io/v1beta1",
+		"kind":       "VirtinkMachineTemplate",
+		"metadata": map[string]interface{}{
+			"name": "not-used",
+		},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"ipPoolRef": ipPoolRef,
+				},
+			},
+		},
+	})
+}
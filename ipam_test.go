@@ -0,0 +1,192 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestValidateIPAMConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		provider   string
+		addrs      []string
+		config     ipPoolConfig
+		wantPrefix int
+		wantErr    bool
+	}{{
+		name:     "metal3 accepts any config",
+		provider: metal3IPAMProvider,
+		addrs:    []string{"172.22.127.100-172.22.127.200"},
+		config:   ipPoolConfig{DNSServers: []string{"8.8.8.8"}, PreAllocations: map[string]string{"a": "172.22.127.100"}},
+	}, {
+		name:       "in-cluster with prefix",
+		provider:   inClusterIPAMProvider,
+		addrs:      []string{"172.22.127.100-172.22.127.200"},
+		config:     ipPoolConfig{Prefix: 24, Gateway: "172.22.127.1"},
+		wantPrefix: 24,
+	}, {
+		name:       "in-cluster takes prefix of subnet",
+		provider:   inClusterIPAMProvider,
+		addrs:      []string{"172.22.127.100", "172.22.128.0/24"},
+		wantPrefix: 24,
+	}, {
+		name:     "in-cluster requires prefix",
+		provider: inClusterIPAMProvider,
+		addrs:    []string{"172.22.127.100-172.22.127.200"},
+		wantErr:  true,
+	}, {
+		name:     "in-cluster rejects DNS servers",
+		provider: inClusterIPAMProvider,
+		addrs:    []string{"172.22.127.0/24"},
+		config:   ipPoolConfig{DNSServers: []string{"8.8.8.8"}},
+		wantErr:  true,
+	}, {
+		name:     "in-cluster rejects pre-allocations",
+		provider: inClusterIPAMProvider,
+		addrs:    []string{"172.22.127.0/24"},
+		config:   ipPoolConfig{PreAllocations: map[string]string{"a": "172.22.127.100"}},
+		wantErr:  true,
+	}, {
+		name:     "unsupported provider",
+		provider: "infoblox",
+		addrs:    []string{"172.22.127.0/24"},
+		wantErr:  true,
+	}}
+
+	for _, tt := range tests {
+		ranges, err := parseIPPoolRanges(tt.addrs)
+		if err != nil {
+			t.Fatalf("%s: parseIPPoolRanges() error = %v", tt.name, err)
+		}
+		err = validateIPAMConfig(tt.provider, ranges, &tt.config)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateIPAMConfig() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && tt.config.Prefix != tt.wantPrefix {
+			t.Errorf("%s: validateIPAMConfig() prefix = %d, want %d", tt.name, tt.config.Prefix, tt.wantPrefix)
+		}
+	}
+}
+
+func TestRenderIPAMPool(t *testing.T) {
+	ranges, err := parseIPPoolRanges([]string{"172.22.127.100-172.22.127.200", "172.22.128.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		clusterName string
+		config      ipPoolConfig
+		want        map[string]interface{}
+	}{{
+		name:        "cluster pool",
+		clusterName: "foo",
+		config:      ipPoolConfig{Prefix: 24, Gateway: "172.22.127.1"},
+		want: map[string]interface{}{
+			"apiVersion": "ipam.cluster.x-k8s.io/" + inClusterIPPoolVersion,
+			"kind":       "InClusterIPPool",
+			"metadata": map[string]interface{}{
+				"name":      "foo",
+				"namespace": "default",
+				"labels":    map[string]interface{}{clusterNameLabel: "foo"},
+			},
+			"spec": map[string]interface{}{
+				"addresses": []interface{}{"172.22.127.100-172.22.127.200", "172.22.128.0/24"},
+				"prefix":    24,
+				"gateway":   "172.22.127.1",
+			},
+		},
+	}, {
+		name:   "without cluster and gateway",
+		config: ipPoolConfig{Prefix: 24},
+		want: map[string]interface{}{
+			"apiVersion": "ipam.cluster.x-k8s.io/" + inClusterIPPoolVersion,
+			"kind":       "InClusterIPPool",
+			"metadata": map[string]interface{}{
+				"name":      "foo",
+				"namespace": "default",
+			},
+			"spec": map[string]interface{}{
+				"addresses": []interface{}{"172.22.127.100-172.22.127.200", "172.22.128.0/24"},
+				"prefix":    24,
+			},
+		},
+	}}
+
+	for _, tt := range tests {
+		data, err := renderIPAMPool(inClusterIPAMProvider, "foo", "default", tt.clusterName, ranges, &tt.config)
+		if err != nil {
+			t.Errorf("%s: renderIPAMPool() error = %v", tt.name, err)
+			continue
+		}
+		var got map[string]interface{}
+		if err := yaml.Unmarshal(data, &got); err != nil {
+			t.Errorf("%s: renderIPAMPool() = %s, not valid YAML: %v", tt.name, data, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: renderIPAMPool() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNewIPPoolRefPatch(t *testing.T) {
+	tests := []struct {
+		provider     string
+		wantAPIGroup string
+		wantKind     string
+		wantErr      bool
+	}{{
+		provider:     metal3IPAMProvider,
+		wantAPIGroup: "ipam.metal3.io",
+		wantKind:     "IPPool",
+	}, {
+		provider:     inClusterIPAMProvider,
+		wantAPIGroup: "ipam.cluster.x-k8s.io",
+		wantKind:     "InClusterIPPool",
+	}, {
+		provider: "infoblox",
+		wantErr:  true,
+	}}
+
+	for _, tt := range tests {
+		data, err := newIPPoolRefPatch(tt.provider, "shared")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("newIPPoolRefPatch(%q) error = %v, wantErr %v", tt.provider, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+
+		var kustomization struct {
+			Patches []kustomizationPatch `yaml:"patches"`
+		}
+		if err := yaml.Unmarshal(data, &kustomization); err != nil {
+			t.Fatalf("newIPPoolRefPatch(%q) = %s, not a kustomization: %v", tt.provider, data, err)
+		}
+		if len(kustomization.Patches) != 1 || kustomization.Patches[0].Target == nil || kustomization.Patches[0].Target.Kind != "VirtinkMachineTemplate" {
+			t.Fatalf("newIPPoolRefPatch(%q) patches = %v, want one patch of VirtinkMachineTemplate", tt.provider, kustomization.Patches)
+		}
+		var patch struct {
+			Spec struct {
+				Template struct {
+					Spec struct {
+						IPPoolRef map[string]string `yaml:"ipPoolRef"`
+					} `yaml:"spec"`
+				} `yaml:"template"`
+			} `yaml:"spec"`
+		}
+		if err := yaml.Unmarshal([]byte(kustomization.Patches[0].Patch), &patch); err != nil {
+			t.Fatalf("newIPPoolRefPatch(%q) patch = %s: %v", tt.provider, kustomization.Patches[0].Patch, err)
+		}
+		want := map[string]string{"apiGroup": tt.wantAPIGroup, "kind": tt.wantKind, "name": "shared"}
+		if got := patch.Spec.Template.Spec.IPPoolRef; !reflect.DeepEqual(got, want) {
+			t.Errorf("newIPPoolRefPatch(%q) ipPoolRef = %v, want %v", tt.provider, got, want)
+		}
+	}
+}
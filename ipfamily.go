@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+var (
+	defaultPodNetworkCIDRs = map[string]string{
+		"ipv4": "192.168.0.0/16",
+		"ipv6": "fd00:10:244::/56",
+		"dual": "192.168.0.0/16,fd00:10:244::/56",
+	}
+	defaultServiceCIDRs = map[string]string{
+		"ipv4": "10.96.0.0/12",
+		"ipv6": "fd00:10:96::/108",
+		"dual": "10.96.0.0/12,fd00:10:96::/108",
+	}
+)
+
+// parseCIDRs parses comma-separated CIDRs of the given IP family, the first being the primary one.
+func parseCIDRs(ipFamily string, value string, flagName string) ([]string, error) {
+	var cidrs []string
+	var ipv4Count, ipv6Count int
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		ip, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --%s %q: %s", flagName, item, err)
+		}
+		if isIPv4(ip) {
+			ipv4Count++
+		} else {
+			ipv6Count++
+		}
+		cidrs = append(cidrs, ipNet.String())
+	}
+
+	switch ipFamily {
+	case "ipv4":
+		if ipv4Count != 1 || ipv6Count != 0 {
+			return nil, fmt.Errorf("--%s should be a single IPv4 CIDR for IP family %q", flagName, ipFamily)
+		}
+	case "ipv6":
+		if ipv4Count != 0 || ipv6Count != 1 {
+			return nil, fmt.Errorf("--%s should be a single IPv6 CIDR for IP family %q", flagName, ipFamily)
+		}
+	case "dual":
+		if ipv4Count != 1 || ipv6Count != 1 {
+			return nil, fmt.Errorf("--%s should be an IPv4 CIDR and an IPv6 CIDR separated by comma for IP family %q", flagName, ipFamily)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported IP family: %s", ipFamily)
+	}
+	return cidrs, nil
+}
+
+// splitCIDRsByFamily returns the IPv4 and IPv6 CIDR among the given CIDRs, either of which may be empty.
+func splitCIDRsByFamily(cidrs []string) (string, string) {
+	var ipv4CIDR, ipv6CIDR string
+	for _, cidr := range cidrs {
+		ip, _, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		if isIPv4(ip) {
+			ipv4CIDR = cidr
+		} else {
+			ipv6CIDR = cidr
+		}
+	}
+	return ipv4CIDR, ipv6CIDR
+}
+
+// unbracketHost strips the brackets of an IPv6 host, which net.JoinHostPort adds back.
+func unbracketHost(host string) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
+
+func isIPv4CIDR(cidr string) bool {
+	ip, _, err := net.ParseCIDR(cidr)
+	return err == nil && isIPv4(ip)
+}
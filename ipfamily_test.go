@@ -0,0 +1,78 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	tests := []struct {
+		ipFamily string
+		value    string
+		want     []string
+		wantErr  bool
+	}{{
+		ipFamily: "ipv4",
+		value:    "192.168.0.0/16",
+		want:     []string{"192.168.0.0/16"},
+	}, {
+		ipFamily: "ipv4",
+		value:    "192.168.1.1/16",
+		want:     []string{"192.168.0.0/16"},
+	}, {
+		ipFamily: "ipv6",
+		value:    "fd00:10:244::/56",
+		want:     []string{"fd00:10:244::/56"},
+	}, {
+		ipFamily: "dual",
+		value:    "192.168.0.0/16, fd00:10:244::/56",
+		want:     []string{"192.168.0.0/16", "fd00:10:244::/56"},
+	}, {
+		ipFamily: "dual",
+		value:    "fd00:10:244::/56,192.168.0.0/16",
+		want:     []string{"fd00:10:244::/56", "192.168.0.0/16"},
+	}, {
+		ipFamily: "ipv4",
+		value:    "fd00:10:244::/56",
+		wantErr:  true,
+	}, {
+		ipFamily: "ipv4",
+		value:    "192.168.0.0/16,10.0.0.0/8",
+		wantErr:  true,
+	}, {
+		ipFamily: "ipv6",
+		value:    "192.168.0.0/16",
+		wantErr:  true,
+	}, {
+		ipFamily: "dual",
+		value:    "192.168.0.0/16",
+		wantErr:  true,
+	}, {
+		ipFamily: "dual",
+		value:    "192.168.0.0/16,10.0.0.0/8",
+		wantErr:  true,
+	}, {
+		ipFamily: "ipv4",
+		value:    "192.168.0.0",
+		wantErr:  true,
+	}, {
+		ipFamily: "ipv4",
+		value:    "",
+		wantErr:  true,
+	}, {
+		ipFamily: "ipv5",
+		value:    "192.168.0.0/16",
+		wantErr:  true,
+	}}
+
+	for _, tt := range tests {
+		got, err := parseCIDRs(tt.ipFamily, tt.value, "pod-network-cidr")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCIDRs(%q, %q) error = %v, wantErr %v", tt.ipFamily, tt.value, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseCIDRs(%q, %q) = %v, want %v", tt.ipFamily, tt.value, got, tt.want)
+		}
+	}
+}
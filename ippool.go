@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+	"strings"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	ipPoolAnnotation  = "knest.smartx.com/ip-pool"
+	sharedIPPoolLabel = "knest.smartx.com/shared-ip-pool"
+)
+
+type ipPoolRange struct {
+	Start  string
+	End    string
+	Subnet string
+	Prefix int
+
+	first net.IP
+	last  net.IP
+}
+
+func (r *ipPoolRange) String() string {
+	if r.Subnet != "" {
+		return r.Subnet
+	}
+	return fmt.Sprintf("%s-%s", r.Start, r.End)
+}
+
+func (r *ipPoolRange) Contains(ip net.IP) bool {
+	return isIPv4(ip) == isIPv4(r.first) && compareIPs(ip, r.first) >= 0 && compareIPs(ip, r.last) <= 0
+}
+
+// Size returns the number of addresses within the range.
+func (r *ipPoolRange) Size() *big.Int {
+	size := new(big.Int).Sub(new(big.Int).SetBytes(r.last.To16()), new(big.Int).SetBytes(r.first.To16()))
+	return size.Add(size, big.NewInt(1))
+}
+
+type ipPoolConfig struct {
+	Prefix         int
+	Gateway        string
+	DNSServers     []string
+	PreAllocations map[string]string
+}
+
+// parseIPPoolRange parses a machine address which can be a "START-END" range, a CIDR subnet or a single IP address.
+func parseIPPoolRange(addr string) (*ipPoolRange, error) {
+	if strings.Contains(addr, "/") {
+		ip, ipNet, err := net.ParseCIDR(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid machine address %q: %s", addr, err)
+		}
+		if !ip.Equal(ipNet.IP) {
+			return nil, fmt.Errorf("invalid machine address %q: host bits are set, do you mean %s?", addr, ipNet.String())
+		}
+		ones, _ := ipNet.Mask.Size()
+		return &ipPoolRange{
+			Subnet: ipNet.String(),
+			Prefix: ones,
+			first:  ipNet.IP,
+			last:   lastIPOfNet(ipNet),
+		}, nil
+	}
+
+	startStr, endStr := addr, addr
+	if strings.Contains(addr, "-") {
+		items := strings.Split(addr, "-")
+		if len(items) != 2 {
+			return nil, fmt.Errorf("invalid machine address %q: expect START-END", addr)
+		}
+		startStr, endStr = strings.TrimSpace(items[0]), strings.TrimSpace(items[1])
+	}
+	start := net.ParseIP(startStr)
+	if start == nil {
+		return nil, fmt.Errorf("invalid machine address %q: invalid IP address %q", addr, startStr)
+	}
+	end := net.ParseIP(endStr)
+	if end == nil {
+		return nil, fmt.Errorf("invalid machine address %q: invalid IP address %q", addr, endStr)
+	}
+	if isIPv4(start) != isIPv4(end) {
+		return nil, fmt.Errorf("invalid machine address %q: start and end are of different IP families", addr)
+	}
+	if compareIPs(start, end) > 0 {
+		return nil, fmt.Errorf("invalid machine address %q: start is greater than end", addr)
+	}
+	return &ipPoolRange{
+		Start: start.String(),
+		End:   end.String(),
+		first: start,
+		last:  end,
+	}, nil
+}
+
+// parseIPPoolRanges parses machine addresses of the same IP family which do not overlap.
+func parseIPPoolRanges(addrs []string) ([]*ipPoolRange, error) {
+	var ranges []*ipPoolRange
+	for _, addr := range addrs {
+		r, err := parseIPPoolRange(addr)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range ranges {
+			if isIPv4(r.first) != isIPv4(other.first) {
+				return nil, fmt.Errorf("machine addresses %s and %s are of different IP families", other, r)
+			}
+			if compareIPs(r.first, other.last) <= 0 && compareIPs(other.first, r.last) <= 0 {
+				return nil, fmt.Errorf("machine addresses %s and %s overlap", other, r)
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// validateIPPoolConfig checks the gateway, DNS servers and pre-allocated addresses of an IPPool against its ranges.
+func validateIPPoolConfig(ranges []*ipPoolRange, config *ipPoolConfig) error {
+	if len(ranges) == 0 {
+		return nil
+	}
+	ipv4 := isIPv4(ranges[0].first)
+	maxPrefix := 128
+	if ipv4 {
+		maxPrefix = 32
+	}
+	if config.Prefix < 0 || config.Prefix > maxPrefix {
+		return fmt.Errorf("invalid machine address prefix %d: expect 0 to %d", config.Prefix, maxPrefix)
+	}
+
+	if config.Gateway != "" {
+		gateway := net.ParseIP(config.Gateway)
+		if gateway == nil {
+			return fmt.Errorf("invalid machine gateway %q", config.Gateway)
+		}
+		if isIPv4(gateway) != ipv4 {
+			return fmt.Errorf("machine gateway %s is of a different IP family from machine addresses", config.Gateway)
+		}
+		for _, r := range ranges {
+			if r.Contains(gateway) {
+				return fmt.Errorf("machine gateway %s must not be within machine addresses %s", config.Gateway, r)
+			}
+			prefix := config.Prefix
+			if prefix == 0 {
+				prefix = r.Prefix
+			}
+			if prefix == 0 {
+				continue
+			}
+			ipNet := &net.IPNet{IP: r.first, Mask: net.CIDRMask(prefix, maxPrefix)}
+			if !ipNet.Contains(gateway) || !ipNet.Contains(r.last) {
+				return fmt.Errorf("machine gateway %s and machine addresses %s are not in the same /%d network", config.Gateway, r, prefix)
+			}
+		}
+	}
+
+	for _, dnsServer := range config.DNSServers {
+		if net.ParseIP(dnsServer) == nil {
+			return fmt.Errorf("invalid machine DNS server %q", dnsServer)
+		}
+	}
+
+	for name, addr := range config.PreAllocations {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return fmt.Errorf("invalid pre-allocated address %q for %q", addr, name)
+		}
+		found := false
+		for _, r := range ranges {
+			if r.Contains(ip) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("pre-allocated address %s for %q is not within machine addresses", addr, name)
+		}
+	}
+	return nil
+}
+
+// parsePreAllocations parses "CLAIM=IP" pairs into a map of IPClaim names to IP addresses.
+func parsePreAllocations(specs []string) (map[string]string, error) {
+	preAllocations := map[string]string{}
+	for _, spec := range specs {
+		items := strings.SplitN(spec, "=", 2)
+		if len(items) != 2 || items[0] == "" || items[1] == "" {
+			return nil, fmt.Errorf("invalid pre-allocation %q: expect CLAIM=IP", spec)
+		}
+		preAllocations[items[0]] = items[1]
+	}
+	return preAllocations, nil
+}
+
+// checkIPPoolRangesAgainstNodes makes sure no host cluster node address is within the ranges.
+func checkIPPoolRangesAgainstNodes(ranges []*ipPoolRange) error {
+	nodes, err := getObjects("nodes")
+	if err != nil {
+		return fmt.Errorf("get nodes: %s", err)
+	}
+	for _, node := range nodes {
+		addresses, _, _ := unstructured.NestedSlice(node.Object, "status", "addresses")
+		for _, address := range addresses {
+			a, _ := address.(map[string]interface{})
+			if a["type"] != "InternalIP" && a["type"] != "ExternalIP" {
+				continue
+			}
+			addr, _ := a["address"].(string)
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				continue
+			}
+			for _, r := range ranges {
+				if r.Contains(ip) {
+					return fmt.Errorf("machine addresses %s overlap with address %s of host node %q", r, addr, node.GetName())
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// renderIPPool renders a metal3 IPPool, labeled as shared if it has no clusterName.
+func renderIPPool(name string, namespace string, clusterName string, ranges []*ipPoolRange, config *ipPoolConfig) ([]byte, error) {
+	var preAllocationNames []string
+	for preAllocationName := range config.PreAllocations {
+		preAllocationNames = append(preAllocationNames, preAllocationName)
+	}
+	sort.Strings(preAllocationNames)
+
+	data := struct {
+		Name               string
+		Namespace          string
+		ClusterName        string
+		SharedLabel        string
+		Pools              []*ipPoolRange
+		Config             *ipPoolConfig
+		PreAllocationNames []string
+	}{
+		Name:               name,
+		Namespace:          namespace,
+		ClusterName:        clusterName,
+		SharedLabel:        sharedIPPoolLabel,
+		Pools:              ranges,
+		Config:             config,
+		PreAllocationNames: preAllocationNames,
+	}
+
+	buf := &bytes.Buffer{}
+	if err := template.Must(template.New("ippool.yaml").ParseFS(templatesFS, "templates/ippool.yaml")).Execute(buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func isIPv4(ip net.IP) bool {
+	return ip.To4() != nil
+}
+
+func compareIPs(a net.IP, b net.IP) int {
+	return new(big.Int).SetBytes(a.To16()).Cmp(new(big.Int).SetBytes(b.To16()))
+}
+
+func lastIPOfNet(ipNet *net.IPNet) net.IP {
+	ip := make(net.IP, len(ipNet.IP))
+	for i := range ipNet.IP {
+		ip[i] = ipNet.IP[i] | ^ipNet.Mask[i]
+	}
+	return ip
+}
+
+// getSharedIPPool returns a shared IPPool and its ranges.
+func getSharedIPPool(namespace string, name string) (*unstructured.Unstructured, []*ipPoolRange, error) {
+	ipPool, err := getObject("ippools.ipam.metal3.io", name, "--namespace", namespace)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get IPPool %q: %s", name, err)
+	}
+	if ipPool.GetLabels()[sharedIPPoolLabel] != "true" {
+		return nil, nil, fmt.Errorf("IPPool %q is not a shared IP pool", name)
+	}
+	ranges, _, err := getIPPoolRanges(ipPool)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ipPool, ranges, nil
+}
+
+// getIPPoolClusters returns the names of the clusters drawing addresses from the given IPPool.
+func getIPPoolClusters(namespace string, ipPoolName string) ([]string, error) {
+	clusters, err := getObjects("clusters.cluster.x-k8s.io", "--namespace", namespace)
+	if err != nil {
+		return nil, fmt.Errorf("get clusters: %s", err)
+	}
+	var clusterNames []string
+	for _, cluster := range clusters {
+		if cluster.GetAnnotations()[ipPoolAnnotation] == ipPoolName {
+			clusterNames = append(clusterNames, cluster.GetName())
+		}
+	}
+	return clusterNames, nil
+}
+
+// checkIPPoolReplaceable refuses to replace a pool which is shared or in use by other clusters.
+func checkIPPoolReplaceable(resource string, namespace string, name string, clusterName string) error {
+	ipPools, err := getObjects(resource, "--namespace", namespace, "--field-selector", fmt.Sprintf("metadata.name=%s", name))
+	if err != nil {
+		return fmt.Errorf("get IPPool %q: %s", name, err)
+	}
+	if len(ipPools) == 0 {
+		return nil
+	}
+	clusterNames, err := getIPPoolClusters(namespace, name)
+	if err != nil {
+		return err
+	}
+	return validateIPPoolReplacement(ipPools[0], clusterNames, clusterName)
+}
+
+// validateIPPoolReplacement refuses to replace a shared pool, or a pool drawn from by clusters other than clusterName.
+func validateIPPoolReplacement(ipPool *unstructured.Unstructured, clusterNames []string, clusterName string) error {
+	if ipPool.GetLabels()[sharedIPPoolLabel] == "true" {
+		return fmt.Errorf("IPPool %q is a shared IP pool, use --ip-pool to draw addresses from it", ipPool.GetName())
+	}
+	for _, n := range clusterNames {
+		if n != clusterName {
+			return fmt.Errorf("IPPool %q is in use by cluster %q", ipPool.GetName(), n)
+		}
+	}
+	return nil
+}
+
+// getClusterIPClaims returns the names of the IPClaims made for the machines of a cluster.
+func getClusterIPClaims(namespace string, clusterName string) ([]string, error) {
+	virtinkMachines, err := getClusterOwnedObjects("virtinkmachines.infrastructure.cluster.x-k8s.io", namespace, clusterName)
+	if err != nil {
+		return nil, fmt.Errorf("get VirtinkMachines: %s", err)
+	}
+	virtinkMachineNames := map[string]bool{}
+	for _, virtinkMachine := range virtinkMachines {
+		virtinkMachineNames[virtinkMachine.GetName()] = true
+	}
+
+	ipClaims, err := getObjects("ipclaims.ipam.metal3.io", "--namespace", namespace)
+	if err != nil {
+		return nil, fmt.Errorf("get IPClaims: %s", err)
+	}
+	var ipClaimNames []string
+	for _, ipClaim := range ipClaims {
+		for _, ownerRef := range ipClaim.GetOwnerReferences() {
+			if ownerRef.Kind == "VirtinkMachine" && virtinkMachineNames[ownerRef.Name] {
+				ipClaimNames = append(ipClaimNames, ipClaim.GetName())
+				break
+			}
+		}
+	}
+	return ipClaimNames, nil
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestParseIPPoolRange(t *testing.T) {
+	tests := []struct {
+		addr       string
+		wantString string
+		wantPrefix int
+		wantSize   int64
+		wantErr    bool
+	}{{
+		addr:       "172.22.127.100-172.22.127.200",
+		wantString: "172.22.127.100-172.22.127.200",
+		wantSize:   101,
+	}, {
+		addr:       "172.22.127.100 - 172.22.127.100",
+		wantString: "172.22.127.100-172.22.127.100",
+		wantSize:   1,
+	}, {
+		addr:       "172.22.127.100",
+		wantString: "172.22.127.100-172.22.127.100",
+		wantSize:   1,
+	}, {
+		addr:       "172.22.127.0/24",
+		wantString: "172.22.127.0/24",
+		wantPrefix: 24,
+		wantSize:   256,
+	}, {
+		addr:       "fd00:10::1-fd00:10::ff",
+		wantString: "fd00:10::1-fd00:10::ff",
+		wantSize:   255,
+	}, {
+		addr:       "fd00:10::/120",
+		wantString: "fd00:10::/120",
+		wantPrefix: 120,
+		wantSize:   256,
+	}, {
+		addr:    "172.22.127.1/24",
+		wantErr: true,
+	}, {
+		addr:    "172.22.127.200-172.22.127.100",
+		wantErr: true,
+	}, {
+		addr:    "172.22.127.100-fd00:10::1",
+		wantErr: true,
+	}, {
+		addr:    "172.22.127.100-172.22.127.150-172.22.127.200",
+		wantErr: true,
+	}, {
+		addr:    "172.22.127.300",
+		wantErr: true,
+	}, {
+		addr:    "172.22.127.0/33",
+		wantErr: true,
+	}}
+
+	for _, tt := range tests {
+		got, err := parseIPPoolRange(tt.addr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseIPPoolRange(%q) error = %v, wantErr %v", tt.addr, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if got.String() != tt.wantString || got.Prefix != tt.wantPrefix || got.Size().Int64() != tt.wantSize {
+			t.Errorf("parseIPPoolRange(%q) = %s with prefix %d and size %s, want %s with prefix %d and size %d",
+				tt.addr, got, got.Prefix, got.Size(), tt.wantString, tt.wantPrefix, tt.wantSize)
+		}
+	}
+}
+
+func TestParseIPPoolRanges(t *testing.T) {
+	tests := []struct {
+		addrs   []string
+		wantErr bool
+	}{{
+		addrs: []string{"172.22.127.100-172.22.127.149", "172.22.127.150-172.22.127.200"},
+	}, {
+		addrs:   []string{"172.22.127.100-172.22.127.150", "172.22.127.150-172.22.127.200"},
+		wantErr: true,
+	}, {
+		addrs:   []string{"172.22.127.0/24", "172.22.127.100"},
+		wantErr: true,
+	}, {
+		addrs:   []string{"172.22.127.100", "fd00:10::1"},
+		wantErr: true,
+	}}
+
+	for _, tt := range tests {
+		_, err := parseIPPoolRanges(tt.addrs)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseIPPoolRanges(%q) error = %v, wantErr %v", tt.addrs, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateIPPoolConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		addrs   []string
+		config  *ipPoolConfig
+		wantErr bool
+	}{{
+		name:   "gateway in the network",
+		addrs:  []string{"172.22.127.0/25"},
+		config: &ipPoolConfig{Gateway: "172.22.127.254", Prefix: 24, DNSServers: []string{"8.8.8.8"}},
+	}, {
+		name:    "gateway outside of the subnet prefix",
+		addrs:   []string{"172.22.127.0/25"},
+		config:  &ipPoolConfig{Gateway: "172.22.127.254"},
+		wantErr: true,
+	}, {
+		name:    "gateway outside of the subnet",
+		addrs:   []string{"172.22.127.0/25"},
+		config:  &ipPoolConfig{Gateway: "172.22.128.1", Prefix: 24},
+		wantErr: true,
+	}, {
+		name:    "gateway within machine addresses",
+		addrs:   []string{"172.22.127.100-172.22.127.200"},
+		config:  &ipPoolConfig{Gateway: "172.22.127.150", Prefix: 24},
+		wantErr: true,
+	}, {
+		name:    "gateway of a different IP family",
+		addrs:   []string{"172.22.127.100-172.22.127.200"},
+		config:  &ipPoolConfig{Gateway: "fd00:10::1"},
+		wantErr: true,
+	}, {
+		name:    "prefix too long",
+		addrs:   []string{"172.22.127.100-172.22.127.200"},
+		config:  &ipPoolConfig{Prefix: 33},
+		wantErr: true,
+	}, {
+		name:    "invalid DNS server",
+		addrs:   []string{"172.22.127.100-172.22.127.200"},
+		config:  &ipPoolConfig{DNSServers: []string{"dns.example.com"}},
+		wantErr: true,
+	}, {
+		name:   "pre-allocation within machine addresses",
+		addrs:  []string{"172.22.127.100-172.22.127.200"},
+		config: &ipPoolConfig{PreAllocations: map[string]string{"foo-cp-1": "172.22.127.100"}},
+	}, {
+		name:    "pre-allocation outside of machine addresses",
+		addrs:   []string{"172.22.127.100-172.22.127.200"},
+		config:  &ipPoolConfig{PreAllocations: map[string]string{"foo-cp-1": "172.22.127.99"}},
+		wantErr: true,
+	}}
+
+	for _, tt := range tests {
+		ranges, err := parseIPPoolRanges(tt.addrs)
+		if err != nil {
+			t.Fatalf("%s: parseIPPoolRanges() error = %v", tt.name, err)
+		}
+		if err := validateIPPoolConfig(ranges, tt.config); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateIPPoolConfig() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParsePreAllocations(t *testing.T) {
+	tests := []struct {
+		specs   []string
+		want    map[string]string
+		wantErr bool
+	}{{
+		specs: []string{"foo-cp-1=172.22.127.100", "foo-md-0=172.22.127.101"},
+		want:  map[string]string{"foo-cp-1": "172.22.127.100", "foo-md-0": "172.22.127.101"},
+	}, {
+		want: map[string]string{},
+	}, {
+		specs:   []string{"172.22.127.100"},
+		wantErr: true,
+	}, {
+		specs:   []string{"=172.22.127.100"},
+		wantErr: true,
+	}, {
+		specs:   []string{"foo-cp-1="},
+		wantErr: true,
+	}}
+
+	for _, tt := range tests {
+		got, err := parsePreAllocations(tt.specs)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parsePreAllocations(%q) error = %v, wantErr %v", tt.specs, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parsePreAllocations(%q) = %v, want %v", tt.specs, got, tt.want)
+		}
+	}
+}
+
+func TestRenderIPPool(t *testing.T) {
+	ranges, err := parseIPPoolRanges([]string{"172.22.127.0/28", "172.22.127.100-172.22.127.200"})
+	if err != nil {
+		t.Fatalf("parseIPPoolRanges() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		clusterName string
+		config      *ipPoolConfig
+		wantLabels  map[string]string
+		wantSpec    map[string]interface{}
+	}{{
+		name:        "cluster pool",
+		clusterName: "foo",
+		config:      &ipPoolConfig{Gateway: "172.22.127.254", DNSServers: []string{"8.8.8.8"}},
+		wantSpec: map[string]interface{}{
+			"clusterName": "foo",
+			"namePrefix":  "pool",
+			"gateway":     "172.22.127.254",
+			"dnsServers":  []interface{}{"8.8.8.8"},
+			"pools": []interface{}{
+				map[string]interface{}{"subnet": "172.22.127.0/28", "prefix": int64(28)},
+				map[string]interface{}{"start": "172.22.127.100", "end": "172.22.127.200"},
+			},
+		},
+	}, {
+		name:       "shared pool",
+		config:     &ipPoolConfig{Prefix: 24, PreAllocations: map[string]string{"foo-cp-1": "172.22.127.100"}},
+		wantLabels: map[string]string{sharedIPPoolLabel: "true"},
+		wantSpec: map[string]interface{}{
+			"namePrefix":     "pool",
+			"prefix":         int64(24),
+			"preAllocations": map[string]interface{}{"foo-cp-1": "172.22.127.100"},
+			"pools": []interface{}{
+				map[string]interface{}{"subnet": "172.22.127.0/28"},
+				map[string]interface{}{"start": "172.22.127.100", "end": "172.22.127.200"},
+			},
+		},
+	}}
+
+	for _, tt := range tests {
+		data, err := renderIPPool("pool", "default", tt.clusterName, ranges, tt.config)
+		if err != nil {
+			t.Fatalf("%s: renderIPPool() error = %v", tt.name, err)
+		}
+		objs, err := decodeObjects(bytes.NewReader(data))
+		if err != nil || len(objs) != 1 {
+			t.Fatalf("%s: renderIPPool() = %s, want a single IPPool: %v", tt.name, data, err)
+		}
+		if labels := objs[0].GetLabels(); !reflect.DeepEqual(labels, tt.wantLabels) {
+			t.Errorf("%s: labels = %v, want %v", tt.name, labels, tt.wantLabels)
+		}
+		if spec, _, _ := unstructured.NestedMap(objs[0].Object, "spec"); !reflect.DeepEqual(spec, tt.wantSpec) {
+			t.Errorf("%s: spec = %v, want %v", tt.name, spec, tt.wantSpec)
+		}
+	}
+}
+
+func TestValidateIPPoolReplacement(t *testing.T) {
+	newIPPool := func(labels map[string]string) *unstructured.Unstructured {
+		ipPool := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "ipam.metal3.io/v1alpha1",
+			"kind":       "IPPool",
+		}}
+		ipPool.SetName("foo")
+		ipPool.SetLabels(labels)
+		return ipPool
+	}
+
+	tests := []struct {
+		name         string
+		ipPool       *unstructured.Unstructured
+		clusterNames []string
+		wantErr      bool
+	}{{
+		name:   "unused pool",
+		ipPool: newIPPool(nil),
+	}, {
+		name:         "pool of the same cluster",
+		ipPool:       newIPPool(nil),
+		clusterNames: []string{"foo"},
+	}, {
+		name:         "pool in use by another cluster",
+		ipPool:       newIPPool(nil),
+		clusterNames: []string{"foo", "bar"},
+		wantErr:      true,
+	}, {
+		name:    "shared pool",
+		ipPool:  newIPPool(map[string]string{sharedIPPoolLabel: "true"}),
+		wantErr: true,
+	}}
+
+	for _, tt := range tests {
+		if err := validateIPPoolReplacement(tt.ipPool, tt.clusterNames, "foo"); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateIPPoolReplacement() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
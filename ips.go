@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os/exec"
+	"text/tabwriter"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type ipAllocation struct {
+	Address  string
+	Claim    string
+	Machine  string
+	VM       string
+	HostNode string
+}
+
+// getClusterIPPoolName returns the metal3 IPPool of a persistent cluster, shared or named after the cluster.
+func getClusterIPPoolName(namespace string, clusterName string) (string, error) {
+	cluster, err := getObject("clusters.cluster.x-k8s.io", clusterName, "--namespace", namespace)
+	if err != nil {
+		return "", fmt.Errorf("get cluster: %s", err)
+	}
+	if provider := cluster.GetAnnotations()[ipamProviderAnnotation]; provider != "" && provider != metal3IPAMProvider {
+		return "", fmt.Errorf("cluster %q allocates addresses by the %s IPAM provider, use its %s resource instead", clusterName, provider, ipamPoolResources[provider])
+	}
+	if ipPoolName := cluster.GetAnnotations()[ipPoolAnnotation]; ipPoolName != "" {
+		return ipPoolName, nil
+	}
+	return clusterName, nil
+}
+
+// parseIPPoolEntry parses an item of the "spec.pools" field of a live IPPool.
+func parseIPPoolEntry(entry map[string]interface{}) (*ipPoolRange, error) {
+	if subnet, ok := entry["subnet"].(string); ok && subnet != "" {
+		_, ipNet, err := net.ParseCIDR(subnet)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subnet %q in IPPool: %s", subnet, err)
+		}
+		r, err := parseIPPoolRange(ipNet.String())
+		if err != nil {
+			return nil, err
+		}
+		if prefix, ok, _ := unstructured.NestedInt64(entry, "prefix"); ok {
+			r.Prefix = int(prefix)
+		}
+		return r, nil
+	}
+	start, _ := entry["start"].(string)
+	end, _ := entry["end"].(string)
+	return parseIPPoolRange(fmt.Sprintf("%s-%s", start, end))
+}
+
+func getIPPoolRanges(ipPool *unstructured.Unstructured) ([]*ipPoolRange, []interface{}, error) {
+	entries, _, _ := unstructured.NestedSlice(ipPool.Object, "spec", "pools")
+	var ranges []*ipPoolRange
+	for _, entry := range entries {
+		e, _ := entry.(map[string]interface{})
+		r, err := parseIPPoolEntry(e)
+		if err != nil {
+			return nil, nil, err
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, entries, nil
+}
+
+func getIPPoolAddresses(namespace string, ipPoolName string) ([]*unstructured.Unstructured, error) {
+	ipAddresses, err := getObjects("ipaddresses.ipam.metal3.io", "--namespace", namespace)
+	if err != nil {
+		return nil, fmt.Errorf("get IPAddresses: %s", err)
+	}
+	var poolAddresses []*unstructured.Unstructured
+	for _, ipAddress := range ipAddresses {
+		poolName, _, _ := unstructured.NestedString(ipAddress.Object, "spec", "pool", "name")
+		if poolName == ipPoolName {
+			poolAddresses = append(poolAddresses, ipAddress)
+		}
+	}
+	return poolAddresses, nil
+}
+
+// getIPAllocations returns the addresses allocated from the IPPool with their Machines, VMs and host nodes.
+func getIPAllocations(namespace string, ipPoolName string) ([]*ipAllocation, error) {
+	ipAddresses, err := getIPPoolAddresses(namespace, ipPoolName)
+	if err != nil {
+		return nil, err
+	}
+	virtinkMachines, err := getObjects("virtinkmachines.infrastructure.cluster.x-k8s.io", "--namespace", namespace)
+	if err != nil {
+		return nil, fmt.Errorf("get VirtinkMachines: %s", err)
+	}
+	virtinkMachinesByName := map[string]*unstructured.Unstructured{}
+	for _, virtinkMachine := range virtinkMachines {
+		virtinkMachinesByName[virtinkMachine.GetName()] = virtinkMachine
+	}
+	vms, err := getObjects("virtualmachines.virt.virtink.smartx.com", "--namespace", namespace)
+	if err != nil {
+		return nil, fmt.Errorf("get VirtualMachines: %s", err)
+	}
+	vmsByName := map[string]*unstructured.Unstructured{}
+	for _, vm := range vms {
+		vmsByName[vm.GetName()] = vm
+	}
+	ipClaims, err := getObjects("ipclaims.ipam.metal3.io", "--namespace", namespace)
+	if err != nil {
+		return nil, fmt.Errorf("get IPClaims: %s", err)
+	}
+	ipClaimsByName := map[string]*unstructured.Unstructured{}
+	for _, ipClaim := range ipClaims {
+		ipClaimsByName[ipClaim.GetName()] = ipClaim
+	}
+
+	var allocations []*ipAllocation
+	for _, ipAddress := range ipAddresses {
+		address, _, _ := unstructured.NestedString(ipAddress.Object, "spec", "address")
+		claimName, _, _ := unstructured.NestedString(ipAddress.Object, "spec", "claim", "name")
+		allocation := &ipAllocation{
+			Address: address,
+			Claim:   claimName,
+		}
+		if ipClaim, ok := ipClaimsByName[claimName]; ok {
+			for _, ownerRef := range ipClaim.GetOwnerReferences() {
+				if ownerRef.Kind != "VirtinkMachine" {
+					continue
+				}
+				if virtinkMachine, ok := virtinkMachinesByName[ownerRef.Name]; ok {
+					for _, machineOwnerRef := range virtinkMachine.GetOwnerReferences() {
+						if machineOwnerRef.Kind == "Machine" {
+							allocation.Machine = machineOwnerRef.Name
+						}
+					}
+				}
+				if vm, ok := vmsByName[ownerRef.Name]; ok {
+					allocation.VM = vm.GetName()
+					allocation.HostNode, _, _ = unstructured.NestedString(vm.Object, "status", "nodeName")
+				}
+			}
+		}
+		allocations = append(allocations, allocation)
+	}
+	return allocations, nil
+}
+
+func printIPAllocations(out io.Writer, namespace string, ipPoolName string) error {
+	ipPool, err := getObject("ippools.ipam.metal3.io", ipPoolName, "--namespace", namespace)
+	if err != nil {
+		return fmt.Errorf("get IPPool: %s", err)
+	}
+	ranges, _, err := getIPPoolRanges(ipPool)
+	if err != nil {
+		return err
+	}
+	allocations, err := getIPAllocations(namespace, ipPoolName)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(w, "ADDRESS\tCLAIM\tMACHINE\tVM\tHOST-NODE")
+	for _, allocation := range allocations {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", allocation.Address, allocation.Claim, orNone(allocation.Machine), orNone(allocation.VM), orNone(allocation.HostNode))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	total := big.NewInt(0)
+	for _, r := range ranges {
+		total.Add(total, r.Size())
+	}
+	free := new(big.Int).Sub(total, big.NewInt(int64(len(allocations))))
+	if free.Sign() < 0 {
+		free.SetInt64(0)
+	}
+	fmt.Fprintf(out, "\nIPPool %q: %d allocated, %s free of %s addresses in %v\n", ipPoolName, len(allocations), free, total, ranges)
+	return nil
+}
+
+// addIPPoolRange adds a range not overlapping existing ranges or host nodes to a live IPPool.
+func addIPPoolRange(namespace string, ipPoolName string, addr string) error {
+	ipPool, err := getObject("ippools.ipam.metal3.io", ipPoolName, "--namespace", namespace)
+	if err != nil {
+		return fmt.Errorf("get IPPool: %s", err)
+	}
+	newRange, newEntries, err := addIPPoolEntry(ipPool, addr)
+	if err != nil {
+		return err
+	}
+	if err := checkIPPoolRangesAgainstNodes([]*ipPoolRange{newRange}); err != nil {
+		return err
+	}
+	if err := patchIPPoolEntries(namespace, ipPoolName, newEntries); err != nil {
+		return err
+	}
+	fmt.Printf("Added %s to IPPool %q\n", newRange, ipPoolName)
+	return nil
+}
+
+// addIPPoolEntry returns the range of addr and the "spec.pools" field of the IPPool with it appended.
+func addIPPoolEntry(ipPool *unstructured.Unstructured, addr string) (*ipPoolRange, []interface{}, error) {
+	ranges, entries, err := getIPPoolRanges(ipPool)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var addrs []string
+	for _, r := range ranges {
+		addrs = append(addrs, r.String())
+	}
+	newRanges, err := parseIPPoolRanges(append(addrs, addr))
+	if err != nil {
+		return nil, nil, err
+	}
+	newRange := newRanges[len(newRanges)-1]
+
+	entry := map[string]interface{}{}
+	if newRange.Subnet != "" {
+		entry["subnet"] = newRange.Subnet
+		if _, ok, _ := unstructured.NestedInt64(ipPool.Object, "spec", "prefix"); !ok {
+			entry["prefix"] = int64(newRange.Prefix)
+		}
+	} else {
+		entry["start"] = newRange.Start
+		entry["end"] = newRange.End
+	}
+	return newRange, append(append([]interface{}{}, entries...), entry), nil
+}
+
+// removeIPPoolRange removes an existing range without allocated addresses from a live IPPool.
+func removeIPPoolRange(namespace string, ipPoolName string, addr string) error {
+	ipPool, err := getObject("ippools.ipam.metal3.io", ipPoolName, "--namespace", namespace)
+	if err != nil {
+		return fmt.Errorf("get IPPool: %s", err)
+	}
+	ipAddresses, err := getIPPoolAddresses(namespace, ipPoolName)
+	if err != nil {
+		return err
+	}
+	target, newEntries, err := removeIPPoolEntry(ipPool, addr, ipAddresses)
+	if err != nil {
+		return err
+	}
+	if err := patchIPPoolEntries(namespace, ipPoolName, newEntries); err != nil {
+		return err
+	}
+	fmt.Printf("Removed %s from IPPool %q\n", target, ipPoolName)
+	return nil
+}
+
+// removeIPPoolEntry returns the range of addr and the "spec.pools" field of the IPPool without it, unless it has addresses allocated.
+func removeIPPoolEntry(ipPool *unstructured.Unstructured, addr string, ipAddresses []*unstructured.Unstructured) (*ipPoolRange, []interface{}, error) {
+	ranges, entries, err := getIPPoolRanges(ipPool)
+	if err != nil {
+		return nil, nil, err
+	}
+	target, err := parseIPPoolRange(addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	index := -1
+	for i, r := range ranges {
+		if r.first.Equal(target.first) && r.last.Equal(target.last) {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, nil, fmt.Errorf("%s is not a range of IPPool %q, existing ranges are %v", target, ipPool.GetName(), ranges)
+	}
+
+	for _, ipAddress := range ipAddresses {
+		address, _, _ := unstructured.NestedString(ipAddress.Object, "spec", "address")
+		if ip := net.ParseIP(address); ip != nil && target.Contains(ip) {
+			claimName, _, _ := unstructured.NestedString(ipAddress.Object, "spec", "claim", "name")
+			return nil, nil, fmt.Errorf("address %s in %s is allocated to IPClaim %q", address, target, claimName)
+		}
+	}
+
+	return target, append(append([]interface{}{}, entries[:index]...), entries[index+1:]...), nil
+}
+
+func patchIPPoolEntries(namespace string, ipPoolName string, entries []interface{}) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"pools": entries,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if err := runCommand(exec.Command("kubectl", "patch", "ippools.ipam.metal3.io", ipPoolName, "--namespace", namespace, "--type", "merge", "--patch", string(patch))); err != nil {
+		return fmt.Errorf("patch IPPool: %s", err)
+	}
+	return nil
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func newTestIPPool(withPrefix bool, entries ...interface{}) *unstructured.Unstructured {
+	spec := map[string]interface{}{
+		"pools": entries,
+	}
+	if withPrefix {
+		spec["prefix"] = int64(24)
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "ipam.metal3.io/v1alpha1",
+		"kind":       "IPPool",
+		"metadata":   map[string]interface{}{"name": "foo", "namespace": "default"},
+		"spec":       spec,
+	}}
+}
+
+func newTestIPAddress(address string, claimName string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "ipam.metal3.io/v1alpha1",
+		"kind":       "IPAddress",
+		"spec": map[string]interface{}{
+			"address": address,
+			"pool":    map[string]interface{}{"name": "foo"},
+			"claim":   map[string]interface{}{"name": claimName},
+		},
+	}}
+}
+
+func TestAddIPPoolEntry(t *testing.T) {
+	existing := map[string]interface{}{"start": "10.0.0.10", "end": "10.0.0.20"}
+
+	tests := []struct {
+		name        string
+		ipPool      *unstructured.Unstructured
+		addr        string
+		wantRange   string
+		wantEntries []interface{}
+		wantErr     bool
+	}{{
+		name:        "range",
+		ipPool:      newTestIPPool(true, existing),
+		addr:        "10.0.0.30-10.0.0.40",
+		wantRange:   "10.0.0.30-10.0.0.40",
+		wantEntries: []interface{}{existing, map[string]interface{}{"start": "10.0.0.30", "end": "10.0.0.40"}},
+	}, {
+		name:        "single address",
+		ipPool:      newTestIPPool(true, existing),
+		addr:        "10.0.0.50",
+		wantRange:   "10.0.0.50-10.0.0.50",
+		wantEntries: []interface{}{existing, map[string]interface{}{"start": "10.0.0.50", "end": "10.0.0.50"}},
+	}, {
+		name:        "subnet of a pool with a prefix",
+		ipPool:      newTestIPPool(true, existing),
+		addr:        "10.0.1.0/28",
+		wantRange:   "10.0.1.0/28",
+		wantEntries: []interface{}{existing, map[string]interface{}{"subnet": "10.0.1.0/28"}},
+	}, {
+		name:        "subnet of a pool without a prefix",
+		ipPool:      newTestIPPool(false, existing),
+		addr:        "10.0.1.0/28",
+		wantRange:   "10.0.1.0/28",
+		wantEntries: []interface{}{existing, map[string]interface{}{"subnet": "10.0.1.0/28", "prefix": int64(28)}},
+	}, {
+		name:    "overlapping range",
+		ipPool:  newTestIPPool(true, existing),
+		addr:    "10.0.0.15-10.0.0.25",
+		wantErr: true,
+	}, {
+		name:    "overlapping subnet",
+		ipPool:  newTestIPPool(true, map[string]interface{}{"subnet": "10.0.1.0/28"}),
+		addr:    "10.0.1.8",
+		wantErr: true,
+	}, {
+		name:    "different IP family",
+		ipPool:  newTestIPPool(true, existing),
+		addr:    "fd00::10-fd00::20",
+		wantErr: true,
+	}, {
+		name:    "invalid address",
+		ipPool:  newTestIPPool(true, existing),
+		addr:    "10.0.0.300",
+		wantErr: true,
+	}}
+
+	for _, tt := range tests {
+		gotRange, gotEntries, err := addIPPoolEntry(tt.ipPool, tt.addr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: addIPPoolEntry() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if gotRange.String() != tt.wantRange {
+			t.Errorf("%s: addIPPoolEntry() range = %s, want %s", tt.name, gotRange, tt.wantRange)
+		}
+		if !reflect.DeepEqual(gotEntries, tt.wantEntries) {
+			t.Errorf("%s: addIPPoolEntry() entries = %v, want %v", tt.name, gotEntries, tt.wantEntries)
+		}
+	}
+}
+
+func TestRemoveIPPoolEntry(t *testing.T) {
+	first := map[string]interface{}{"start": "10.0.0.10", "end": "10.0.0.20"}
+	second := map[string]interface{}{"subnet": "10.0.1.0/28"}
+
+	tests := []struct {
+		name        string
+		addr        string
+		ipAddresses []*unstructured.Unstructured
+		wantEntries []interface{}
+		wantErr     bool
+	}{{
+		name:        "range without allocations",
+		addr:        "10.0.0.10-10.0.0.20",
+		ipAddresses: []*unstructured.Unstructured{newTestIPAddress("10.0.1.1", "foo-cp-1")},
+		wantEntries: []interface{}{second},
+	}, {
+		name:        "subnet without allocations",
+		addr:        "10.0.1.0/28",
+		ipAddresses: []*unstructured.Unstructured{newTestIPAddress("10.0.0.10", "foo-cp-1")},
+		wantEntries: []interface{}{first},
+	}, {
+		name:        "subnet given as a range",
+		addr:        "10.0.1.0-10.0.1.15",
+		wantEntries: []interface{}{first},
+	}, {
+		name:        "range with an allocated address",
+		addr:        "10.0.0.10-10.0.0.20",
+		ipAddresses: []*unstructured.Unstructured{newTestIPAddress("10.0.0.20", "foo-cp-1")},
+		wantErr:     true,
+	}, {
+		name:    "part of a range",
+		addr:    "10.0.0.10-10.0.0.15",
+		wantErr: true,
+	}, {
+		name:    "unknown range",
+		addr:    "10.0.2.0/24",
+		wantErr: true,
+	}}
+
+	for _, tt := range tests {
+		ipPool := newTestIPPool(true, first, second)
+		_, gotEntries, err := removeIPPoolEntry(ipPool, tt.addr, tt.ipAddresses)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: removeIPPoolEntry() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !reflect.DeepEqual(gotEntries, tt.wantEntries) {
+			t.Errorf("%s: removeIPPoolEntry() entries = %v, want %v", tt.name, gotEntries, tt.wantEntries)
+		}
+		if pools, _, _ := unstructured.NestedSlice(ipPool.Object, "spec", "pools"); len(pools) != 2 {
+			t.Errorf("%s: removeIPPoolEntry() modified the IPPool", tt.name)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/util/homedir"
+)
+
+func defaultKubeconfigFilePath(namespace string, clusterName string) string {
+	return filepath.Join(homedir.HomeDir(), ".kube", fmt.Sprintf("knest.%s.%s.kubeconfig", namespace, clusterName))
+}
+
+// mergedContextName names the context, cluster and user of a nested cluster in the default kubeconfig.
+func mergedContextName(namespace string, clusterName string) string {
+	return fmt.Sprintf("knest-%s-%s", namespace, clusterName)
+}
+
+func getAdminKubeconfig(namespace string, clusterName string) (*clientcmdapi.Config, error) {
+	encodedKubeconfigData, err := getCommandOutput(exec.Command("kubectl", "get", "secret", fmt.Sprintf("%s-kubeconfig", clusterName), "--namespace", namespace, "-o", "jsonpath={.data.value}"))
+	if err != nil {
+		return nil, fmt.Errorf("get kubeconfig: %s", err)
+	}
+	kubeconfigData, err := base64.StdEncoding.DecodeString(encodedKubeconfigData)
+	if err != nil {
+		return nil, fmt.Errorf("decode kubeconfig: %s", err)
+	}
+	kubeconfig, err := clientcmd.Load(kubeconfigData)
+	if err != nil {
+		return nil, fmt.Errorf("load kubeconfig: %s", err)
+	}
+	return kubeconfig, nil
+}
+
+// buildKubeconfig builds the kubeconfig of a nested cluster pointing to its current control plane endpoint.
+func buildKubeconfig(namespace string, clusterName string) (*clientcmdapi.Config, error) {
+	kubeconfig, err := getAdminKubeconfig(namespace, clusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint, err := getControlPlaneEndpoint(namespace, clusterName)
+	if err != nil {
+		return nil, err
+	}
+	for _, cluster := range kubeconfig.Clusters {
+		cluster.Server = endpoint.Server
+		cluster.TLSServerName = endpoint.TLSServerName
+	}
+	return kubeconfig, nil
+}
+
+// mergeKubeconfig merges the kubeconfig of a nested cluster into the default kubeconfig.
+func mergeKubeconfig(namespace string, clusterName string, kubeconfig *clientcmdapi.Config) (string, error) {
+	context, ok := kubeconfig.Contexts[kubeconfig.CurrentContext]
+	if !ok {
+		return "", fmt.Errorf("current context %q not found in kubeconfig", kubeconfig.CurrentContext)
+	}
+	cluster, ok := kubeconfig.Clusters[context.Cluster]
+	if !ok {
+		return "", fmt.Errorf("cluster %q not found in kubeconfig", context.Cluster)
+	}
+	authInfo, ok := kubeconfig.AuthInfos[context.AuthInfo]
+	if !ok {
+		return "", fmt.Errorf("user %q not found in kubeconfig", context.AuthInfo)
+	}
+
+	defaultKubeconfigFilePath := clientcmd.NewDefaultClientConfigLoadingRules().GetDefaultFilename()
+	defaultKubeconfig, err := loadKubeconfigFile(defaultKubeconfigFilePath)
+	if err != nil {
+		return "", err
+	}
+
+	name := mergedContextName(namespace, clusterName)
+	defaultKubeconfig.Clusters[name] = cluster
+	defaultKubeconfig.AuthInfos[name] = authInfo
+	defaultKubeconfig.Contexts[name] = &clientcmdapi.Context{
+		Cluster:   name,
+		AuthInfo:  name,
+		Namespace: context.Namespace,
+	}
+	if err := clientcmd.WriteToFile(*defaultKubeconfig, defaultKubeconfigFilePath); err != nil {
+		return "", fmt.Errorf("write kubeconfig %q: %s", defaultKubeconfigFilePath, err)
+	}
+	return name, nil
+}
+
+// unmergeKubeconfig removes the context, cluster and user merged by mergeKubeconfig from the default kubeconfig.
+func unmergeKubeconfig(namespace string, clusterName string) error {
+	defaultKubeconfigFilePath := clientcmd.NewDefaultClientConfigLoadingRules().GetDefaultFilename()
+	defaultKubeconfig, err := loadKubeconfigFile(defaultKubeconfigFilePath)
+	if err != nil {
+		return err
+	}
+
+	name := mergedContextName(namespace, clusterName)
+	if _, ok := defaultKubeconfig.Contexts[name]; !ok {
+		return nil
+	}
+	delete(defaultKubeconfig.Contexts, name)
+	delete(defaultKubeconfig.Clusters, name)
+	delete(defaultKubeconfig.AuthInfos, name)
+	if defaultKubeconfig.CurrentContext == name {
+		defaultKubeconfig.CurrentContext = ""
+	}
+	if err := clientcmd.WriteToFile(*defaultKubeconfig, defaultKubeconfigFilePath); err != nil {
+		return fmt.Errorf("write kubeconfig %q: %s", defaultKubeconfigFilePath, err)
+	}
+	return nil
+}
+
+func loadKubeconfigFile(path string) (*clientcmdapi.Config, error) {
+	kubeconfig, err := clientcmd.LoadFromFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return clientcmdapi.NewConfig(), nil
+		}
+		return nil, fmt.Errorf("load kubeconfig %q: %s", path, err)
+	}
+	return kubeconfig, nil
+}
+
+// writeKubeconfigFile writes the kubeconfig readable by the current user only.
+func writeKubeconfigFile(kubeconfig *clientcmdapi.Config, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := clientcmd.WriteToFile(*kubeconfig, path); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0600)
+}
+
+// withKubeconfigFile saves the kubeconfig to a temporary file for the duration of fn.
+func withKubeconfigFile(kubeconfig *clientcmdapi.Config, fn func(kubeconfigFilePath string) error) error {
+	kubeconfigFile, err := os.CreateTemp("", "knest-kubeconfig")
+	if err != nil {
+		return err
+	}
+	kubeconfigFile.Close()
+	defer os.Remove(kubeconfigFile.Name())
+	if err := clientcmd.WriteToFile(*kubeconfig, kubeconfigFile.Name()); err != nil {
+		return err
+	}
+	return fn(kubeconfigFile.Name())
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func newTestKubeconfig(name string, server string) *clientcmdapi.Config {
+	kubeconfig := clientcmdapi.NewConfig()
+	kubeconfig.Clusters[name] = &clientcmdapi.Cluster{Server: server}
+	kubeconfig.AuthInfos[name] = &clientcmdapi.AuthInfo{Token: name}
+	kubeconfig.Contexts[name] = &clientcmdapi.Context{Cluster: name, AuthInfo: name, Namespace: "default"}
+	kubeconfig.CurrentContext = name
+	return kubeconfig
+}
+
+func TestMergeKubeconfigInvalid(t *testing.T) {
+	t.Setenv("KUBECONFIG", filepath.Join(t.TempDir(), "config"))
+
+	tests := []struct {
+		name   string
+		modify func(kubeconfig *clientcmdapi.Config)
+	}{{
+		name:   "missing current context",
+		modify: func(kubeconfig *clientcmdapi.Config) { kubeconfig.CurrentContext = "missing" },
+	}, {
+		name:   "missing cluster",
+		modify: func(kubeconfig *clientcmdapi.Config) { delete(kubeconfig.Clusters, "admin") },
+	}, {
+		name:   "missing user",
+		modify: func(kubeconfig *clientcmdapi.Config) { delete(kubeconfig.AuthInfos, "admin") },
+	}}
+
+	for _, tt := range tests {
+		kubeconfig := newTestKubeconfig("admin", "https://10.0.0.1:6443")
+		tt.modify(kubeconfig)
+		if _, err := mergeKubeconfig("default", "foo", kubeconfig); err == nil {
+			t.Errorf("%s: mergeKubeconfig() succeeded, want error", tt.name)
+		}
+	}
+}
+
+func TestMergeKubeconfigRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	t.Setenv("KUBECONFIG", path)
+	host := newTestKubeconfig("host", "https://192.168.0.1:6443")
+	if err := clientcmd.WriteToFile(*host, path); err != nil {
+		t.Fatalf("write kubeconfig: %v", err)
+	}
+
+	nested := newTestKubeconfig("foo-admin@foo", "https://10.0.0.1:6443")
+	for i := 0; i < 2; i++ {
+		name, err := mergeKubeconfig("default", "foo", nested)
+		if err != nil {
+			t.Fatalf("mergeKubeconfig() error = %v", err)
+		}
+		if name != "knest-default-foo" {
+			t.Errorf("mergeKubeconfig() = %q, want %q", name, "knest-default-foo")
+		}
+	}
+
+	merged, err := clientcmd.LoadFromFile(path)
+	if err != nil {
+		t.Fatalf("load kubeconfig: %v", err)
+	}
+	if merged.CurrentContext != "host" {
+		t.Errorf("current context = %q, want %q", merged.CurrentContext, "host")
+	}
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{{
+		name: "host context",
+		got:  merged.Contexts["host"].Cluster,
+		want: "host",
+	}, {
+		name: "merged context",
+		got:  []string{merged.Contexts["knest-default-foo"].Cluster, merged.Contexts["knest-default-foo"].AuthInfo, merged.Contexts["knest-default-foo"].Namespace},
+		want: []string{"knest-default-foo", "knest-default-foo", "default"},
+	}, {
+		name: "merged cluster",
+		got:  merged.Clusters["knest-default-foo"].Server,
+		want: "https://10.0.0.1:6443",
+	}, {
+		name: "merged user",
+		got:  merged.AuthInfos["knest-default-foo"].Token,
+		want: "foo-admin@foo",
+	}, {
+		name: "number of contexts",
+		got:  len(merged.Contexts),
+		want: 2,
+	}}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+
+	merged.CurrentContext = "knest-default-foo"
+	if err := clientcmd.WriteToFile(*merged, path); err != nil {
+		t.Fatalf("write kubeconfig: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := unmergeKubeconfig("default", "foo"); err != nil {
+			t.Fatalf("unmergeKubeconfig() error = %v", err)
+		}
+	}
+
+	unmerged, err := clientcmd.LoadFromFile(path)
+	if err != nil {
+		t.Fatalf("load kubeconfig: %v", err)
+	}
+	if unmerged.CurrentContext != "" {
+		t.Errorf("current context = %q after unmerging it, want it cleared", unmerged.CurrentContext)
+	}
+	for _, m := range []interface{}{unmerged.Contexts, unmerged.Clusters, unmerged.AuthInfos} {
+		if n := reflect.ValueOf(m).Len(); n != 1 {
+			t.Errorf("%d entries left after unmerging, want only the host ones", n)
+		}
+	}
+	if unmerged.Clusters["host"].Server != "https://192.168.0.1:6443" {
+		t.Errorf("host cluster = %+v, want it kept", unmerged.Clusters["host"])
+	}
+}
+
+func TestUnmergeKubeconfigMissingFile(t *testing.T) {
+	t.Setenv("KUBECONFIG", filepath.Join(t.TempDir(), "config"))
+	if err := unmergeKubeconfig("default", "foo"); err != nil {
+		t.Errorf("unmergeKubeconfig() error = %v, want nil", err)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type kustomizationPatchTarget struct {
+	Kind string `yaml:"kind,omitempty"`
+	Name string `yaml:"name,omitempty"`
+}
+
+type kustomizationPatch struct {
+	Target *kustomizationPatchTarget `yaml:"target,omitempty"`
+	Patch  string                    `yaml:"patch"`
+}
+
+// clusterTemplatePatch is a kustomization applied to the generated cluster template.
+type clusterTemplatePatch struct {
+	Name          string
+	Kustomization []byte
+}
+
+// newKustomization returns a kustomization patching all resources of the given kind.
+func newKustomization(kind string, patch map[string]interface{}) ([]byte, error) {
+	patchData, err := marshalYAML(patch)
+	if err != nil {
+		return nil, err
+	}
+	return newPatchKustomization(kustomizationPatch{
+		Target: &kustomizationPatchTarget{Kind: kind},
+		Patch:  string(patchData),
+	})
+}
+
+func newPatchKustomization(patch kustomizationPatch) ([]byte, error) {
+	kustomization := struct {
+		Resources []string             `yaml:"resources"`
+		Patches   []kustomizationPatch `yaml:"patches"`
+	}{
+		Resources: []string{"cluster-template.yaml"},
+		Patches:   []kustomizationPatch{patch},
+	}
+	return marshalYAML(kustomization)
+}
+
+// readPatchFile reads a patch in the form of "[KIND[/NAME]=]FILE".
+func readPatchFile(spec string) (*clusterTemplatePatch, error) {
+	path := spec
+	var target *kustomizationPatchTarget
+	if targetSpec, p, ok := strings.Cut(spec, "="); ok {
+		path = p
+		kind, name, _ := strings.Cut(targetSpec, "/")
+		target = &kustomizationPatchTarget{Kind: kind, Name: name}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read patch %q: %s", path, err)
+	}
+
+	var content interface{}
+	if err := yaml.Unmarshal(data, &content); err != nil {
+		return nil, fmt.Errorf("parse patch %q: %s", path, err)
+	}
+
+	patch := kustomizationPatch{
+		Target: target,
+		Patch:  string(data),
+	}
+	switch c := content.(type) {
+	case []interface{}:
+		if target == nil {
+			return nil, fmt.Errorf("JSON6902 patch %q requires a target in the form of 'KIND[/NAME]=FILE'", path)
+		}
+	case map[string]interface{}:
+		if _, ok := c["patch"]; ok {
+			if err := yaml.Unmarshal(data, &patch); err != nil {
+				return nil, fmt.Errorf("parse patch %q: %s", path, err)
+			}
+			if target != nil {
+				patch.Target = target
+			}
+		}
+	default:
+		return nil, fmt.Errorf("invalid patch %q", path)
+	}
+
+	kustomization, err := newPatchKustomization(patch)
+	if err != nil {
+		return nil, err
+	}
+	return &clusterTemplatePatch{
+		Name:          path,
+		Kustomization: kustomization,
+	}, nil
+}
+
+// readPatchDir reads all patches in the directory in lexical order.
+func readPatchDir(dir string) ([]*clusterTemplatePatch, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read patch dir %q: %s", dir, err)
+	}
+
+	var fileNames []string
+	for _, entry := range entries {
+		switch filepath.Ext(entry.Name()) {
+		case ".yaml", ".yml", ".json":
+			if !entry.IsDir() {
+				fileNames = append(fileNames, entry.Name())
+			}
+		}
+	}
+	sort.Strings(fileNames)
+
+	var patches []*clusterTemplatePatch
+	for _, fileName := range fileNames {
+		patch, err := readPatchFile(filepath.Join(dir, fileName))
+		if err != nil {
+			return nil, err
+		}
+		patches = append(patches, patch)
+	}
+	return patches, nil
+}
+
+func marshalYAML(v interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	encoder := yaml.NewEncoder(buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestReadPatchFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"strategic.yaml": "kind: KubeadmControlPlane\nmetadata:\n  name: not-used\n",
+		"json6902.yaml":  "- op: replace\n  path: /spec/replicas\n  value: 3\n",
+		"entry.yaml":     "target:\n  kind: MachineDeployment\npatch: |\n  - op: remove\n    path: /spec/strategy\n",
+		"scalar.yaml":    "patch\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		spec    string
+		want    kustomizationPatch
+		wantErr bool
+	}{{
+		spec: filepath.Join(dir, "strategic.yaml"),
+		want: kustomizationPatch{Patch: files["strategic.yaml"]},
+	}, {
+		spec: "KubeadmControlPlane=" + filepath.Join(dir, "json6902.yaml"),
+		want: kustomizationPatch{
+			Target: &kustomizationPatchTarget{Kind: "KubeadmControlPlane"},
+			Patch:  files["json6902.yaml"],
+		},
+	}, {
+		spec: "MachineDeployment/quickstart-md-0=" + filepath.Join(dir, "json6902.yaml"),
+		want: kustomizationPatch{
+			Target: &kustomizationPatchTarget{Kind: "MachineDeployment", Name: "quickstart-md-0"},
+			Patch:  files["json6902.yaml"],
+		},
+	}, {
+		spec: filepath.Join(dir, "entry.yaml"),
+		want: kustomizationPatch{
+			Target: &kustomizationPatchTarget{Kind: "MachineDeployment"},
+			Patch:  "- op: remove\n  path: /spec/strategy\n",
+		},
+	}, {
+		spec: "MachineDeployment/quickstart-md-1=" + filepath.Join(dir, "entry.yaml"),
+		want: kustomizationPatch{
+			Target: &kustomizationPatchTarget{Kind: "MachineDeployment", Name: "quickstart-md-1"},
+			Patch:  "- op: remove\n  path: /spec/strategy\n",
+		},
+	}, {
+		spec:    filepath.Join(dir, "json6902.yaml"),
+		wantErr: true,
+	}, {
+		spec:    filepath.Join(dir, "scalar.yaml"),
+		wantErr: true,
+	}, {
+		spec:    filepath.Join(dir, "missing.yaml"),
+		wantErr: true,
+	}}
+
+	for _, tt := range tests {
+		got, err := readPatchFile(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("readPatchFile(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+
+		var kustomization struct {
+			Resources []string             `yaml:"resources"`
+			Patches   []kustomizationPatch `yaml:"patches"`
+		}
+		if err := yaml.Unmarshal(got.Kustomization, &kustomization); err != nil {
+			t.Errorf("readPatchFile(%q) returned an invalid kustomization: %s", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(kustomization.Resources, []string{"cluster-template.yaml"}) {
+			t.Errorf("readPatchFile(%q) resources = %v", tt.spec, kustomization.Resources)
+		}
+		if len(kustomization.Patches) != 1 || !reflect.DeepEqual(kustomization.Patches[0], tt.want) {
+			t.Errorf("readPatchFile(%q) patches = %+v, want %+v", tt.spec, kustomization.Patches, tt.want)
+		}
+	}
+}
@@ -3,30 +3,35 @@ package main
 import (
 	"bytes"
 	"embed"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"net/url"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"text/template"
+	"text/tabwriter"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"gopkg.in/yaml.v3"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	"k8s.io/client-go/util/homedir"
 )
 
 const (
-	VirtinkVersion          = "v0.15.0"
-	VirtinkProviderVersion  = "v0.7.0"
-	IPAddressManagerVersion = "v1.2.1"
-	CDIVersion              = "v1.55.2"
+	VirtinkVersion               = "v0.15.0"
+	VirtinkProviderVersion       = "v0.7.0"
+	IPAddressManagerVersion      = "v1.2.1"
+	InClusterIPAMProviderVersion = "v0.1.0"
+	CDIVersion                   = "v1.55.2"
+	ClusterAutoscalerVersion     = "v1.26.2"
 )
 
 var version string
@@ -62,8 +67,46 @@ func main() {
 		workerMachineRootfsSize        = resource.QuantityValue{Quantity: resource.MustParse("4Gi")}
 		persistent                     = false
 		machineAddresses               []string
+		machineIPPoolConfig            = ipPoolConfig{}
+		machinePreAllocations          []string
+		sharedIPPoolName               string
+		ipamProvider                   = metal3IPAMProvider
+		storage                        = storageOptions{}
+		workerDataDiskSpecs            []string
 		hostClusterCNI                 string
 		from                           string
+		workerPoolSpecs                []string
+		newWorkerPoolCount             = 0
+		waitScale                      = false
+		autoscaleMin                   = 0
+		autoscaleMax                   = 0
+		autoscalePool                  string
+		autoscaleDisable               = false
+		controlPlaneServiceType        = "NodePort"
+		controlPlaneServiceAnnotations map[string]string
+		route                          = controlPlaneRoute{Type: "ingress", Port: 443, IngressClass: "nginx"}
+		kubeconfigOutput               string
+		kubeconfigMerge                = false
+		kubeconfigRefresh              = false
+		kubeconfigRevoke               = false
+		credential                     = scopedCredential{TTL: 24 * time.Hour, Type: "certificate"}
+		kubeconfigExec                 = false
+		hostContext                    string
+		proxyAddress                   = "127.0.0.1"
+		proxyPort                      = 0
+		proxyKubeconfigOutput          string
+		endpointHost                   string
+		ipFamily                       = "ipv4"
+		extraAPIServerCertSANs         []string
+		cni                            string
+		cniEncapsulation               string
+		addons                         []string
+		addonManifestsDirs             []string
+		addonCharts                    []string
+		patchFiles                     []string
+		patchDirs                      []string
+		templateVariableSets           []string
+		templateValuesFiles            []string
 	)
 
 	cmdCreate := &cobra.Command{
@@ -71,6 +114,165 @@ func main() {
 		Args:  cobra.ExactArgs(1),
 		Short: "Create a nested Kubernetes cluster.",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if cmd.Flags().Changed("autoscale-min") && autoscaleMax == 0 {
+				return fmt.Errorf("--autoscale-min requires --autoscale-max")
+			}
+			if autoscaleMax > 0 && autoscaleMax < autoscaleMin {
+				return fmt.Errorf("--autoscale-max should not be less than --autoscale-min")
+			}
+
+			switch controlPlaneServiceType {
+			case "NodePort", "LoadBalancer", "ClusterIP":
+			default:
+				return fmt.Errorf("unsupported control plane service type: %s", controlPlaneServiceType)
+			}
+
+			if route.BaseDomain != "" {
+				switch route.Type {
+				case "ingress":
+				case "gateway":
+					if route.Gateway == "" {
+						return fmt.Errorf("--control-plane-gateway is required for gateway route")
+					}
+				default:
+					return fmt.Errorf("unsupported control plane route type: %s", route.Type)
+				}
+			}
+
+			if !cmd.Flags().Changed("pod-network-cidr") {
+				podNetworkCIDR = defaultPodNetworkCIDRs[ipFamily]
+			}
+			if !cmd.Flags().Changed("service-cidr") {
+				serviceCIDR = defaultServiceCIDRs[ipFamily]
+			}
+			podNetworkCIDRs, err := parseCIDRs(ipFamily, podNetworkCIDR, "pod-network-cidr")
+			if err != nil {
+				return err
+			}
+			serviceCIDRs, err := parseCIDRs(ipFamily, serviceCIDR, "service-cidr")
+			if err != nil {
+				return err
+			}
+			if isIPv4CIDR(podNetworkCIDRs[0]) != isIPv4CIDR(serviceCIDRs[0]) {
+				return fmt.Errorf("the first CIDRs of --pod-network-cidr and --service-cidr should be of the same IP family")
+			}
+			endpointHost = unbracketHost(endpointHost)
+
+			for _, spec := range workerDataDiskSpecs {
+				disk, err := parseDataDisk(spec)
+				if err != nil {
+					return err
+				}
+				storage.WorkerDataDisks = append(storage.WorkerDataDisks, disk)
+			}
+			if err := validateStorageOptions(&storage); err != nil {
+				return err
+			}
+			if !storage.IsEmpty() && !persistent {
+				return fmt.Errorf("storage options require --persistent")
+			}
+
+			if _, ok := ipamPoolResources[ipamProvider]; !ok {
+				return fmt.Errorf("unsupported IPAM provider: %s", ipamProvider)
+			}
+
+			var machineAddressRanges []*ipPoolRange
+			if sharedIPPoolName != "" {
+				if !persistent {
+					return fmt.Errorf("--ip-pool requires --persistent")
+				}
+				if ipamProvider != metal3IPAMProvider {
+					return fmt.Errorf("--ip-pool is only supported by the %s IPAM provider", metal3IPAMProvider)
+				}
+				if len(machineAddresses) > 0 || machineIPPoolConfig.Prefix != 0 || machineIPPoolConfig.Gateway != "" || len(machineIPPoolConfig.DNSServers) > 0 || len(machinePreAllocations) > 0 {
+					return fmt.Errorf("--machine-addresses and other IPPool flags can't be used with --ip-pool, configure the shared IPPool instead")
+				}
+				_, machineAddressRanges, err = getSharedIPPool(targetNamespace, sharedIPPoolName)
+				if err != nil {
+					return err
+				}
+			} else if persistent {
+				machineAddressRanges, err = parseIPPoolRanges(machineAddresses)
+				if err != nil {
+					return err
+				}
+				machineIPPoolConfig.PreAllocations, err = parsePreAllocations(machinePreAllocations)
+				if err != nil {
+					return err
+				}
+				if err := validateIPPoolConfig(machineAddressRanges, &machineIPPoolConfig); err != nil {
+					return err
+				}
+				if err := validateIPAMConfig(ipamProvider, machineAddressRanges, &machineIPPoolConfig); err != nil {
+					return err
+				}
+				if err := checkIPPoolRangesAgainstNodes(machineAddressRanges); err != nil {
+					return err
+				}
+			}
+			if len(machineAddressRanges) > 0 && isIPv4(machineAddressRanges[0].first) != isIPv4CIDR(podNetworkCIDRs[0]) {
+				return fmt.Errorf("machine addresses should be of the primary IP family of the nested cluster")
+			}
+
+			if cni != "" {
+				var err error
+				cniEncapsulation, err = validateCNI(cni, cniEncapsulation)
+				if err != nil {
+					return err
+				}
+			} else if cniEncapsulation != "" {
+				return fmt.Errorf("--cni-encapsulation requires --cni")
+			}
+
+			for _, addon := range addons {
+				if _, ok := addonCatalog[addon]; !ok {
+					return fmt.Errorf("addon %q not found in the catalog", addon)
+				}
+			}
+			if len(addons) > 0 || len(addonCharts) > 0 {
+				if err := checkHelm(); err != nil {
+					return err
+				}
+			}
+
+			var workerPools []*workerPool
+			workerPoolNames := map[string]bool{}
+			for _, spec := range workerPoolSpecs {
+				pool, err := parseWorkerPool(spec)
+				if err != nil {
+					return err
+				}
+				if workerPoolNames[pool.Name] {
+					return fmt.Errorf("duplicate worker pool name: %q", pool.Name)
+				}
+				if err := validateWorkerPoolName(args[0], pool.Name); err != nil {
+					return err
+				}
+				workerPoolNames[pool.Name] = true
+				workerPools = append(workerPools, pool)
+			}
+
+			templateVariables, err := readTemplateVariables(templateValuesFiles, templateVariableSets)
+			if err != nil {
+				return err
+			}
+
+			var userPatches []*clusterTemplatePatch
+			for _, dir := range patchDirs {
+				patches, err := readPatchDir(dir)
+				if err != nil {
+					return err
+				}
+				userPatches = append(userPatches, patches...)
+			}
+			for _, spec := range patchFiles {
+				patch, err := readPatchFile(spec)
+				if err != nil {
+					return err
+				}
+				userPatches = append(userPatches, patch)
+			}
+
 			if err := setupClusterctlConfig(); err != nil {
 				return fmt.Errorf("setup clusterctl config: %s", err)
 			}
@@ -81,7 +283,9 @@ func main() {
 			}
 			if len(capchCRDOutput) == 0 {
 				fmt.Println("Installing Cluster API providers")
-				if err := runCommand(exec.Command("clusterctl", "init", "--infrastructure", fmt.Sprintf("virtink:%s", VirtinkProviderVersion), "--wait-providers")); err != nil {
+				initCmd := exec.Command("clusterctl", "init", "--infrastructure", fmt.Sprintf("virtink:%s", VirtinkProviderVersion), "--wait-providers")
+				initCmd.Env = append(os.Environ(), "EXP_CLUSTER_RESOURCE_SET=true")
+				if err := runCommand(initCmd); err != nil {
 					return fmt.Errorf("install Cluster API providers: %s", err)
 				}
 			}
@@ -121,22 +325,14 @@ func main() {
 				}
 			}
 
-			ipAddressManagerCRDOutput, err := getCommandOutput(exec.Command("kubectl", "get", "crd", "ippools.ipam.metal3.io", "--ignore-not-found"))
-			if err != nil {
-				return fmt.Errorf("get ip-address-manager CRDs: %s", err)
-			}
-			if len(ipAddressManagerCRDOutput) == 0 {
-				fmt.Println("Installing ip-address-manager")
-				if err := runCommand(exec.Command("kubectl", "create", "namespace", "capm3-system")); err != nil {
-					return fmt.Errorf("create ip-address-manager namespace: %s", err)
-				}
-				if err := runCommand(exec.Command("kubectl", "apply", "-f", fmt.Sprintf("https://github.com/metal3-io/ip-address-manager/releases/download/%s/ipam-components.yaml", IPAddressManagerVersion))); err != nil {
-					return fmt.Errorf("install ip-address-manager: %s", err)
+			if persistent {
+				if err := installIPAMProvider(ipamProvider); err != nil {
+					return err
 				}
-
-				fmt.Println("Waiting for ip-address-manager to be available...")
-				if err := runCommand(exec.Command("kubectl", "wait", "-n", "capm3-system", "deployment", "ipam-controller-manager", "--for", "condition=Available", "--timeout", "-1s")); err != nil {
-					return fmt.Errorf("wait for ip-address-manager to be available: %s", err)
+				if ipamProvider == inClusterIPAMProvider {
+					if err := checkInClusterIPAMSupport(); err != nil {
+						return err
+					}
 				}
 			}
 
@@ -156,9 +352,9 @@ func main() {
 				"--worker-machine-count", strconv.Itoa(workerMachineCount))
 			generateCmd.Env = os.Environ()
 			generateCmd.Env = append(generateCmd.Env,
-				fmt.Sprintf("POD_NETWORK_CIDR=%s", podNetworkCIDR),
-				fmt.Sprintf("SERVICE_CIDR=%s", serviceCIDR),
-				"VIRTINK_CONTROL_PLANE_SERVICE_TYPE=NodePort",
+				fmt.Sprintf("POD_NETWORK_CIDR=%s", podNetworkCIDRs[0]),
+				fmt.Sprintf("SERVICE_CIDR=%s", serviceCIDRs[0]),
+				fmt.Sprintf("VIRTINK_CONTROL_PLANE_SERVICE_TYPE=%s", controlPlaneServiceType),
 				fmt.Sprintf("VIRTINK_CONTROL_PLANE_MACHINE_CPU_CORES=%d", controlPlaneMachineCPUCores),
 				fmt.Sprintf("VIRTINK_CONTROL_PLANE_MACHINE_MEMORY_SIZE=%s", controlPlaneMachineMemorySize.String()),
 				fmt.Sprintf("VIRTINK_CONTROL_PLANE_MACHINE_KERNEL_IMAGE=%s", controlPlaneMachineKernelImage),
@@ -171,7 +367,12 @@ func main() {
 				fmt.Sprintf("VIRTINK_WORKER_MACHINE_ROOTFS_SIZE=%s", workerMachineRootfsSize.String()))
 
 			if from != "" {
-				generateCmd.Args = append(generateCmd.Args, "--from", from)
+				clusterTemplateSource, cleanup, err := resolveClusterTemplateSource(from)
+				if err != nil {
+					return err
+				}
+				defer cleanup()
+				generateCmd.Args = append(generateCmd.Args, "--from", clusterTemplateSource)
 			} else {
 				generateCmd.Args = append(generateCmd.Args, "--infrastructure", fmt.Sprintf("virtink:%s", VirtinkProviderVersion))
 				if persistent {
@@ -181,7 +382,12 @@ func main() {
 				}
 			}
 
-			clusterTemplatePatches := map[string][]byte{}
+			machineIPPoolName := args[0]
+			if sharedIPPoolName != "" {
+				machineIPPoolName = sharedIPPoolName
+			}
+
+			var clusterTemplatePatches []*clusterTemplatePatch
 			if persistent {
 				if controlPlaneMachineRootfsImage == "" {
 					controlPlaneMachineRootfsImage = "smartxworks/capch-rootfs-cdi-1.24.0"
@@ -193,54 +399,35 @@ func main() {
 				generateCmd.Env = append(generateCmd.Env,
 					fmt.Sprintf("VIRTINK_CONTROL_PLANE_MACHINE_ROOTFS_CDI_IMAGE=%s", controlPlaneMachineRootfsImage),
 					fmt.Sprintf("VIRTINK_WORKER_MACHINE_ROOTFS_CDI_IMAGE=%s", workerMachineRootfsImage),
-					fmt.Sprintf("VIRTINK_IP_POOL_NAME=%s", args[0]),
+					fmt.Sprintf("VIRTINK_IP_POOL_NAME=%s", machineIPPoolName),
 				)
 
-				if err := runCommand(exec.Command("kubectl", "delete", "ippool.ipam.metal3.io", args[0], "--namespace", targetNamespace, "--wait", "--ignore-not-found")); err != nil {
-					return fmt.Errorf("delete IPPool: %s", err)
-				}
-
-				type ipPoolTemplateDataPool struct {
-					Start  string
-					End    string
-					Subnet string
-				}
-
-				ipPoolTemplateData := struct {
-					Name      string
-					Namespace string
-					Pools     []ipPoolTemplateDataPool
-				}{
-					Name:      args[0],
-					Namespace: targetNamespace,
-				}
+				if sharedIPPoolName == "" {
+					if err := checkIPPoolReplaceable(ipamPoolResources[ipamProvider], targetNamespace, args[0], args[0]); err != nil {
+						return err
+					}
+					if err := runCommand(exec.Command("kubectl", "delete", ipamPoolResources[ipamProvider], args[0], "--namespace", targetNamespace, "--wait", "--ignore-not-found")); err != nil {
+						return fmt.Errorf("delete IPPool: %s", err)
+					}
 
-				for _, addr := range machineAddresses {
-					if strings.Contains(addr, "-") {
-						items := strings.Split(addr, "-")
-						if len(items) != 2 {
-							return fmt.Errorf("invalid machine address: %s", addr)
-						}
-						ipPoolTemplateData.Pools = append(ipPoolTemplateData.Pools, ipPoolTemplateDataPool{
-							Start: items[0],
-							End:   items[1],
-						})
-					} else {
-						ipPoolTemplateData.Pools = append(ipPoolTemplateData.Pools, ipPoolTemplateDataPool{
-							Subnet: addr,
-						})
+					ipPoolData, err := renderIPAMPool(ipamProvider, args[0], targetNamespace, args[0], machineAddressRanges, &machineIPPoolConfig)
+					if err != nil {
+						return err
 					}
-				}
 
-				ipPoolDataBuf := &bytes.Buffer{}
-				if err := template.Must(template.New("ippool.yaml").ParseFS(templatesFS, "templates/ippool.yaml")).Execute(ipPoolDataBuf, ipPoolTemplateData); err != nil {
-					return err
+					createIPPoolCmd := exec.Command("kubectl", "apply", "-f", "-")
+					createIPPoolCmd.Stdin = bytes.NewReader(ipPoolData)
+					if err := runCommand(createIPPoolCmd); err != nil {
+						return fmt.Errorf("create IPPool: %s", err)
+					}
 				}
 
-				createIPPoolCmd := exec.Command("kubectl", "apply", "-f", "-")
-				createIPPoolCmd.Stdin = ipPoolDataBuf
-				if err := runCommand(createIPPoolCmd); err != nil {
-					return fmt.Errorf("create IPPool: %s", err)
+				if ipamProvider != metal3IPAMProvider {
+					patchBytes, err := newIPPoolRefPatch(ipamProvider, machineIPPoolName)
+					if err != nil {
+						return err
+					}
+					clusterTemplatePatches = append(clusterTemplatePatches, &clusterTemplatePatch{Name: "ip-pool-ref", Kustomization: patchBytes})
 				}
 
 				if hostClusterCNI != "" {
@@ -258,7 +445,7 @@ func main() {
 					if err != nil {
 						return err
 					}
-					clusterTemplatePatches[patchFileName] = patchBytes
+					clusterTemplatePatches = append(clusterTemplatePatches, &clusterTemplatePatch{Name: patchFileName, Kustomization: patchBytes})
 				}
 			} else {
 				if controlPlaneMachineKernelImage == "" {
@@ -279,6 +466,112 @@ func main() {
 					fmt.Sprintf("VIRTINK_WORKER_MACHINE_ROOTFS_IMAGE=%s", workerMachineRootfsImage))
 			}
 
+			if len(controlPlaneServiceAnnotations) > 0 {
+				annotations := map[string]interface{}{}
+				for key, value := range controlPlaneServiceAnnotations {
+					annotations[key] = value
+				}
+				patchBytes, err := newKustomization("VirtinkCluster", map[string]interface{}{
+					"apiVersion": "infrastructure.cluster.x-k8s.io/v1beta1",
+					"kind":       "VirtinkCluster",
+					"metadata": map[string]interface{}{
+						"name": "not-used",
+					},
+					"spec": map[string]interface{}{
+						"controlPlaneServiceTemplate": map[string]interface{}{
+							"metadata": map[string]interface{}{
+								"annotations": annotations,
+							},
+						},
+					},
+				})
+				if err != nil {
+					return err
+				}
+				clusterTemplatePatches = append(clusterTemplatePatches, &clusterTemplatePatch{Name: "control-plane-service-annotations", Kustomization: patchBytes})
+			}
+
+			apiServerCertSANs := append([]string{}, extraAPIServerCertSANs...)
+			clusterAnnotations := map[string]interface{}{}
+			if route.BaseDomain != "" {
+				host := route.Host(targetNamespace, args[0])
+				apiServerCertSANs = append(apiServerCertSANs, host)
+				clusterAnnotations[controlPlaneHostAnnotation] = host
+				clusterAnnotations[controlPlanePortAnnotation] = strconv.Itoa(route.Port)
+			}
+			if endpointHost != "" {
+				apiServerCertSANs = append(apiServerCertSANs, endpointHost)
+				clusterAnnotations[endpointHostAnnotation] = endpointHost
+			}
+			if persistent {
+				clusterAnnotations[ipPoolAnnotation] = machineIPPoolName
+				clusterAnnotations[ipamProviderAnnotation] = ipamProvider
+			}
+
+			clusterLabels := map[string]interface{}{}
+			if cni != "" || len(addonManifestsDirs) > 0 {
+				clusterLabels[clusterNameLabel] = args[0]
+			}
+
+			clusterSpec := map[string]interface{}{}
+			if len(podNetworkCIDRs) > 1 || len(serviceCIDRs) > 1 {
+				clusterSpec["clusterNetwork"] = map[string]interface{}{
+					"pods": map[string]interface{}{
+						"cidrBlocks": podNetworkCIDRs,
+					},
+					"services": map[string]interface{}{
+						"cidrBlocks": serviceCIDRs,
+					},
+				}
+			}
+
+			if len(clusterAnnotations) > 0 || len(clusterLabels) > 0 || len(clusterSpec) > 0 {
+				patchBytes, err := newKustomization("Cluster", map[string]interface{}{
+					"apiVersion": "cluster.x-k8s.io/v1beta1",
+					"kind":       "Cluster",
+					"metadata": map[string]interface{}{
+						"name":        "not-used",
+						"annotations": clusterAnnotations,
+						"labels":      clusterLabels,
+					},
+					"spec": clusterSpec,
+				})
+				if err != nil {
+					return err
+				}
+				clusterTemplatePatches = append(clusterTemplatePatches, &clusterTemplatePatch{Name: "cluster-annotations", Kustomization: patchBytes})
+			}
+
+			if len(apiServerCertSANs) > 0 {
+				patchBytes, err := newKustomization("KubeadmControlPlane", map[string]interface{}{
+					"apiVersion": "controlplane.cluster.x-k8s.io/v1beta1",
+					"kind":       "KubeadmControlPlane",
+					"metadata": map[string]interface{}{
+						"name": "not-used",
+					},
+					"spec": map[string]interface{}{
+						"kubeadmConfigSpec": map[string]interface{}{
+							"clusterConfiguration": map[string]interface{}{
+								"apiServer": map[string]interface{}{
+									"certSANs": apiServerCertSANs,
+								},
+							},
+						},
+					},
+				})
+				if err != nil {
+					return err
+				}
+				clusterTemplatePatches = append(clusterTemplatePatches, &clusterTemplatePatch{Name: "api-server-cert-sans", Kustomization: patchBytes})
+			}
+
+			clusterTemplatePatches = append(clusterTemplatePatches, userPatches...)
+
+			generateCmd.Env = append(generateCmd.Env, templateVariables...)
+			if err := checkTemplateVariables(generateCmd); err != nil {
+				return err
+			}
+
 			kustomizeWorkDir, err := os.MkdirTemp("", "knest")
 			if err != nil {
 				return err
@@ -295,15 +588,27 @@ func main() {
 			}
 			clusterTemplateFile.Close()
 
-			for patchFile, patchBytes := range clusterTemplatePatches {
+			if len(workerPools) > 0 {
+				if err := addWorkerPoolsToClusterTemplate(clusterTemplateFilePath, args[0], workerPools); err != nil {
+					return fmt.Errorf("add worker pools: %s", err)
+				}
+			}
+
+			if !storage.IsEmpty() {
+				if err := applyStorageOptionsToClusterTemplate(clusterTemplateFilePath, &storage); err != nil {
+					return fmt.Errorf("apply storage options: %s", err)
+				}
+			}
+
+			for _, patch := range clusterTemplatePatches {
 				kustomizationFilePath := filepath.Join(kustomizeWorkDir, "kustomization.yaml")
-				if err := os.WriteFile(kustomizationFilePath, patchBytes, 0644); err != nil {
+				if err := os.WriteFile(kustomizationFilePath, patch.Kustomization, 0644); err != nil {
 					return err
 				}
 
 				kustomizeCmd := exec.Command("kubectl", "kustomize", kustomizeWorkDir, "--output", clusterTemplateFilePath)
 				if err := runCommand(kustomizeCmd); err != nil {
-					return fmt.Errorf("kustomize cluster template for %s: %s", patchFile, err)
+					return fmt.Errorf("kustomize cluster template for %s: %s", patch.Name, err)
 				}
 			}
 
@@ -313,48 +618,61 @@ func main() {
 				return fmt.Errorf("create cluster resources: %s", err)
 			}
 
-			fmt.Println("Waiting for control plane to be initialized...")
-			if err := runCommand(exec.Command("kubectl", "wait", "clusters.cluster.x-k8s.io", args[0], "--namespace", targetNamespace, "--for", "condition=ControlPlaneInitialized", "--timeout", "-1s")); err != nil {
-				return fmt.Errorf("wait for control plane to be initialized: %s", err)
+			if cni != "" {
+				cniManifests, err := renderCNI(cni, cniEncapsulation, podNetworkCIDRs)
+				if err != nil {
+					return err
+				}
+				if err := applyObjectsServerSide(newClusterResourceSet(targetNamespace, args[0], "cni", cniManifests)); err != nil {
+					return fmt.Errorf("create CNI ClusterResourceSet: %s", err)
+				}
 			}
 
-			// TODO: LB support
-			nodePort, err := getCommandOutput(exec.Command("kubectl", "get", "service", args[0], "--namespace", targetNamespace, "-o", "jsonpath={.spec.ports[0].nodePort}"))
-			if err != nil {
-				return fmt.Errorf("get node port: %s", err)
-			}
-			clusterIP, err := getCommandOutput(exec.Command("kubectl", "get", "service", args[0], "--namespace", targetNamespace, "-o", "jsonpath={.spec.clusterIP}"))
-			if err != nil {
-				return fmt.Errorf("get cluster IP: %s", err)
+			for _, dir := range addonManifestsDirs {
+				if err := applyManifestsAddon(targetNamespace, args[0], dir); err != nil {
+					return fmt.Errorf("create addon ClusterResourceSet for %q: %s", dir, err)
+				}
 			}
 
-			encodedKubeconfigData, err := getCommandOutput(exec.Command("kubectl", "get", "secret", fmt.Sprintf("%s-kubeconfig", args[0]), "--namespace", targetNamespace, "-o", "jsonpath={.data.value}"))
-			if err != nil {
-				return fmt.Errorf("get kubeconfig: %s", err)
+			if autoscaleMax > 0 {
+				machineDeployments, err := getMachineDeployments(targetNamespace, args[0])
+				if err != nil {
+					return err
+				}
+				var machineDeploymentNames []string
+				for _, machineDeployment := range machineDeployments {
+					machineDeploymentNames = append(machineDeploymentNames, machineDeployment.GetName())
+				}
+				if err := enableAutoscaler(targetNamespace, args[0], machineDeploymentNames, autoscaleMin, autoscaleMax); err != nil {
+					return fmt.Errorf("enable autoscaler: %s", err)
+				}
 			}
-			kubeconfigData, err := base64.StdEncoding.DecodeString(encodedKubeconfigData)
-			if err != nil {
-				return fmt.Errorf("decode kubeconfig: %s", err)
+
+			fmt.Println("Waiting for control plane to be initialized...")
+			if err := runCommand(exec.Command("kubectl", "wait", "clusters.cluster.x-k8s.io", args[0], "--namespace", targetNamespace, "--for", "condition=ControlPlaneInitialized", "--timeout", "-1s")); err != nil {
+				return fmt.Errorf("wait for control plane to be initialized: %s", err)
 			}
 
-			kubeconfigFilePath := filepath.Join(homedir.HomeDir(), ".kube", fmt.Sprintf("knest.%s.%s.kubeconfig", targetNamespace, args[0]))
-			if err := os.WriteFile(kubeconfigFilePath, kubeconfigData, 0644); err != nil {
-				return fmt.Errorf("save kubeconfig: %s", err)
+			if route.BaseDomain != "" {
+				if err := createControlPlaneRoute(targetNamespace, args[0], &route); err != nil {
+					return fmt.Errorf("create control plane route: %s", err)
+				}
 			}
 
-			infraKubeconfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(clientcmd.NewDefaultClientConfigLoadingRules(), nil).ClientConfig()
+			kubeconfig, err := buildKubeconfig(targetNamespace, args[0])
 			if err != nil {
-				return fmt.Errorf("load infra kubeconfig: %s", err)
+				return err
 			}
-			infraHost, err := url.Parse(infraKubeconfig.Host)
-			if err != nil {
-				return fmt.Errorf("parse infra host: %s", err)
+			kubeconfigFilePath := defaultKubeconfigFilePath(targetNamespace, args[0])
+			if err := writeKubeconfigFile(kubeconfig, kubeconfigFilePath); err != nil {
+				return fmt.Errorf("save kubeconfig: %s", err)
 			}
 
-			if err := runCommand(exec.Command("kubectl", "config", "--kubeconfig", kubeconfigFilePath, "set-cluster", args[0],
-				"--server", fmt.Sprintf("%s://%s:%s", infraHost.Scheme, infraHost.Hostname(), nodePort),
-				"--tls-server-name", clusterIP)); err != nil {
-				return fmt.Errorf("update kubeconfig: %s", err)
+			for _, addon := range append(append([]string{}, addons...), addonCharts...) {
+				fmt.Printf("Installing addon %q\n", addon)
+				if err := installHelmAddon(kubeconfig, addon); err != nil {
+					return fmt.Errorf("install addon %q: %s", addon, err)
+				}
 			}
 
 			fmt.Printf("Your cluster %q is now accessible with the kubeconfig file %q\n", args[0], kubeconfigFilePath)
@@ -365,8 +683,9 @@ func main() {
 	cmdCreate.PersistentFlags().StringVar(&kubernetesVersion, "kubernetes-version", kubernetesVersion, "The Kubernetes version to use for the nested cluster.")
 	cmdCreate.PersistentFlags().IntVar(&controlPlaneMachineCount, "control-plane-machine-count", controlPlaneMachineCount, "The number of control plane machines for the nested cluster.")
 	cmdCreate.PersistentFlags().IntVar(&workerMachineCount, "worker-machine-count", workerMachineCount, "The number of worker machines for the nested cluster.")
-	cmdCreate.PersistentFlags().StringVar(&podNetworkCIDR, "pod-network-cidr", podNetworkCIDR, "Specify range of IP addresses for the pod network.")
-	cmdCreate.PersistentFlags().StringVar(&serviceCIDR, "service-cidr", serviceCIDR, "Specify range of IP address for service VIPs.")
+	cmdCreate.PersistentFlags().StringVar(&ipFamily, "ip-family", ipFamily, "The IP family of the nested cluster, support 'ipv4', 'ipv6' and 'dual'.")
+	cmdCreate.PersistentFlags().StringVar(&podNetworkCIDR, "pod-network-cidr", podNetworkCIDR, "Specify range of IP addresses for the pod network. For dual-stack clusters, specify an IPv4 and an IPv6 CIDR separated by comma. The default depends on --ip-family.")
+	cmdCreate.PersistentFlags().StringVar(&serviceCIDR, "service-cidr", serviceCIDR, "Specify range of IP address for service VIPs. For dual-stack clusters, specify an IPv4 and an IPv6 CIDR separated by comma. The default depends on --ip-family.")
 	cmdCreate.PersistentFlags().IntVar(&controlPlaneMachineCPUCores, "control-plane-machine-cpu-cores", controlPlaneMachineCPUCores, "The CPU cores of each control plane machine.")
 	cmdCreate.PersistentFlags().Var(&controlPlaneMachineMemorySize, "control-plane-machine-memory-size", "The memory size of each control plane machine")
 	cmdCreate.PersistentFlags().StringVar(&controlPlaneMachineKernelImage, "control-plane-machine-kernel-image", controlPlaneMachineKernelImage, "The kernel image of control plane machine.")
@@ -378,9 +697,40 @@ func main() {
 	cmdCreate.PersistentFlags().StringVar(&workerMachineRootfsImage, "worker-machine-rootfs-image", workerMachineRootfsImage, "The rootfs image of worker machine.")
 	cmdCreate.PersistentFlags().Var(&workerMachineRootfsSize, "worker-machine-rootfs-size", "The rootfs size of each worker machine.")
 	cmdCreate.PersistentFlags().BoolVar(&persistent, "persistent", persistent, "The machines of the nested cluster will be persistent, include persistent storage and IP address.")
-	cmdCreate.PersistentFlags().StringSliceVar(&machineAddresses, "machine-addresses", machineAddresses, "The candidate IP addresses for persistent machines of nested cluster.")
+	cmdCreate.PersistentFlags().StringSliceVar(&machineAddresses, "machine-addresses", machineAddresses, "The candidate IP addresses for persistent machines of nested cluster, each can be a START-END range, a CIDR subnet or a single IP address.")
+	cmdCreate.PersistentFlags().IntVar(&machineIPPoolConfig.Prefix, "machine-address-prefix", machineIPPoolConfig.Prefix, "The network prefix length of persistent machine addresses. If unspecified, the prefix of each CIDR subnet will be used.")
+	cmdCreate.PersistentFlags().StringVar(&machineIPPoolConfig.Gateway, "machine-gateway", machineIPPoolConfig.Gateway, "The default gateway of persistent machines.")
+	cmdCreate.PersistentFlags().StringSliceVar(&machineIPPoolConfig.DNSServers, "machine-dns-servers", machineIPPoolConfig.DNSServers, "The DNS servers of persistent machines.")
+	cmdCreate.PersistentFlags().StringVar(&storage.StorageClass, "storage-class", storage.StorageClass, "The StorageClass of the persistent machine volumes. If unspecified, the default StorageClass of the host cluster will be used.")
+	cmdCreate.PersistentFlags().StringVar(&storage.VolumeMode, "volume-mode", storage.VolumeMode, "The volume mode of the persistent machine volumes, support 'Filesystem' and 'Block'.")
+	cmdCreate.PersistentFlags().StringVar(&storage.AccessMode, "access-mode", storage.AccessMode, "The access mode of the persistent machine volumes, e.g. 'ReadWriteOnce' or 'ReadWriteMany'.")
+	cmdCreate.PersistentFlags().StringArrayVar(&workerDataDiskSpecs, "worker-data-disk", workerDataDiskSpecs, "Extra data disks of each persistent worker machine in the form of 'size=SIZE,storageClass=NAME,count=N'. Can be specified multiple times.")
+	cmdCreate.PersistentFlags().StringVar(&ipamProvider, "ipam-provider", ipamProvider, "The IPAM provider to allocate persistent machine addresses, support 'metal3' and 'in-cluster'.")
+	cmdCreate.PersistentFlags().StringVar(&sharedIPPoolName, "ip-pool", sharedIPPoolName, "The shared IPPool created by 'knest ippool create' to draw persistent machine addresses from, instead of creating an IPPool for the nested cluster.")
+	cmdCreate.PersistentFlags().StringSliceVar(&machinePreAllocations, "machine-pre-allocations", machinePreAllocations, "The pre-allocated addresses of persistent machines in the format of CLAIM=IP, where CLAIM is the name of the IPClaim.")
 	cmdCreate.PersistentFlags().StringVar(&hostClusterCNI, "host-cluster-cni", hostClusterCNI, "The CNI of the host cluster, support 'calico' and 'kube-ovn'.")
-	cmdCreate.PersistentFlags().StringVar(&from, "from", from, fmt.Sprintf("The URL of the cluster template to use for the nested cluster. If unspecified, the cluster template of cluster-api-provider-virtink %s will be used.", VirtinkProviderVersion))
+	cmdCreate.PersistentFlags().StringVar(&from, "from", from, fmt.Sprintf("The cluster template to use for the nested cluster: a URL, a local file path, or embedded://NAME for a template listed by 'knest templates list'. If unspecified, the cluster template of cluster-api-provider-virtink %s will be used.", VirtinkProviderVersion))
+	cmdCreate.PersistentFlags().StringVar(&controlPlaneServiceType, "control-plane-service-type", controlPlaneServiceType, "The type of the control plane Service, support 'NodePort', 'LoadBalancer' and 'ClusterIP'.")
+	cmdCreate.PersistentFlags().StringToStringVar(&controlPlaneServiceAnnotations, "control-plane-service-annotations", controlPlaneServiceAnnotations, "The annotations of the control plane Service, e.g. for load balancer configuration.")
+	cmdCreate.PersistentFlags().StringVar(&cni, "cni", cni, "The CNI plugin to install in the nested cluster, support 'calico' and 'cilium'.")
+	cmdCreate.PersistentFlags().StringVar(&cniEncapsulation, "cni-encapsulation", cniEncapsulation, "The encapsulation mode of the CNI plugin, support 'ipip' and 'vxlan' for Calico, 'vxlan' and 'geneve' for Cilium.")
+	cmdCreate.PersistentFlags().StringArrayVar(&addons, "addon", addons, fmt.Sprintf("The addon from the catalog to install in the nested cluster, support %s. Can be specified multiple times.", strings.Join(addonNames(), ", ")))
+	cmdCreate.PersistentFlags().StringArrayVar(&addonManifestsDirs, "addon-manifests", addonManifestsDirs, "The directory of manifests to apply to the nested cluster through a ClusterResourceSet. Can be specified multiple times.")
+	cmdCreate.PersistentFlags().StringArrayVar(&addonCharts, "addon-chart", addonCharts, "The local Helm chart archive to install in the nested cluster. Can be specified multiple times.")
+	cmdCreate.PersistentFlags().StringVar(&endpointHost, "endpoint-host", endpointHost, "The node IP or DNS name of the host cluster to access the NodePort of the control plane. If unspecified, a reachable node will be selected automatically.")
+	cmdCreate.PersistentFlags().StringSliceVar(&extraAPIServerCertSANs, "api-server-cert-sans", extraAPIServerCertSANs, "Extra Subject Alternative Names for the API server certificate.")
+	cmdCreate.PersistentFlags().StringVar(&route.BaseDomain, "control-plane-route-domain", route.BaseDomain, "The base domain to publish the API server at '<cluster>.<namespace>.<domain>' through a host ingress controller or gateway with TLS passthrough.")
+	cmdCreate.PersistentFlags().StringVar(&route.Type, "control-plane-route-type", route.Type, "The type of the control plane route, support 'ingress' and 'gateway'.")
+	cmdCreate.PersistentFlags().IntVar(&route.Port, "control-plane-route-port", route.Port, "The port of the host ingress controller or gateway.")
+	cmdCreate.PersistentFlags().StringVar(&route.IngressClass, "control-plane-ingress-class", route.IngressClass, "The ingress class of the control plane Ingress, which should support TLS passthrough.")
+	cmdCreate.PersistentFlags().StringVar(&route.Gateway, "control-plane-gateway", route.Gateway, "The gateway in the form of '[NAMESPACE/]NAME' to attach the control plane TLSRoute to.")
+	cmdCreate.PersistentFlags().IntVar(&autoscaleMin, "autoscale-min", autoscaleMin, "The minimum number of worker machines of each worker pool when autoscaling is enabled.")
+	cmdCreate.PersistentFlags().IntVar(&autoscaleMax, "autoscale-max", autoscaleMax, "The maximum number of worker machines of each worker pool. Autoscaling is enabled when it's greater than 0.")
+	cmdCreate.PersistentFlags().StringArrayVar(&templateVariableSets, "set", templateVariableSets, "The cluster template variable in the form of 'KEY=VALUE'. Can be specified multiple times.")
+	cmdCreate.PersistentFlags().StringArrayVar(&templateValuesFiles, "values", templateValuesFiles, "The YAML file of cluster template variables. Can be specified multiple times.")
+	cmdCreate.PersistentFlags().StringArrayVar(&patchFiles, "patch", patchFiles, "The kustomize patch file in the form of '[KIND[/NAME]=]FILE' to apply to the cluster template, either a strategic-merge patch or a JSON6902 patch. Can be specified multiple times.")
+	cmdCreate.PersistentFlags().StringArrayVar(&patchDirs, "patch-dir", patchDirs, "The directory of kustomize patch files to apply to the cluster template in lexical order. Can be specified multiple times.")
+	cmdCreate.PersistentFlags().StringArrayVar(&workerPoolSpecs, "worker-pool", workerPoolSpecs, "An additional worker pool in the form of 'name=NAME,count=N,cpu=N,memory=SIZE,rootfs-size=SIZE,labels=K=V;K=V,taints=K=V:EFFECT;K:EFFECT'. Can be specified multiple times.")
 
 	cmdDelete := &cobra.Command{
 		Use:   "delete CLUSTER",
@@ -388,19 +738,152 @@ func main() {
 		Short: "Delete a nested cluster.",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			clusterName := args[0]
+			if err := deleteClusterAutoscaler(targetNamespace, clusterName); err != nil {
+				return err
+			}
+			if err := deleteEtcdBackupSchedule(targetNamespace, clusterName); err != nil {
+				return err
+			}
+
+			var ipPoolName string
+			var ipClaimNames []string
+			clusterIPAMProvider := metal3IPAMProvider
+			clusterOutput, err := getCommandOutput(exec.Command("kubectl", "get", "clusters.cluster.x-k8s.io", clusterName, "--namespace", targetNamespace, "--ignore-not-found"))
+			if err != nil {
+				return fmt.Errorf("get cluster: %s", err)
+			}
+			if len(clusterOutput) > 0 {
+				cluster, err := getObject("clusters.cluster.x-k8s.io", clusterName, "--namespace", targetNamespace)
+				if err != nil {
+					return fmt.Errorf("get cluster: %s", err)
+				}
+				ipPoolName = clusterName
+				if provider := cluster.GetAnnotations()[ipamProviderAnnotation]; provider != "" {
+					clusterIPAMProvider = provider
+				}
+				if clusterIPAMProvider == metal3IPAMProvider {
+					ipPoolName, err = getClusterIPPoolName(targetNamespace, clusterName)
+					if err != nil {
+						return err
+					}
+				}
+				if ipPoolName != clusterName {
+					ipClaimNames, err = getClusterIPClaims(targetNamespace, clusterName)
+					if err != nil {
+						return err
+					}
+				}
+			}
+
 			if err := runCommand(exec.Command("kubectl", "delete", "clusters.cluster.x-k8s.io", clusterName, "--namespace", targetNamespace, "--wait", "--ignore-not-found")); err != nil {
 				return fmt.Errorf("delete cluster CR: %s", err)
 			}
 
-			if err := runCommand(exec.Command("kubectl", "delete", "ippool.ipam.metal3.io", clusterName, "--namespace", targetNamespace, "--wait", "--ignore-not-found")); err != nil {
-				return fmt.Errorf("delete IPPool CR: %s", err)
+			// A shared IPPool outlives the cluster, only the claims of the cluster's own machines are released.
+			if ipPoolName != "" && ipPoolName != clusterName {
+				if len(ipClaimNames) > 0 {
+					if err := runCommand(exec.Command("kubectl", append([]string{"delete", "ipclaims.ipam.metal3.io", "--namespace", targetNamespace, "--ignore-not-found"}, ipClaimNames...)...)); err != nil {
+						return fmt.Errorf("release IPClaims: %s", err)
+					}
+				}
+			} else if clusterIPAMProvider != metal3IPAMProvider {
+				if err := runCommand(exec.Command("kubectl", "delete", ipamPoolResources[clusterIPAMProvider], clusterName, "--namespace", targetNamespace, "--wait", "--ignore-not-found")); err != nil {
+					return fmt.Errorf("delete IPPool CR: %s", err)
+				}
+			} else {
+				// Ephemeral clusters don't install any IPAM provider, so there may be no pool resources at all.
+				metal3Installed, err := isIPAMProviderInstalled(metal3IPAMProvider)
+				if err != nil {
+					return err
+				}
+				if metal3Installed {
+					sharedIPPoolLabelValue, err := getCommandOutput(exec.Command("kubectl", "get", "ippool.ipam.metal3.io", clusterName, "--namespace", targetNamespace, "--ignore-not-found",
+						"-o", fmt.Sprintf("jsonpath={.metadata.labels.%s}", strings.ReplaceAll(sharedIPPoolLabel, ".", "\\."))))
+					if err != nil {
+						return fmt.Errorf("get IPPool CR: %s", err)
+					}
+					if sharedIPPoolLabelValue != "true" {
+						if err := runCommand(exec.Command("kubectl", "delete", "ippool.ipam.metal3.io", clusterName, "--namespace", targetNamespace, "--wait", "--ignore-not-found")); err != nil {
+							return fmt.Errorf("delete IPPool CR: %s", err)
+						}
+					}
+				}
+
+				// The cluster may be gone before its IPAM provider got recorded, e.g. after a failed create.
+				inClusterInstalled, err := isIPAMProviderInstalled(inClusterIPAMProvider)
+				if err != nil {
+					return err
+				}
+				if inClusterInstalled {
+					if err := runCommand(exec.Command("kubectl", "delete", ipamPoolResources[inClusterIPAMProvider], "--namespace", targetNamespace,
+						"--selector", fmt.Sprintf("%s=%s", clusterNameLabel, clusterName), "--wait", "--ignore-not-found")); err != nil {
+						return fmt.Errorf("delete IPPool CR: %s", err)
+					}
+				}
+			}
+
+			if err := runCommand(exec.Command("kubectl", "delete", "clusterresourcesets.addons.cluster.x-k8s.io,configmaps", "--namespace", targetNamespace,
+				"--selector", fmt.Sprintf("%s=%s", clusterNameLabel, clusterName), "--ignore-not-found")); err != nil {
+				return fmt.Errorf("delete ClusterResourceSets: %s", err)
+			}
+
+			if err := unmergeKubeconfig(targetNamespace, clusterName); err != nil {
+				return fmt.Errorf("remove merged kubeconfig: %s", err)
+			}
+			os.Remove(defaultKubeconfigFilePath(targetNamespace, clusterName))
+			return nil
+		},
+	}
+
+	cmdClone := &cobra.Command{
+		Use:   "clone SRC DST",
+		Args:  cobra.ExactArgs(2),
+		Short: "Clone a persistent nested cluster.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			options := &cloneOptions{
+				SharedIPPoolName:    sharedIPPoolName,
+				MachineIPPoolConfig: &machineIPPoolConfig,
+			}
+			if sharedIPPoolName != "" && (len(machineAddresses) > 0 || machineIPPoolConfig.Prefix != 0 || machineIPPoolConfig.Gateway != "" || len(machineIPPoolConfig.DNSServers) > 0) {
+				return fmt.Errorf("--machine-addresses and other IPPool flags can't be used with --ip-pool, configure the shared IPPool instead")
+			}
+			if len(machineAddresses) > 0 {
+				var err error
+				options.MachineAddressRanges, err = parseIPPoolRanges(machineAddresses)
+				if err != nil {
+					return err
+				}
+			}
+
+			if err := cloneCluster(targetNamespace, args[0], args[1], options); err != nil {
+				return err
+			}
+
+			fmt.Println("Waiting for control plane to be initialized...")
+			if err := runCommand(exec.Command("kubectl", "wait", "clusters.cluster.x-k8s.io", args[1], "--namespace", targetNamespace, "--for", "condition=ControlPlaneInitialized", "--timeout", "-1s")); err != nil {
+				return fmt.Errorf("wait for control plane to be initialized: %s", err)
+			}
+
+			kubeconfig, err := buildKubeconfig(targetNamespace, args[1])
+			if err != nil {
+				return err
+			}
+			kubeconfigFilePath := defaultKubeconfigFilePath(targetNamespace, args[1])
+			if err := writeKubeconfigFile(kubeconfig, kubeconfigFilePath); err != nil {
+				return fmt.Errorf("save kubeconfig: %s", err)
 			}
 
-			os.Remove(filepath.Join(homedir.HomeDir(), ".kube", fmt.Sprintf("knest.%s.%s.kubeconfig", targetNamespace, args[0])))
+			fmt.Printf("Your cluster %q is now accessible with the kubeconfig file %q\n", args[1], kubeconfigFilePath)
 			return nil
 		},
 	}
 
+	cmdClone.PersistentFlags().StringSliceVar(&machineAddresses, "machine-addresses", machineAddresses, "The candidate IP addresses for the machines of the clone, each can be a START-END range, a CIDR subnet or a single IP address. Required unless the source cluster uses a shared IPPool.")
+	cmdClone.PersistentFlags().IntVar(&machineIPPoolConfig.Prefix, "machine-address-prefix", machineIPPoolConfig.Prefix, "The network prefix length of the machine addresses. If unspecified, that of the source cluster will be used.")
+	cmdClone.PersistentFlags().StringVar(&machineIPPoolConfig.Gateway, "machine-gateway", machineIPPoolConfig.Gateway, "The default gateway of the machines. If unspecified, that of the source cluster will be used.")
+	cmdClone.PersistentFlags().StringSliceVar(&machineIPPoolConfig.DNSServers, "machine-dns-servers", machineIPPoolConfig.DNSServers, "The DNS servers of the machines. If unspecified, those of the source cluster will be used.")
+	cmdClone.PersistentFlags().StringVar(&sharedIPPoolName, "ip-pool", sharedIPPoolName, "The shared IPPool to draw the machine addresses of the clone from.")
+
 	cmdList := &cobra.Command{
 		Use:   "list",
 		Short: "List nested clusters.",
@@ -417,24 +900,915 @@ func main() {
 		Args:  cobra.ExactArgs(1),
 		Short: "Scale a nested cluster.",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkClusterNotStopped(targetNamespace, args[0]); err != nil {
+				return err
+			}
+			if newControlPlaneMachineCount > 0 && newControlPlaneMachineCount%2 == 0 {
+				return fmt.Errorf("the number of control plane machines should be odd to keep etcd quorum: %d", newControlPlaneMachineCount)
+			}
+
 			if newControlPlaneMachineCount > 0 {
-				if err := runCommand(exec.Command("kubectl", "patch", "kubeadmcontrolplane.controlplane.cluster.x-k8s.io", fmt.Sprintf("%s-cp", args[0]),
+				controlPlane, err := getControlPlane(targetNamespace, args[0])
+				if err != nil {
+					return err
+				}
+
+				fmt.Printf("Scaling control plane %q from %d to %d replicas\n", controlPlane.GetName(), getReplicas(controlPlane), newControlPlaneMachineCount)
+				if err := runCommand(exec.Command("kubectl", "patch", "kubeadmcontrolplane.controlplane.cluster.x-k8s.io", controlPlane.GetName(),
 					"--namespace", targetNamespace, "--type", "merge", "--patch", fmt.Sprintf("{\"spec\":{\"replicas\":%d}}", newControlPlaneMachineCount))); err != nil {
 					return fmt.Errorf("set control-plane replicas: %s", err)
 				}
+
+				if waitScale {
+					fmt.Println("Waiting for control plane replicas to be ready...")
+					if err := waitForReplicasReady("kubeadmcontrolplane.controlplane.cluster.x-k8s.io", targetNamespace, controlPlane.GetName(), replicasTimeout); err != nil {
+						return fmt.Errorf("wait for control plane replicas to be ready: %s", err)
+					}
+				}
 			}
 
 			if newWorkerMachineCount >= 0 {
-				if err := runCommand(exec.Command("kubectl", "patch", "machinedeployment.cluster.x-k8s.io", fmt.Sprintf("%s-md-0", args[0]),
-					"--namespace", targetNamespace, "--type", "merge", "--patch", fmt.Sprintf("{\"spec\":{\"replicas\":%d}}", newWorkerMachineCount))); err != nil {
+				machineDeployments, err := getMachineDeployments(targetNamespace, args[0])
+				if err != nil {
+					return err
+				}
+				// Worker pools added by knest are scaled by 'knest pool scale'.
+				machineDeployment, err := findPrimaryMachineDeployment(machineDeployments)
+				if err != nil {
+					return fmt.Errorf("cluster %q: %s", args[0], err)
+				}
+
+				fmt.Printf("Scaling workers %q from %d to %d replicas\n", machineDeployment.GetName(), getReplicas(machineDeployment), newWorkerMachineCount)
+				if err := runCommand(exec.Command("kubectl", "patch", "machinedeployment.cluster.x-k8s.io", machineDeployment.GetName(),
+					"--namespace", targetNamespace, "--type", "merge", "--patch", fmt.Sprintf("{\"spec\":{\"replicas\":%d}}", newWorkerMachineCount))); err != nil {
 					return fmt.Errorf("set worker replicas: %s", err)
 				}
+
+				if waitScale {
+					fmt.Println("Waiting for worker replicas to be ready...")
+					if err := waitForReplicasReady("machinedeployment.cluster.x-k8s.io", targetNamespace, machineDeployment.GetName(), replicasTimeout); err != nil {
+						return fmt.Errorf("wait for worker replicas to be ready: %s", err)
+					}
+				}
 			}
 			return nil
 		},
 	}
 	cmdScale.PersistentFlags().IntVar(&newControlPlaneMachineCount, "control-plane-machine-count", newControlPlaneMachineCount, "The number of control plane machines for the nested cluster.")
 	cmdScale.PersistentFlags().IntVar(&newWorkerMachineCount, "worker-machine-count", newWorkerMachineCount, "The number of worker machines for the nested cluster.")
+	cmdScale.PersistentFlags().BoolVar(&waitScale, "wait", waitScale, "Wait until the new replicas are ready.")
+
+	var stopForce bool
+	cmdStop := &cobra.Command{
+		Use:   "stop CLUSTER",
+		Args:  cobra.ExactArgs(1),
+		Short: "Stop a nested cluster to free its resources on the host cluster.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := stopCluster(targetNamespace, args[0], stopForce); err != nil {
+				return err
+			}
+			fmt.Printf("Cluster %q stopped\n", args[0])
+			return nil
+		},
+	}
+	cmdStop.PersistentFlags().BoolVar(&stopForce, "force", stopForce, "Stop the nested cluster even if the state of its machines would be lost.")
+
+	cmdStart := &cobra.Command{
+		Use:   "start CLUSTER",
+		Args:  cobra.ExactArgs(1),
+		Short: "Start a stopped nested cluster.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := startCluster(targetNamespace, args[0]); err != nil {
+				return err
+			}
+			fmt.Printf("Cluster %q started\n", args[0])
+			return nil
+		},
+	}
+
+	cmdPool := &cobra.Command{
+		Use:   "pool",
+		Short: "Manage worker pools of a nested cluster.",
+	}
+
+	cmdPoolAdd := &cobra.Command{
+		Use:   "add CLUSTER POOL_SPEC",
+		Args:  cobra.ExactArgs(2),
+		Short: "Add a worker pool to a nested cluster.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkClusterNotStopped(targetNamespace, args[0]); err != nil {
+				return err
+			}
+			pool, err := parseWorkerPool(args[1])
+			if err != nil {
+				return err
+			}
+			if err := validateWorkerPoolName(args[0], pool.Name); err != nil {
+				return err
+			}
+			machineDeploymentOutput, err := getCommandOutput(exec.Command("kubectl", "get", "machinedeployment.cluster.x-k8s.io", fmt.Sprintf("%s-%s", args[0], pool.Name),
+				"--namespace", targetNamespace, "--ignore-not-found"))
+			if err != nil {
+				return fmt.Errorf("get worker pool: %s", err)
+			}
+			if len(machineDeploymentOutput) > 0 {
+				return fmt.Errorf("worker pool %q already exists", pool.Name)
+			}
+
+			baseMachineDeployment, baseMachineTemplate, baseConfigTemplate, err := getWorkerPoolBaseObjects(targetNamespace, args[0])
+			if err != nil {
+				return err
+			}
+			poolObjs, err := newWorkerPoolObjects(args[0], pool, baseMachineDeployment, baseMachineTemplate, baseConfigTemplate)
+			if err != nil {
+				return fmt.Errorf("generate worker pool: %s", err)
+			}
+			if err := applyObjects(poolObjs); err != nil {
+				return fmt.Errorf("create worker pool: %s", err)
+			}
+			return nil
+		},
+	}
+
+	cmdPoolDelete := &cobra.Command{
+		Use:   "delete CLUSTER POOL",
+		Args:  cobra.ExactArgs(2),
+		Short: "Delete a worker pool from a nested cluster.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkClusterNotStopped(targetNamespace, args[0]); err != nil {
+				return err
+			}
+			name := fmt.Sprintf("%s-%s", args[0], args[1])
+			machineDeployment, err := getObject("machinedeployment.cluster.x-k8s.io", name, "--namespace", targetNamespace)
+			if err != nil {
+				return fmt.Errorf("get worker pool: %s", err)
+			}
+			if machineDeployment.GetLabels()[workerPoolLabel] != args[1] {
+				return fmt.Errorf("%q is not a worker pool added by knest, scale it to 0 instead", args[1])
+			}
+
+			if err := runCommand(exec.Command("kubectl", "delete", "machinedeployment.cluster.x-k8s.io", name, "--namespace", targetNamespace, "--wait")); err != nil {
+				return fmt.Errorf("delete MachineDeployment: %s", err)
+			}
+
+			infrastructureRefName, _, _ := unstructured.NestedString(machineDeployment.Object, "spec", "template", "spec", "infrastructureRef", "name")
+			if err := runCommand(exec.Command("kubectl", "delete", "virtinkmachinetemplate.infrastructure.cluster.x-k8s.io", infrastructureRefName, "--namespace", targetNamespace, "--ignore-not-found")); err != nil {
+				return fmt.Errorf("delete VirtinkMachineTemplate: %s", err)
+			}
+			configRefName, _, _ := unstructured.NestedString(machineDeployment.Object, "spec", "template", "spec", "bootstrap", "configRef", "name")
+			if err := runCommand(exec.Command("kubectl", "delete", "kubeadmconfigtemplate.bootstrap.cluster.x-k8s.io", configRefName, "--namespace", targetNamespace, "--ignore-not-found")); err != nil {
+				return fmt.Errorf("delete KubeadmConfigTemplate: %s", err)
+			}
+			return nil
+		},
+	}
+
+	cmdPoolScale := &cobra.Command{
+		Use:   "scale CLUSTER POOL",
+		Args:  cobra.ExactArgs(2),
+		Short: "Scale a worker pool of a nested cluster.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkClusterNotStopped(targetNamespace, args[0]); err != nil {
+				return err
+			}
+			if !cmd.Flags().Changed("count") {
+				return fmt.Errorf("--count is required")
+			}
+			if newWorkerPoolCount < 0 {
+				return fmt.Errorf("invalid worker pool count: %d", newWorkerPoolCount)
+			}
+
+			name := fmt.Sprintf("%s-%s", args[0], args[1])
+			machineDeploymentOutput, err := getCommandOutput(exec.Command("kubectl", "get", "machinedeployment.cluster.x-k8s.io", name, "--namespace", targetNamespace, "--ignore-not-found"))
+			if err != nil {
+				return fmt.Errorf("get worker pool: %s", err)
+			}
+			if len(machineDeploymentOutput) == 0 {
+				return fmt.Errorf("worker pool %q not found in cluster %q", args[1], args[0])
+			}
+			machineDeployment, err := getObject("machinedeployment.cluster.x-k8s.io", name, "--namespace", targetNamespace)
+			if err != nil {
+				return fmt.Errorf("get worker pool: %s", err)
+			}
+			if machineDeployment.GetLabels()[workerPoolLabel] != args[1] {
+				return fmt.Errorf("%q is not a worker pool added by knest, use 'knest scale --worker-machine-count' instead", args[1])
+			}
+
+			fmt.Printf("Scaling worker pool %q from %d to %d replicas\n", args[1], getReplicas(machineDeployment), newWorkerPoolCount)
+			if err := runCommand(exec.Command("kubectl", "patch", "machinedeployment.cluster.x-k8s.io", name,
+				"--namespace", targetNamespace, "--type", "merge", "--patch", fmt.Sprintf("{\"spec\":{\"replicas\":%d}}", newWorkerPoolCount))); err != nil {
+				return fmt.Errorf("set worker pool replicas: %s", err)
+			}
+			return nil
+		},
+	}
+	cmdPoolScale.PersistentFlags().IntVar(&newWorkerPoolCount, "count", newWorkerPoolCount, "The number of worker machines for the worker pool.")
+
+	cmdPoolList := &cobra.Command{
+		Use:   "list CLUSTER",
+		Args:  cobra.ExactArgs(1),
+		Short: "List worker pools of a nested cluster.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := runCommand(exec.Command("kubectl", "get", "machinedeployments.cluster.x-k8s.io", "--namespace", targetNamespace,
+				"--selector", fmt.Sprintf("%s=%s", clusterNameLabel, args[0]), "--label-columns", workerPoolLabel)); err != nil {
+				return fmt.Errorf("list MachineDeployments: %s", err)
+			}
+			return nil
+		},
+	}
+
+	cmdPool.AddCommand(cmdPoolAdd)
+	cmdPool.AddCommand(cmdPoolDelete)
+	cmdPool.AddCommand(cmdPoolScale)
+	cmdPool.AddCommand(cmdPoolList)
+
+	cmdKubeconfig := &cobra.Command{
+		Use:   "kubeconfig CLUSTER",
+		Args:  cobra.ExactArgs(1),
+		Short: "Save the kubeconfig of a nested cluster.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if hostContext != "" && !kubeconfigExec {
+				return fmt.Errorf("--host-context requires --exec")
+			}
+
+			if kubeconfigRevoke {
+				if credential.User == "" {
+					return fmt.Errorf("--revoke requires --user")
+				}
+				if err := revokeScopedCredential(targetNamespace, args[0], credential.User); err != nil {
+					return err
+				}
+				fmt.Printf("The credentials of user %q to cluster %q are revoked\n", credential.User, args[0])
+				return nil
+			}
+
+			if credential.User != "" {
+				if kubeconfigMerge {
+					return fmt.Errorf("--merge is not supported with --user")
+				}
+				if kubeconfigExec {
+					return fmt.Errorf("--exec is not supported with --user")
+				}
+
+				kubeconfig, err := buildScopedKubeconfig(targetNamespace, args[0], &credential)
+				if err != nil {
+					return err
+				}
+				kubeconfigFilePath := kubeconfigOutput
+				if kubeconfigFilePath == "" {
+					kubeconfigFilePath = filepath.Join(homedir.HomeDir(), ".kube", fmt.Sprintf("knest.%s.%s.%s.kubeconfig", targetNamespace, args[0], credential.User))
+				}
+				if err := writeKubeconfigFile(kubeconfig, kubeconfigFilePath); err != nil {
+					return fmt.Errorf("save kubeconfig: %s", err)
+				}
+				fmt.Printf("Your cluster %q is now accessible as user %q with the kubeconfig file %q\n", args[0], credential.User, kubeconfigFilePath)
+				return nil
+			}
+
+			kubeconfigFilePath := kubeconfigOutput
+			if kubeconfigFilePath == "" {
+				kubeconfigFilePath = defaultKubeconfigFilePath(targetNamespace, args[0])
+			}
+
+			var kubeconfig *clientcmdapi.Config
+			if !kubeconfigRefresh {
+				if _, err := os.Stat(kubeconfigFilePath); err == nil {
+					kubeconfig, err = clientcmd.LoadFromFile(kubeconfigFilePath)
+					if err != nil {
+						return fmt.Errorf("load kubeconfig: %s", err)
+					}
+				}
+			}
+			if kubeconfig == nil {
+				var err error
+				kubeconfig, err = buildKubeconfig(targetNamespace, args[0])
+				if err != nil {
+					return err
+				}
+			}
+
+			if kubeconfigExec {
+				if err := useExecCredential(kubeconfig, targetNamespace, args[0], hostContext); err != nil {
+					return err
+				}
+			}
+
+			if err := writeKubeconfigFile(kubeconfig, kubeconfigFilePath); err != nil {
+				return fmt.Errorf("save kubeconfig: %s", err)
+			}
+			fmt.Printf("Your cluster %q is now accessible with the kubeconfig file %q\n", args[0], kubeconfigFilePath)
+
+			if kubeconfigMerge {
+				contextName, err := mergeKubeconfig(targetNamespace, args[0], kubeconfig)
+				if err != nil {
+					return fmt.Errorf("merge kubeconfig: %s", err)
+				}
+				fmt.Printf("Your cluster %q is now accessible with the context %q of the default kubeconfig\n", args[0], contextName)
+			}
+			return nil
+		},
+	}
+	cmdKubeconfig.PersistentFlags().StringVarP(&kubeconfigOutput, "output", "o", kubeconfigOutput, "The path to save the kubeconfig file. If unspecified, it will be saved in the canonical kubeconfig directory.")
+	cmdKubeconfig.PersistentFlags().BoolVar(&kubeconfigMerge, "merge", kubeconfigMerge, "Merge the kubeconfig into the default kubeconfig as a named context.")
+	cmdKubeconfig.PersistentFlags().BoolVar(&kubeconfigRefresh, "refresh", kubeconfigRefresh, "Rebuild the kubeconfig from the kubeconfig secret and the current control plane Service.")
+	cmdKubeconfig.PersistentFlags().BoolVar(&kubeconfigExec, "exec", kubeconfigExec, "Use 'knest credential' as the exec credential plugin instead of saving static credentials, so that the nested cluster is accessible only with access to the host cluster.")
+	cmdKubeconfig.PersistentFlags().StringVar(&hostContext, "host-context", hostContext, "The context of the host cluster for the exec credential plugin to use with --exec. If unspecified, the current context will be used.")
+	cmdKubeconfig.PersistentFlags().StringVar(&credential.User, "user", credential.User, "Issue a scoped credential for the user instead of using the admin credential.")
+	cmdKubeconfig.PersistentFlags().StringSliceVar(&credential.Groups, "group", credential.Groups, "The groups of the user recorded in the certificate, which are not bound to --role. Only supported by 'certificate' credentials, and 'system:' groups are rejected.")
+	cmdKubeconfig.PersistentFlags().DurationVar(&credential.TTL, "ttl", credential.TTL, "The time to live of the user credential.")
+	cmdKubeconfig.PersistentFlags().StringVar(&credential.Role, "role", credential.Role, "The ClusterRole to bind to the user in the nested cluster, e.g. 'view' or 'edit'.")
+	cmdKubeconfig.PersistentFlags().BoolVar(&kubeconfigRevoke, "revoke", kubeconfigRevoke, "Revoke the scoped credentials issued for the user by deleting their ClusterRoleBindings and ServiceAccount.")
+	cmdKubeconfig.PersistentFlags().StringVar(&credential.Type, "credential-type", credential.Type, "The type of the user credential, support 'certificate' (signed by the nested cluster CA) and 'token' (a ServiceAccount token).")
+
+	cmdCredential := &cobra.Command{
+		Use:   "credential CLUSTER",
+		Args:  cobra.ExactArgs(1),
+		Short: "Print the credential of a nested cluster in the ExecCredential format.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			execCredential, err := getExecCredential(targetNamespace, args[0], hostContext)
+			if err != nil {
+				return err
+			}
+			data, err := json.Marshal(execCredential)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s\n", data)
+			return nil
+		},
+	}
+	cmdCredential.PersistentFlags().StringVar(&hostContext, "host-context", hostContext, "The context of the host cluster in the default kubeconfig. If unspecified, the current context will be used.")
+
+	cmdProxy := &cobra.Command{
+		Use:   "proxy CLUSTER",
+		Args:  cobra.ExactArgs(1),
+		Short: "Access a nested cluster through a local port-forward via the host API server.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			localPort := proxyPort
+			if localPort == 0 {
+				var err error
+				localPort, err = getFreePort(proxyAddress)
+				if err != nil {
+					return fmt.Errorf("get free local port: %s", err)
+				}
+			}
+
+			kubeconfig, servicePort, err := buildProxyKubeconfig(targetNamespace, args[0], proxyAddress, localPort)
+			if err != nil {
+				return err
+			}
+			kubeconfigFilePath := proxyKubeconfigOutput
+			if kubeconfigFilePath == "" {
+				kubeconfigFilePath = filepath.Join(homedir.HomeDir(), ".kube", fmt.Sprintf("knest.%s.%s.proxy.kubeconfig", targetNamespace, args[0]))
+			}
+			if err := writeKubeconfigFile(kubeconfig, kubeconfigFilePath); err != nil {
+				return fmt.Errorf("save kubeconfig: %s", err)
+			}
+
+			fmt.Printf("Your cluster %q is now accessible with the kubeconfig file %q while this command is running\n", args[0], kubeconfigFilePath)
+			if err := runCommand(exec.Command("kubectl", "port-forward", fmt.Sprintf("service/%s", args[0]), fmt.Sprintf("%d:%d", localPort, servicePort),
+				"--namespace", targetNamespace, "--address", proxyAddress)); err != nil {
+				return fmt.Errorf("port-forward control plane service: %s", err)
+			}
+			return nil
+		},
+	}
+	cmdProxy.PersistentFlags().StringVar(&proxyAddress, "address", proxyAddress, "The local address to listen on.")
+	cmdProxy.PersistentFlags().IntVar(&proxyPort, "port", proxyPort, "The local port to listen on. If unspecified, a free port will be chosen.")
+	cmdProxy.PersistentFlags().StringVarP(&proxyKubeconfigOutput, "output", "o", proxyKubeconfigOutput, "The path to save the kubeconfig file. If unspecified, it will be saved in the canonical kubeconfig directory.")
+
+	cmdAddons := &cobra.Command{
+		Use:   "addons",
+		Short: "Manage addons of a nested cluster.",
+	}
+
+	cmdAddonsList := &cobra.Command{
+		Use:   "list CLUSTER",
+		Args:  cobra.ExactArgs(1),
+		Short: "List addons of a nested cluster.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkHelm(); err != nil {
+				return err
+			}
+			kubeconfig, err := buildKubeconfig(targetNamespace, args[0])
+			if err != nil {
+				return err
+			}
+			releases, err := listHelmAddons(kubeconfig)
+			if err != nil {
+				return fmt.Errorf("list Helm releases: %s", err)
+			}
+			clusterResourceSets, err := getObjects("clusterresourcesets.addons.cluster.x-k8s.io", "--namespace", targetNamespace,
+				"--selector", fmt.Sprintf("%s=%s,%s", clusterNameLabel, args[0], addonLabel))
+			if err != nil {
+				return fmt.Errorf("list ClusterResourceSets: %s", err)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 3, ' ', 0)
+			fmt.Fprintln(w, "NAME\tTYPE\tSTATUS")
+			releaseStatuses := map[string]string{}
+			for _, release := range releases {
+				releaseStatuses[release.Name] = release.Status
+			}
+			for _, name := range addonNames() {
+				status, ok := releaseStatuses[name]
+				if !ok {
+					status = "not-installed"
+				}
+				fmt.Fprintf(w, "%s\tcatalog\t%s\n", name, status)
+			}
+			for _, release := range releases {
+				if _, ok := addonCatalog[release.Name]; !ok {
+					fmt.Fprintf(w, "%s\tchart\t%s\n", release.Name, release.Status)
+				}
+			}
+			for _, clusterResourceSet := range clusterResourceSets {
+				fmt.Fprintf(w, "%s\tmanifests\tapplied\n", clusterResourceSet.GetLabels()[addonLabel])
+			}
+			return w.Flush()
+		},
+	}
+
+	cmdAddonsEnable := &cobra.Command{
+		Use:   "enable CLUSTER [ADDON|CHART]...",
+		Args:  cobra.MinimumNArgs(1),
+		Short: "Enable addons from the catalog or local Helm chart archives in a nested cluster.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 1 {
+				if err := checkHelm(); err != nil {
+					return err
+				}
+			}
+			if len(addonManifestsDirs) > 0 {
+				if err := runCommand(exec.Command("kubectl", "label", "clusters.cluster.x-k8s.io", args[0], "--namespace", targetNamespace, "--overwrite",
+					fmt.Sprintf("%s=%s", clusterNameLabel, args[0]))); err != nil {
+					return fmt.Errorf("label cluster: %s", err)
+				}
+			}
+			for _, dir := range addonManifestsDirs {
+				if err := applyManifestsAddon(targetNamespace, args[0], dir); err != nil {
+					return fmt.Errorf("create addon ClusterResourceSet for %q: %s", dir, err)
+				}
+			}
+
+			if len(args) == 1 {
+				return nil
+			}
+			kubeconfig, err := buildKubeconfig(targetNamespace, args[0])
+			if err != nil {
+				return err
+			}
+			for _, addon := range args[1:] {
+				if err := installHelmAddon(kubeconfig, addon); err != nil {
+					return fmt.Errorf("install addon %q: %s", addon, err)
+				}
+			}
+			return nil
+		},
+	}
+	cmdAddonsEnable.PersistentFlags().StringArrayVar(&addonManifestsDirs, "manifests", addonManifestsDirs, "The directory of manifests to apply to the nested cluster through a ClusterResourceSet. Can be specified multiple times.")
+
+	cmdAddonsDisable := &cobra.Command{
+		Use:   "disable CLUSTER ADDON",
+		Args:  cobra.ExactArgs(2),
+		Short: "Disable an addon of a nested cluster.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			kubeconfig, err := buildKubeconfig(targetNamespace, args[0])
+			if err != nil {
+				return err
+			}
+
+			clusterResourceSetOutput, err := getCommandOutput(exec.Command("kubectl", "get", "clusterresourcesets.addons.cluster.x-k8s.io", fmt.Sprintf("%s-addon-%s", args[0], args[1]),
+				"--namespace", targetNamespace, "--ignore-not-found"))
+			if err != nil {
+				return fmt.Errorf("get addon ClusterResourceSet: %s", err)
+			}
+			if len(clusterResourceSetOutput) > 0 {
+				return deleteManifestsAddon(kubeconfig, targetNamespace, args[0], args[1])
+			}
+			if err := checkHelm(); err != nil {
+				return err
+			}
+			return uninstallHelmAddon(kubeconfig, args[1])
+		},
+	}
+
+	cmdAddons.AddCommand(cmdAddonsList)
+	cmdAddons.AddCommand(cmdAddonsEnable)
+	cmdAddons.AddCommand(cmdAddonsDisable)
+
+	cmdIPs := &cobra.Command{
+		Use:   "ips CLUSTER",
+		Args:  cobra.ExactArgs(1),
+		Short: "List the static IP addresses allocated to the machines of a persistent nested cluster.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ipPoolName, err := getClusterIPPoolName(targetNamespace, args[0])
+			if err != nil {
+				return err
+			}
+			return printIPAllocations(os.Stdout, targetNamespace, ipPoolName)
+		},
+	}
+
+	cmdIPsAdd := &cobra.Command{
+		Use:   "add CLUSTER RANGE",
+		Args:  cobra.ExactArgs(2),
+		Short: "Add a range of addresses to the IP pool of a persistent nested cluster.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ipPoolName, err := getClusterIPPoolName(targetNamespace, args[0])
+			if err != nil {
+				return err
+			}
+			return addIPPoolRange(targetNamespace, ipPoolName, args[1])
+		},
+	}
+
+	cmdIPsRemove := &cobra.Command{
+		Use:   "remove CLUSTER RANGE",
+		Args:  cobra.ExactArgs(2),
+		Short: "Remove a range of unallocated addresses from the IP pool of a persistent nested cluster.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ipPoolName, err := getClusterIPPoolName(targetNamespace, args[0])
+			if err != nil {
+				return err
+			}
+			return removeIPPoolRange(targetNamespace, ipPoolName, args[1])
+		},
+	}
+
+	cmdIPs.AddCommand(cmdIPsAdd)
+	cmdIPs.AddCommand(cmdIPsRemove)
+
+	cmdIPPool := &cobra.Command{
+		Use:   "ippool",
+		Short: "Manage IP pools shared by persistent nested clusters.",
+	}
+
+	var (
+		sharedIPPoolAddresses      []string
+		sharedIPPoolConfig         = ipPoolConfig{}
+		sharedIPPoolPreAllocations []string
+	)
+	cmdIPPoolCreate := &cobra.Command{
+		Use:   "create NAME",
+		Args:  cobra.ExactArgs(1),
+		Short: "Create an IP pool shared by persistent nested clusters.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(sharedIPPoolAddresses) == 0 {
+				return fmt.Errorf("--addresses is required")
+			}
+			ranges, err := parseIPPoolRanges(sharedIPPoolAddresses)
+			if err != nil {
+				return err
+			}
+			sharedIPPoolConfig.PreAllocations, err = parsePreAllocations(sharedIPPoolPreAllocations)
+			if err != nil {
+				return err
+			}
+			if err := validateIPPoolConfig(ranges, &sharedIPPoolConfig); err != nil {
+				return err
+			}
+			if err := checkIPPoolRangesAgainstNodes(ranges); err != nil {
+				return err
+			}
+
+			if err := installIPAMProvider(metal3IPAMProvider); err != nil {
+				return err
+			}
+			ipPoolData, err := renderIPPool(args[0], targetNamespace, "", ranges, &sharedIPPoolConfig)
+			if err != nil {
+				return err
+			}
+			createIPPoolCmd := exec.Command("kubectl", "create", "-f", "-")
+			createIPPoolCmd.Stdin = bytes.NewReader(ipPoolData)
+			if err := runCommand(createIPPoolCmd); err != nil {
+				return fmt.Errorf("create IPPool: %s", err)
+			}
+			return nil
+		},
+	}
+	cmdIPPoolCreate.PersistentFlags().StringSliceVar(&sharedIPPoolAddresses, "addresses", sharedIPPoolAddresses, "The addresses of the IP pool, each can be a START-END range, a CIDR subnet or a single IP address.")
+	cmdIPPoolCreate.PersistentFlags().IntVar(&sharedIPPoolConfig.Prefix, "prefix", sharedIPPoolConfig.Prefix, "The network prefix length of the addresses. If unspecified, the prefix of each CIDR subnet will be used.")
+	cmdIPPoolCreate.PersistentFlags().StringVar(&sharedIPPoolConfig.Gateway, "gateway", sharedIPPoolConfig.Gateway, "The default gateway of the machines.")
+	cmdIPPoolCreate.PersistentFlags().StringSliceVar(&sharedIPPoolConfig.DNSServers, "dns-servers", sharedIPPoolConfig.DNSServers, "The DNS servers of the machines.")
+	cmdIPPoolCreate.PersistentFlags().StringSliceVar(&sharedIPPoolPreAllocations, "pre-allocations", sharedIPPoolPreAllocations, "The pre-allocated addresses in the format of CLAIM=IP, where CLAIM is the name of the IPClaim.")
+
+	cmdIPPoolList := &cobra.Command{
+		Use:   "list",
+		Args:  cobra.NoArgs,
+		Short: "List IP pools shared by persistent nested clusters.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ipPools, err := getObjects("ippools.ipam.metal3.io", "--namespace", targetNamespace, "--selector", fmt.Sprintf("%s=true", sharedIPPoolLabel))
+			if err != nil {
+				return fmt.Errorf("get IPPools: %s", err)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 3, ' ', 0)
+			fmt.Fprintln(w, "NAME\tRANGES\tALLOCATED\tCLUSTERS")
+			for _, ipPool := range ipPools {
+				ranges, _, err := getIPPoolRanges(ipPool)
+				if err != nil {
+					return err
+				}
+				ipAddresses, err := getIPPoolAddresses(targetNamespace, ipPool.GetName())
+				if err != nil {
+					return err
+				}
+				clusterNames, err := getIPPoolClusters(targetNamespace, ipPool.GetName())
+				if err != nil {
+					return err
+				}
+				var rangeStrs []string
+				for _, r := range ranges {
+					rangeStrs = append(rangeStrs, r.String())
+				}
+				fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", ipPool.GetName(), strings.Join(rangeStrs, ","), len(ipAddresses), orNone(strings.Join(clusterNames, ",")))
+			}
+			return w.Flush()
+		},
+	}
+
+	cmdIPPoolDelete := &cobra.Command{
+		Use:   "delete NAME",
+		Args:  cobra.ExactArgs(1),
+		Short: "Delete an IP pool shared by persistent nested clusters.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, _, err := getSharedIPPool(targetNamespace, args[0]); err != nil {
+				return err
+			}
+			clusterNames, err := getIPPoolClusters(targetNamespace, args[0])
+			if err != nil {
+				return err
+			}
+			if len(clusterNames) > 0 {
+				return fmt.Errorf("IPPool %q is still in use by clusters %v", args[0], clusterNames)
+			}
+			ipAddresses, err := getIPPoolAddresses(targetNamespace, args[0])
+			if err != nil {
+				return err
+			}
+			if len(ipAddresses) > 0 {
+				return fmt.Errorf("IPPool %q still has %d allocated addresses", args[0], len(ipAddresses))
+			}
+
+			if err := runCommand(exec.Command("kubectl", "delete", "ippool.ipam.metal3.io", args[0], "--namespace", targetNamespace, "--wait")); err != nil {
+				return fmt.Errorf("delete IPPool: %s", err)
+			}
+			return nil
+		},
+	}
+
+	cmdIPPool.AddCommand(cmdIPPoolCreate)
+	cmdIPPool.AddCommand(cmdIPPoolList)
+	cmdIPPool.AddCommand(cmdIPPoolDelete)
+
+	cmdSnapshot := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Manage volume snapshots of persistent nested clusters.",
+	}
+
+	var volumeSnapshotClass string
+	cmdSnapshotCreate := &cobra.Command{
+		Use:   "create CLUSTER [SNAPSHOT]",
+		Args:  cobra.RangeArgs(1, 2),
+		Short: "Take a snapshot of the volumes of a persistent nested cluster.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			snapshotName := fmt.Sprintf("%s-%s", args[0], time.Now().Format("20060102150405"))
+			if len(args) > 1 {
+				snapshotName = args[1]
+			}
+			if err := createSnapshot(targetNamespace, args[0], snapshotName, volumeSnapshotClass); err != nil {
+				return err
+			}
+			fmt.Printf("Snapshot %q of cluster %q created\n", snapshotName, args[0])
+			return nil
+		},
+	}
+	cmdSnapshotCreate.PersistentFlags().StringVar(&volumeSnapshotClass, "volume-snapshot-class", volumeSnapshotClass, "The VolumeSnapshotClass to use. If unspecified, the default VolumeSnapshotClass will be used.")
+
+	cmdSnapshotList := &cobra.Command{
+		Use:   "list CLUSTER",
+		Args:  cobra.ExactArgs(1),
+		Short: "List the snapshots of a persistent nested cluster.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			snapshots, err := getSnapshots(targetNamespace, args[0])
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 3, ' ', 0)
+			fmt.Fprintln(w, "NAME\tVOLUMES\tCREATED")
+			for _, snapshot := range snapshots {
+				snapshotName := snapshot.GetLabels()[snapshotLabel]
+				volumes, err := getSnapshotVolumes(targetNamespace, args[0], snapshotName)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(w, "%s\t%d\t%s\n", snapshotName, len(volumes), snapshot.GetCreationTimestamp().Format(time.RFC3339))
+			}
+			return w.Flush()
+		},
+	}
+
+	cmdSnapshotDelete := &cobra.Command{
+		Use:   "delete CLUSTER SNAPSHOT",
+		Args:  cobra.ExactArgs(2),
+		Short: "Delete a snapshot of a persistent nested cluster.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := getSnapshotVolumes(targetNamespace, args[0], args[1]); err != nil {
+				return err
+			}
+			return deleteSnapshot(targetNamespace, args[0], args[1])
+		},
+	}
+
+	cmdSnapshotRestore := &cobra.Command{
+		Use:   "restore CLUSTER SNAPSHOT",
+		Args:  cobra.ExactArgs(2),
+		Short: "Roll a persistent nested cluster back to a snapshot.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := restoreSnapshot(targetNamespace, args[0], args[1]); err != nil {
+				return err
+			}
+			fmt.Printf("Cluster %q restored to snapshot %q\n", args[0], args[1])
+			return nil
+		},
+	}
+
+	cmdSnapshot.AddCommand(cmdSnapshotCreate)
+	cmdSnapshot.AddCommand(cmdSnapshotList)
+	cmdSnapshot.AddCommand(cmdSnapshotDelete)
+	cmdSnapshot.AddCommand(cmdSnapshotRestore)
+
+	cmdEtcd := &cobra.Command{
+		Use:   "etcd",
+		Short: "Back up and restore the etcd of nested clusters.",
+	}
+
+	var etcdBackupOutput string
+	cmdEtcdBackup := &cobra.Command{
+		Use:   "backup CLUSTER",
+		Args:  cobra.ExactArgs(1),
+		Short: "Save an etcd snapshot of a nested cluster to a local file.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outputPath := etcdBackupOutput
+			if outputPath == "" {
+				outputPath = fmt.Sprintf("%s-etcd-%s.db", args[0], time.Now().Format("20060102150405"))
+			}
+			if err := backupEtcd(targetNamespace, args[0], outputPath); err != nil {
+				return err
+			}
+			fmt.Printf("Etcd snapshot of cluster %q saved to %s\n", args[0], outputPath)
+			return nil
+		},
+	}
+	cmdEtcdBackup.PersistentFlags().StringVarP(&etcdBackupOutput, "output", "o", etcdBackupOutput, "The file to save the etcd snapshot to. Defaults to CLUSTER-etcd-TIMESTAMP.db in the current directory.")
+
+	var etcdRestoreFile string
+	cmdEtcdRestore := &cobra.Command{
+		Use:   "restore CLUSTER",
+		Args:  cobra.ExactArgs(1),
+		Short: "Restore the etcd of a nested cluster from a snapshot file.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if etcdRestoreFile == "" {
+				return fmt.Errorf("--file is required")
+			}
+			if err := restoreEtcd(targetNamespace, args[0], etcdRestoreFile); err != nil {
+				return err
+			}
+			fmt.Printf("Etcd of cluster %q restored from %s\n", args[0], etcdRestoreFile)
+			return nil
+		},
+	}
+	cmdEtcdRestore.PersistentFlags().StringVarP(&etcdRestoreFile, "file", "f", etcdRestoreFile, "The etcd snapshot file to restore from.")
+
+	var (
+		etcdBackupSchedule = "0 0 * * *"
+		etcdBackupPVC      string
+		etcdBackupRetain   = 7
+		etcdBackupDisable  = false
+	)
+	cmdEtcdSchedule := &cobra.Command{
+		Use:   "schedule CLUSTER",
+		Args:  cobra.ExactArgs(1),
+		Short: "Schedule periodic etcd backups of a nested cluster into a PVC of the host cluster.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if etcdBackupDisable {
+				return deleteEtcdBackupSchedule(targetNamespace, args[0])
+			}
+			if etcdBackupPVC == "" {
+				return fmt.Errorf("--pvc is required")
+			}
+			return scheduleEtcdBackup(targetNamespace, args[0], etcdBackupSchedule, etcdBackupPVC, etcdBackupRetain)
+		},
+	}
+	cmdEtcdSchedule.PersistentFlags().StringVar(&etcdBackupSchedule, "schedule", etcdBackupSchedule, "The cron schedule of the backups.")
+	cmdEtcdSchedule.PersistentFlags().StringVar(&etcdBackupPVC, "pvc", etcdBackupPVC, "The PVC in the cluster's namespace of the host cluster to save the etcd snapshots to.")
+	cmdEtcdSchedule.PersistentFlags().IntVar(&etcdBackupRetain, "retain", etcdBackupRetain, "The number of latest etcd snapshots to keep in the PVC.")
+	cmdEtcdSchedule.PersistentFlags().BoolVar(&etcdBackupDisable, "disable", etcdBackupDisable, "Disable scheduled backups.")
+
+	cmdEtcd.AddCommand(cmdEtcdBackup)
+	cmdEtcd.AddCommand(cmdEtcdRestore)
+	cmdEtcd.AddCommand(cmdEtcdSchedule)
+
+	cmdTemplates := &cobra.Command{
+		Use:   "templates",
+		Short: "Manage the embedded cluster templates.",
+	}
+
+	cmdTemplatesList := &cobra.Command{
+		Use:   "list",
+		Args:  cobra.NoArgs,
+		Short: "List the embedded cluster templates.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			clusterTemplates, err := listClusterTemplates()
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 3, ' ', 0)
+			fmt.Fprintln(w, "NAME\tDESCRIPTION")
+			for _, clusterTemplate := range clusterTemplates {
+				fmt.Fprintf(w, "%s\t%s\n", clusterTemplate.Name, clusterTemplate.Description)
+			}
+			return w.Flush()
+		},
+	}
+
+	cmdTemplatesShow := &cobra.Command{
+		Use:   "show TEMPLATE",
+		Args:  cobra.ExactArgs(1),
+		Short: "Print an embedded cluster template.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := readClusterTemplate(args[0])
+			if err != nil {
+				return err
+			}
+			_, err = os.Stdout.Write(data)
+			return err
+		},
+	}
+
+	var templateExportOutput string
+	cmdTemplatesExport := &cobra.Command{
+		Use:   "export TEMPLATE",
+		Args:  cobra.ExactArgs(1),
+		Short: "Export an embedded cluster template to a local file for customization.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := readClusterTemplate(args[0])
+			if err != nil {
+				return err
+			}
+			if templateExportOutput == "" {
+				templateExportOutput = fmt.Sprintf("%s.yaml", args[0])
+			}
+			if err := os.WriteFile(templateExportOutput, data, 0644); err != nil {
+				return fmt.Errorf("write cluster template: %s", err)
+			}
+			fmt.Printf("cluster template %q exported to %s\n", args[0], templateExportOutput)
+			return nil
+		},
+	}
+	cmdTemplatesExport.PersistentFlags().StringVarP(&templateExportOutput, "output", "o", templateExportOutput, "The file to export the cluster template to. Defaults to TEMPLATE.yaml in the current directory.")
+
+	cmdTemplates.AddCommand(cmdTemplatesList)
+	cmdTemplates.AddCommand(cmdTemplatesShow)
+	cmdTemplates.AddCommand(cmdTemplatesExport)
+
+	cmdAutoscale := &cobra.Command{
+		Use:   "autoscale CLUSTER",
+		Args:  cobra.ExactArgs(1),
+		Short: "Configure autoscaling of a nested cluster's workers.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkClusterNotStopped(targetNamespace, args[0]); err != nil {
+				return err
+			}
+			var machineDeploymentNames []string
+			if autoscalePool != "" {
+				machineDeploymentNames = append(machineDeploymentNames, fmt.Sprintf("%s-%s", args[0], autoscalePool))
+			} else {
+				machineDeployments, err := getMachineDeployments(targetNamespace, args[0])
+				if err != nil {
+					return err
+				}
+				for _, machineDeployment := range machineDeployments {
+					machineDeploymentNames = append(machineDeploymentNames, machineDeployment.GetName())
+				}
+			}
+
+			if autoscaleDisable {
+				return disableAutoscaler(targetNamespace, args[0], machineDeploymentNames, autoscalePool == "")
+			}
+			if autoscaleMax == 0 {
+				return fmt.Errorf("--max is required")
+			}
+			return enableAutoscaler(targetNamespace, args[0], machineDeploymentNames, autoscaleMin, autoscaleMax)
+		},
+	}
+	cmdAutoscale.PersistentFlags().IntVar(&autoscaleMin, "min", autoscaleMin, "The minimum number of worker machines.")
+	cmdAutoscale.PersistentFlags().IntVar(&autoscaleMax, "max", autoscaleMax, "The maximum number of worker machines.")
+	cmdAutoscale.PersistentFlags().StringVar(&autoscalePool, "pool", autoscalePool, "The worker pool to configure. If unspecified, all worker pools will be configured.")
+	cmdAutoscale.PersistentFlags().BoolVar(&autoscaleDisable, "disable", autoscaleDisable, "Disable autoscaling.")
 
 	var versionOutput string
 	cmdVersion := &cobra.Command{
@@ -471,8 +1845,22 @@ func main() {
 	rootCmd.PersistentFlags().StringVarP(&targetNamespace, "target-namespace", "n", targetNamespace, "The namespace to use for the nested cluster.")
 	rootCmd.AddCommand(cmdCreate)
 	rootCmd.AddCommand(cmdDelete)
+	rootCmd.AddCommand(cmdClone)
 	rootCmd.AddCommand(cmdList)
 	rootCmd.AddCommand(cmdScale)
+	rootCmd.AddCommand(cmdStop)
+	rootCmd.AddCommand(cmdStart)
+	rootCmd.AddCommand(cmdPool)
+	rootCmd.AddCommand(cmdAutoscale)
+	rootCmd.AddCommand(cmdKubeconfig)
+	rootCmd.AddCommand(cmdCredential)
+	rootCmd.AddCommand(cmdProxy)
+	rootCmd.AddCommand(cmdAddons)
+	rootCmd.AddCommand(cmdIPs)
+	rootCmd.AddCommand(cmdIPPool)
+	rootCmd.AddCommand(cmdSnapshot)
+	rootCmd.AddCommand(cmdEtcd)
+	rootCmd.AddCommand(cmdTemplates)
 	rootCmd.AddCommand(cmdVersion)
 
 	if err := rootCmd.Execute(); err != nil {
@@ -572,3 +1960,99 @@ func getCommandOutput(cmd *exec.Cmd) (string, error) {
 	}
 	return output, nil
 }
+
+func getObjects(args ...string) ([]*unstructured.Unstructured, error) {
+	cmd := exec.Command("kubectl", append(append([]string{"get"}, args...), "-o", "json")...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("run command %q: %s: %s", cmd, err, stderr.String())
+	}
+
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(out); err != nil {
+		return nil, fmt.Errorf("decode objects: %s", err)
+	}
+	if !obj.IsList() {
+		return []*unstructured.Unstructured{obj}, nil
+	}
+
+	list, err := obj.ToList()
+	if err != nil {
+		return nil, fmt.Errorf("decode object list: %s", err)
+	}
+	var objs []*unstructured.Unstructured
+	for i := range list.Items {
+		objs = append(objs, &list.Items[i])
+	}
+	return objs, nil
+}
+
+func getObject(args ...string) (*unstructured.Unstructured, error) {
+	objs, err := getObjects(args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(objs) != 1 {
+		return nil, fmt.Errorf("expect exactly 1 object but got %d", len(objs))
+	}
+	return objs[0], nil
+}
+
+func applyObjects(objs []*unstructured.Unstructured) error {
+	buf := &bytes.Buffer{}
+	if err := encodeObjects(buf, objs); err != nil {
+		return err
+	}
+
+	applyCmd := exec.Command("kubectl", "apply", "-f", "-")
+	applyCmd.Stdin = buf
+	return runCommand(applyCmd)
+}
+
+// applyObjectsServerSide applies objects without the last-applied annotation, which is limited to 256KiB.
+func applyObjectsServerSide(objs []*unstructured.Unstructured) error {
+	buf := &bytes.Buffer{}
+	if err := encodeObjects(buf, objs); err != nil {
+		return err
+	}
+
+	applyCmd := exec.Command("kubectl", "apply", "--server-side", "--force-conflicts", "--field-manager=knest", "-f", "-")
+	applyCmd.Stdin = buf
+	return runCommand(applyCmd)
+}
+
+func decodeObjects(r io.Reader) ([]*unstructured.Unstructured, error) {
+	var objs []*unstructured.Unstructured
+	decoder := k8syaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		var data json.RawMessage
+		if err := decoder.Decode(&data); err != nil {
+			if err == io.EOF {
+				return objs, nil
+			}
+			return nil, err
+		}
+		if len(data) == 0 || string(data) == "null" {
+			continue
+		}
+
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(data); err != nil {
+			return nil, err
+		}
+		objs = append(objs, obj)
+	}
+}
+
+func encodeObjects(w io.Writer, objs []*unstructured.Unstructured) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	for _, obj := range objs {
+		if err := encoder.Encode(obj.Object); err != nil {
+			return err
+		}
+	}
+	return encoder.Close()
+}
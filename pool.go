@@ -0,0 +1,374 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	clusterNameLabel = "cluster.x-k8s.io/cluster-name"
+	workerPoolLabel  = "knest.smartx.com/worker-pool"
+
+	primaryWorkerPoolName = "md-0"
+)
+
+type workerPool struct {
+	Name       string
+	Count      int
+	CPUCores   int
+	MemorySize *resource.Quantity
+	RootfsSize *resource.Quantity
+	Labels     map[string]string
+	Taints     []map[string]interface{}
+}
+
+// parseWorkerPool parses a worker pool spec in the form of "name=NAME,count=N,...".
+func parseWorkerPool(spec string) (*workerPool, error) {
+	pool := &workerPool{
+		Count:  1,
+		Labels: map[string]string{},
+	}
+	for _, field := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid worker pool field: %q", field)
+		}
+
+		switch key {
+		case "name":
+			pool.Name = value
+		case "count":
+			count, err := strconv.Atoi(value)
+			if err != nil || count < 0 {
+				return nil, fmt.Errorf("invalid worker pool count: %q", value)
+			}
+			pool.Count = count
+		case "cpu":
+			cpuCores, err := strconv.Atoi(value)
+			if err != nil || cpuCores <= 0 {
+				return nil, fmt.Errorf("invalid worker pool CPU cores: %q", value)
+			}
+			pool.CPUCores = cpuCores
+		case "memory":
+			memorySize, err := resource.ParseQuantity(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid worker pool memory size: %q", value)
+			}
+			pool.MemorySize = &memorySize
+		case "rootfs-size":
+			rootfsSize, err := resource.ParseQuantity(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid worker pool rootfs size: %q", value)
+			}
+			pool.RootfsSize = &rootfsSize
+		case "labels":
+			for _, label := range strings.Split(value, ";") {
+				labelKey, labelValue, ok := strings.Cut(label, "=")
+				if !ok || labelKey == "" {
+					return nil, fmt.Errorf("invalid worker pool label: %q", label)
+				}
+				pool.Labels[labelKey] = labelValue
+			}
+		case "taints":
+			for _, taint := range strings.Split(value, ";") {
+				keyValue, effect, ok := strings.Cut(taint, ":")
+				if !ok {
+					return nil, fmt.Errorf("invalid worker pool taint: %q", taint)
+				}
+				switch effect {
+				case "NoSchedule", "PreferNoSchedule", "NoExecute":
+				default:
+					return nil, fmt.Errorf("invalid worker pool taint effect: %q", effect)
+				}
+				taintKey, taintValue, _ := strings.Cut(keyValue, "=")
+				if taintKey == "" {
+					return nil, fmt.Errorf("invalid worker pool taint: %q", taint)
+				}
+				t := map[string]interface{}{
+					"key":    taintKey,
+					"effect": effect,
+				}
+				if taintValue != "" {
+					t["value"] = taintValue
+				}
+				pool.Taints = append(pool.Taints, t)
+			}
+		default:
+			return nil, fmt.Errorf("unknown worker pool field: %q", key)
+		}
+	}
+
+	if pool.Name == "" {
+		return nil, fmt.Errorf("worker pool name is required: %q", spec)
+	}
+	if errs := validation.IsDNS1123Label(pool.Name); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid worker pool name %q: %s", pool.Name, strings.Join(errs, ", "))
+	}
+	if pool.Name == primaryWorkerPoolName {
+		return nil, fmt.Errorf("worker pool name %q is reserved for the primary workers", pool.Name)
+	}
+	return pool, nil
+}
+
+// validateWorkerPoolName checks that the objects named after the cluster and the worker pool get valid names.
+func validateWorkerPoolName(clusterName string, poolName string) error {
+	name := fmt.Sprintf("%s-%s", clusterName, poolName)
+	if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+		return fmt.Errorf("invalid name %q of worker pool %q: %s", name, poolName, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// findPrimaryMachineDeployment returns the only MachineDeployment of a cluster which isn't a worker pool added by knest.
+func findPrimaryMachineDeployment(machineDeployments []*unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	var found []*unstructured.Unstructured
+	for _, machineDeployment := range machineDeployments {
+		if _, ok := machineDeployment.GetLabels()[workerPoolLabel]; !ok {
+			found = append(found, machineDeployment)
+		}
+	}
+	if len(found) != 1 {
+		return nil, fmt.Errorf("expect exactly 1 MachineDeployment of the primary workers but found %d", len(found))
+	}
+	return found[0], nil
+}
+
+// newWorkerPoolObjects derives the objects of a worker pool from those of an existing MachineDeployment.
+func newWorkerPoolObjects(clusterName string, pool *workerPool, baseMachineDeployment *unstructured.Unstructured, baseMachineTemplate *unstructured.Unstructured, baseConfigTemplate *unstructured.Unstructured) ([]*unstructured.Unstructured, error) {
+	name := fmt.Sprintf("%s-%s", clusterName, pool.Name)
+
+	machineTemplate := cloneObject(baseMachineTemplate, name)
+	machineTemplate.SetLabels(mergeLabels(machineTemplate.GetLabels(), clusterName, pool.Name))
+	virtualMachineSpecPath := []string{"spec", "template", "spec", "virtualMachineTemplate", "spec"}
+	if pool.CPUCores > 0 {
+		if err := unstructured.SetNestedField(machineTemplate.Object, int64(pool.CPUCores), append(virtualMachineSpecPath, "instance", "cpu", "coresPerSocket")...); err != nil {
+			return nil, fmt.Errorf("set CPU cores: %s", err)
+		}
+	}
+	if pool.MemorySize != nil {
+		if err := unstructured.SetNestedField(machineTemplate.Object, pool.MemorySize.String(), append(virtualMachineSpecPath, "instance", "memory", "size")...); err != nil {
+			return nil, fmt.Errorf("set memory size: %s", err)
+		}
+	}
+	if pool.RootfsSize != nil {
+		if err := setRootfsSize(machineTemplate, pool.RootfsSize.String()); err != nil {
+			return nil, fmt.Errorf("set rootfs size: %s", err)
+		}
+	}
+
+	configTemplate := cloneObject(baseConfigTemplate, name)
+	configTemplate.SetLabels(mergeLabels(configTemplate.GetLabels(), clusterName, pool.Name))
+	nodeRegistrationPath := []string{"spec", "template", "spec", "joinConfiguration", "nodeRegistration"}
+	if len(pool.Labels) > 0 {
+		nodeLabels, _, _ := unstructured.NestedString(configTemplate.Object, append(nodeRegistrationPath, "kubeletExtraArgs", "node-labels")...)
+		var labelKeys []string
+		for key := range pool.Labels {
+			labelKeys = append(labelKeys, key)
+		}
+		sort.Strings(labelKeys)
+		for _, key := range labelKeys {
+			if nodeLabels != "" {
+				nodeLabels += ","
+			}
+			nodeLabels += fmt.Sprintf("%s=%s", key, pool.Labels[key])
+		}
+		if err := unstructured.SetNestedField(configTemplate.Object, nodeLabels, append(nodeRegistrationPath, "kubeletExtraArgs", "node-labels")...); err != nil {
+			return nil, fmt.Errorf("set node labels: %s", err)
+		}
+	}
+	if len(pool.Taints) > 0 {
+		var taints []interface{}
+		for _, taint := range pool.Taints {
+			taints = append(taints, taint)
+		}
+		if err := unstructured.SetNestedSlice(configTemplate.Object, taints, append(nodeRegistrationPath, "taints")...); err != nil {
+			return nil, fmt.Errorf("set node taints: %s", err)
+		}
+	}
+
+	machineDeployment := cloneObject(baseMachineDeployment, name)
+	machineDeployment.SetLabels(mergeLabels(machineDeployment.GetLabels(), clusterName, pool.Name))
+	unstructured.RemoveNestedField(machineDeployment.Object, "spec", "selector")
+	unstructured.RemoveNestedField(machineDeployment.Object, "spec", "template", "metadata", "labels", "cluster.x-k8s.io/deployment-name")
+	if err := unstructured.SetNestedField(machineDeployment.Object, int64(pool.Count), "spec", "replicas"); err != nil {
+		return nil, fmt.Errorf("set replicas: %s", err)
+	}
+	if err := unstructured.SetNestedField(machineDeployment.Object, pool.Name, "spec", "template", "metadata", "labels", workerPoolLabel); err != nil {
+		return nil, fmt.Errorf("set machine labels: %s", err)
+	}
+	if err := unstructured.SetNestedField(machineDeployment.Object, name, "spec", "template", "spec", "infrastructureRef", "name"); err != nil {
+		return nil, fmt.Errorf("set infrastructure ref: %s", err)
+	}
+	if err := unstructured.SetNestedField(machineDeployment.Object, name, "spec", "template", "spec", "bootstrap", "configRef", "name"); err != nil {
+		return nil, fmt.Errorf("set bootstrap config ref: %s", err)
+	}
+
+	return []*unstructured.Unstructured{machineTemplate, configTemplate, machineDeployment}, nil
+}
+
+// cloneObject returns a copy of obj with the given name, stripped of server-populated fields.
+func cloneObject(obj *unstructured.Unstructured, name string) *unstructured.Unstructured {
+	clone := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	clone.SetAPIVersion(obj.GetAPIVersion())
+	clone.SetKind(obj.GetKind())
+	clone.SetName(name)
+	clone.SetNamespace(obj.GetNamespace())
+	clone.SetLabels(obj.GetLabels())
+	if spec, ok := obj.Object["spec"]; ok {
+		clone.Object["spec"] = runtime.DeepCopyJSONValue(spec)
+	}
+	return clone
+}
+
+func mergeLabels(labels map[string]string, clusterName string, poolName string) map[string]string {
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[clusterNameLabel] = clusterName
+	labels[workerPoolLabel] = poolName
+	return labels
+}
+
+func setRootfsSize(machineTemplate *unstructured.Unstructured, size string) error {
+	volumesPath := []string{"spec", "template", "spec", "virtualMachineTemplate", "spec", "volumes"}
+	volumes, _, err := unstructured.NestedSlice(machineTemplate.Object, volumesPath...)
+	if err != nil {
+		return err
+	}
+	for _, volume := range volumes {
+		if v, ok := volume.(map[string]interface{}); ok {
+			if _, ok := v["containerRootfs"]; ok {
+				if err := unstructured.SetNestedField(v, size, "containerRootfs", "size"); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if err := unstructured.SetNestedSlice(machineTemplate.Object, volumes, volumesPath...); err != nil {
+		return err
+	}
+
+	claimTemplatesPath := []string{"spec", "template", "spec", "volumeClaimTemplates"}
+	claimTemplates, _, err := unstructured.NestedSlice(machineTemplate.Object, claimTemplatesPath...)
+	if err != nil {
+		return err
+	}
+	for _, claimTemplate := range claimTemplates {
+		if c, ok := claimTemplate.(map[string]interface{}); ok {
+			// Blank DataVolumes are data disks rather than the rootfs.
+			if _, ok, _ := unstructured.NestedMap(c, "spec", "source", "blank"); ok {
+				continue
+			}
+			if err := unstructured.SetNestedField(c, size, "spec", "pvc", "resources", "requests", "storage"); err != nil {
+				return err
+			}
+		}
+	}
+	if len(claimTemplates) > 0 {
+		if err := unstructured.SetNestedSlice(machineTemplate.Object, claimTemplates, claimTemplatesPath...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findWorkerMachineDeployment returns the MachineDeployment to derive new worker pools from, preferring "<cluster>-md-0".
+func findWorkerMachineDeployment(objs []*unstructured.Unstructured, clusterName string) *unstructured.Unstructured {
+	var found *unstructured.Unstructured
+	for _, obj := range objs {
+		if obj.GetKind() != "MachineDeployment" {
+			continue
+		}
+		if obj.GetName() == fmt.Sprintf("%s-md-0", clusterName) {
+			return obj
+		}
+		if found == nil {
+			found = obj
+		}
+	}
+	return found
+}
+
+func findObject(objs []*unstructured.Unstructured, kind string, name string) *unstructured.Unstructured {
+	for _, obj := range objs {
+		if obj.GetKind() == kind && obj.GetName() == name {
+			return obj
+		}
+	}
+	return nil
+}
+
+// addWorkerPoolsToClusterTemplate appends the objects of the given worker pools to the generated cluster template.
+func addWorkerPoolsToClusterTemplate(clusterTemplateFilePath string, clusterName string, pools []*workerPool) error {
+	clusterTemplateFile, err := os.Open(clusterTemplateFilePath)
+	if err != nil {
+		return err
+	}
+	objs, err := decodeObjects(clusterTemplateFile)
+	clusterTemplateFile.Close()
+	if err != nil {
+		return fmt.Errorf("decode cluster template: %s", err)
+	}
+
+	baseMachineDeployment := findWorkerMachineDeployment(objs, clusterName)
+	if baseMachineDeployment == nil {
+		return fmt.Errorf("no MachineDeployment found in cluster template")
+	}
+	infrastructureRefName, _, _ := unstructured.NestedString(baseMachineDeployment.Object, "spec", "template", "spec", "infrastructureRef", "name")
+	baseMachineTemplate := findObject(objs, "VirtinkMachineTemplate", infrastructureRefName)
+	if baseMachineTemplate == nil {
+		return fmt.Errorf("VirtinkMachineTemplate %q not found in cluster template", infrastructureRefName)
+	}
+	configRefName, _, _ := unstructured.NestedString(baseMachineDeployment.Object, "spec", "template", "spec", "bootstrap", "configRef", "name")
+	baseConfigTemplate := findObject(objs, "KubeadmConfigTemplate", configRefName)
+	if baseConfigTemplate == nil {
+		return fmt.Errorf("KubeadmConfigTemplate %q not found in cluster template", configRefName)
+	}
+
+	for _, pool := range pools {
+		poolObjs, err := newWorkerPoolObjects(clusterName, pool, baseMachineDeployment, baseMachineTemplate, baseConfigTemplate)
+		if err != nil {
+			return fmt.Errorf("generate worker pool %q: %s", pool.Name, err)
+		}
+		objs = append(objs, poolObjs...)
+	}
+
+	clusterTemplateFile, err = os.Create(clusterTemplateFilePath)
+	if err != nil {
+		return err
+	}
+	defer clusterTemplateFile.Close()
+	return encodeObjects(clusterTemplateFile, objs)
+}
+
+// getWorkerPoolBaseObjects returns the worker MachineDeployment new worker pools are derived from.
+func getWorkerPoolBaseObjects(namespace string, clusterName string) (*unstructured.Unstructured, *unstructured.Unstructured, *unstructured.Unstructured, error) {
+	machineDeployments, err := getMachineDeployments(namespace, clusterName)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	baseMachineDeployment := findWorkerMachineDeployment(machineDeployments, clusterName)
+	if baseMachineDeployment == nil {
+		return nil, nil, nil, fmt.Errorf("no MachineDeployment found for cluster %q", clusterName)
+	}
+
+	infrastructureRefName, _, _ := unstructured.NestedString(baseMachineDeployment.Object, "spec", "template", "spec", "infrastructureRef", "name")
+	baseMachineTemplate, err := getObject("virtinkmachinetemplates.infrastructure.cluster.x-k8s.io", infrastructureRefName, "--namespace", namespace)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("get VirtinkMachineTemplate: %s", err)
+	}
+	configRefName, _, _ := unstructured.NestedString(baseMachineDeployment.Object, "spec", "template", "spec", "bootstrap", "configRef", "name")
+	baseConfigTemplate, err := getObject("kubeadmconfigtemplates.bootstrap.cluster.x-k8s.io", configRefName, "--namespace", namespace)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("get KubeadmConfigTemplate: %s", err)
+	}
+	return baseMachineDeployment, baseMachineTemplate, baseConfigTemplate, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestParseWorkerPool(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    *workerPool
+		wantErr bool
+	}{{
+		spec: "name=gpu",
+		want: &workerPool{Name: "gpu", Count: 1, Labels: map[string]string{}},
+	}, {
+		spec: "name=gpu,count=3,cpu=4,labels=a=b;c=,taints=k=v:NoSchedule;k2:NoExecute",
+		want: &workerPool{
+			Name:     "gpu",
+			Count:    3,
+			CPUCores: 4,
+			Labels:   map[string]string{"a": "b", "c": ""},
+			Taints: []map[string]interface{}{
+				{"key": "k", "value": "v", "effect": "NoSchedule"},
+				{"key": "k2", "effect": "NoExecute"},
+			},
+		},
+	}, {
+		spec: "name=gpu,count=0",
+		want: &workerPool{Name: "gpu", Count: 0, Labels: map[string]string{}},
+	}, {
+		spec:    "count=1",
+		wantErr: true,
+	}, {
+		spec:    "name=md-0",
+		wantErr: true,
+	}, {
+		spec:    "name=GPU",
+		wantErr: true,
+	}, {
+		spec:    "name=gpu.large",
+		wantErr: true,
+	}, {
+		spec:    "name=gpu,count=-1",
+		wantErr: true,
+	}, {
+		spec:    "name=gpu,cpu=0",
+		wantErr: true,
+	}, {
+		spec:    "name=gpu,memory=lots",
+		wantErr: true,
+	}, {
+		spec:    "name=gpu,taints=k=v:Never",
+		wantErr: true,
+	}, {
+		spec:    "name=gpu,labels==b",
+		wantErr: true,
+	}, {
+		spec:    "name=gpu,size=large",
+		wantErr: true,
+	}, {
+		spec:    "gpu",
+		wantErr: true,
+	}}
+
+	for _, tt := range tests {
+		got, err := parseWorkerPool(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseWorkerPool(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseWorkerPool(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestValidateWorkerPoolName(t *testing.T) {
+	tests := []struct {
+		clusterName string
+		poolName    string
+		wantErr     bool
+	}{{
+		clusterName: "quickstart",
+		poolName:    "gpu",
+	}, {
+		clusterName: strings.Repeat("c", 31),
+		poolName:    strings.Repeat("p", 31),
+	}, {
+		clusterName: strings.Repeat("c", 31),
+		poolName:    strings.Repeat("p", 32),
+		wantErr:     true,
+	}, {
+		clusterName: "quickstart",
+		poolName:    strings.Repeat("p", 63),
+		wantErr:     true,
+	}}
+
+	for _, tt := range tests {
+		if err := validateWorkerPoolName(tt.clusterName, tt.poolName); (err != nil) != tt.wantErr {
+			t.Errorf("validateWorkerPoolName(%q, %q) error = %v, wantErr %v", tt.clusterName, tt.poolName, err, tt.wantErr)
+		}
+	}
+}
+
+func TestFindPrimaryMachineDeployment(t *testing.T) {
+	newMachineDeployment := func(name string, poolName string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+		obj.SetName(name)
+		labels := map[string]string{clusterNameLabel: "quickstart"}
+		if poolName != "" {
+			labels[workerPoolLabel] = poolName
+		}
+		obj.SetLabels(labels)
+		return obj
+	}
+
+	tests := []struct {
+		name               string
+		machineDeployments []*unstructured.Unstructured
+		want               string
+		wantErr            bool
+	}{{
+		name:               "only primary",
+		machineDeployments: []*unstructured.Unstructured{newMachineDeployment("quickstart-md-0", "")},
+		want:               "quickstart-md-0",
+	}, {
+		name: "with added pools",
+		machineDeployments: []*unstructured.Unstructured{
+			newMachineDeployment("quickstart-large", "large"),
+			newMachineDeployment("quickstart-workers", ""),
+			newMachineDeployment("quickstart-small", "small"),
+		},
+		want: "quickstart-workers",
+	}, {
+		name:               "only added pools",
+		machineDeployments: []*unstructured.Unstructured{newMachineDeployment("quickstart-large", "large")},
+		wantErr:            true,
+	}, {
+		name: "multiple primary",
+		machineDeployments: []*unstructured.Unstructured{
+			newMachineDeployment("quickstart-md-0", ""),
+			newMachineDeployment("quickstart-md-1", ""),
+		},
+		wantErr: true,
+	}}
+
+	for _, tt := range tests {
+		got, err := findPrimaryMachineDeployment(tt.machineDeployments)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: findPrimaryMachineDeployment() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got.GetName() != tt.want {
+			t.Errorf("%s: findPrimaryMachineDeployment() = %q, want %q", tt.name, got.GetName(), tt.want)
+		}
+	}
+}
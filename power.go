@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	stoppedAnnotation      = "knest.smartx.com/stopped"
+	runPolicyAnnotation    = "knest.smartx.com/run-policy"
+	maxUnhealthyAnnotation = "knest.smartx.com/max-unhealthy"
+)
+
+// isClusterEphemeral tells whether any machine of a cluster runs without persistent volumes.
+func isClusterEphemeral(machineVMs []*machineVM) bool {
+	for _, m := range machineVMs {
+		if m.VM != nil && len(getVMDataVolumes(m.VM)) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkClusterNotStopped refuses to change a stopped cluster, whose machines can't be reconciled until it's started.
+func checkClusterNotStopped(namespace string, clusterName string) error {
+	cluster, err := getObject("clusters.cluster.x-k8s.io", clusterName, "--namespace", namespace)
+	if err != nil {
+		return fmt.Errorf("get cluster: %s", err)
+	}
+	if cluster.GetAnnotations()[stoppedAnnotation] == "true" {
+		return fmt.Errorf("cluster %q is stopped, start it with 'knest start' first", clusterName)
+	}
+	return nil
+}
+
+// stopCluster pauses a cluster, disables its MachineHealthChecks and halts its VMs.
+func stopCluster(namespace string, clusterName string, force bool) error {
+	cluster, err := getObject("clusters.cluster.x-k8s.io", clusterName, "--namespace", namespace)
+	if err != nil {
+		return fmt.Errorf("get cluster: %s", err)
+	}
+	machineVMs, err := getClusterMachineVMs(namespace, clusterName)
+	if err != nil {
+		return err
+	}
+	if cluster.GetAnnotations()[stoppedAnnotation] != "true" && isClusterEphemeral(machineVMs) {
+		fmt.Printf("Warning: cluster %q has machines without persistent volumes, whose state will be lost once stopped\n", clusterName)
+		if !force {
+			return fmt.Errorf("use --force to stop cluster %q anyway", clusterName)
+		}
+	}
+
+	if err := setClusterPaused(namespace, clusterName, true); err != nil {
+		return err
+	}
+	if err := runCommand(exec.Command("kubectl", "annotate", "clusters.cluster.x-k8s.io", clusterName, "--namespace", namespace, "--overwrite",
+		fmt.Sprintf("%s=true", stoppedAnnotation))); err != nil {
+		return fmt.Errorf("annotate cluster: %s", err)
+	}
+	if err := disableMachineHealthChecks(namespace, clusterName); err != nil {
+		return err
+	}
+
+	for _, m := range machineVMs {
+		if m.VM == nil {
+			continue
+		}
+		if _, ok := m.VM.GetAnnotations()[runPolicyAnnotation]; ok {
+			if err := setVMRunPolicy(namespace, m.VM.GetName(), "Halted"); err != nil {
+				return err
+			}
+			continue
+		}
+		runPolicy, _, _ := unstructured.NestedString(m.VM.Object, "spec", "runPolicy")
+		if runPolicy == "" {
+			runPolicy = "Once"
+		}
+		if err := runCommand(exec.Command("kubectl", "annotate", "virtualmachines.virt.virtink.smartx.com", m.VM.GetName(), "--namespace", namespace, "--overwrite",
+			fmt.Sprintf("%s=%s", runPolicyAnnotation, runPolicy))); err != nil {
+			return fmt.Errorf("annotate VM %q: %s", m.VM.GetName(), err)
+		}
+		if runPolicy == "Halted" {
+			continue
+		}
+		if err := setVMRunPolicy(namespace, m.VM.GetName(), "Halted"); err != nil {
+			return err
+		}
+	}
+	for _, m := range machineVMs {
+		if m.VM == nil {
+			continue
+		}
+		if err := waitForVMStopped(namespace, m.VM.GetName()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startCluster reverses stopCluster, keeping the cluster stopped if its nodes don't become ready.
+func startCluster(namespace string, clusterName string) error {
+	cluster, err := getObject("clusters.cluster.x-k8s.io", clusterName, "--namespace", namespace)
+	if err != nil {
+		return fmt.Errorf("get cluster: %s", err)
+	}
+	if cluster.GetAnnotations()[stoppedAnnotation] != "true" {
+		return fmt.Errorf("cluster %q is not stopped", clusterName)
+	}
+	machineVMs, err := getClusterMachineVMs(namespace, clusterName)
+	if err != nil {
+		return err
+	}
+	if isClusterEphemeral(machineVMs) {
+		fmt.Printf("Warning: cluster %q has machines without persistent volumes, which start from scratch and may not become ready\n", clusterName)
+	}
+
+	// The run policy annotations are kept until the cluster is started, so that starting it again restores the VMs.
+	for _, m := range machineVMs {
+		if m.VM == nil {
+			continue
+		}
+		runPolicy := m.VM.GetAnnotations()[runPolicyAnnotation]
+		if runPolicy == "" {
+			continue
+		}
+		if err := setVMRunPolicy(namespace, m.VM.GetName(), runPolicy); err != nil {
+			return err
+		}
+	}
+
+	if err := setClusterPaused(namespace, clusterName, false); err != nil {
+		return err
+	}
+	if err := waitForClusterStarted(namespace, clusterName); err != nil {
+		if pauseErr := setClusterPaused(namespace, clusterName, true); pauseErr != nil {
+			return fmt.Errorf("%s, and pause cluster: %s", err, pauseErr)
+		}
+		return fmt.Errorf("%s, the cluster is kept stopped, run 'knest start' again to retry or 'knest stop' to halt its machines", err)
+	}
+
+	if err := enableMachineHealthChecks(namespace, clusterName); err != nil {
+		return err
+	}
+	for _, m := range machineVMs {
+		if m.VM == nil {
+			continue
+		}
+		if _, ok := m.VM.GetAnnotations()[runPolicyAnnotation]; !ok {
+			continue
+		}
+		if err := runCommand(exec.Command("kubectl", "annotate", "virtualmachines.virt.virtink.smartx.com", m.VM.GetName(), "--namespace", namespace,
+			fmt.Sprintf("%s-", runPolicyAnnotation))); err != nil {
+			return fmt.Errorf("annotate VM %q: %s", m.VM.GetName(), err)
+		}
+	}
+	if err := runCommand(exec.Command("kubectl", "annotate", "clusters.cluster.x-k8s.io", clusterName, "--namespace", namespace,
+		fmt.Sprintf("%s-", stoppedAnnotation))); err != nil {
+		return fmt.Errorf("annotate cluster: %s", err)
+	}
+	return nil
+}
+
+func waitForClusterStarted(namespace string, clusterName string) error {
+	kubeconfig, err := buildKubeconfig(namespace, clusterName)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Waiting for nodes to be ready...")
+	return waitForNestedNodesReady(kubeconfig, 15*time.Minute)
+}
+
+// disableMachineHealthChecks sets maxUnhealthy to 0%, keeping the original in an annotation.
+func disableMachineHealthChecks(namespace string, clusterName string) error {
+	machineHealthChecks, err := getClusterOwnedObjects("machinehealthchecks.cluster.x-k8s.io", namespace, clusterName)
+	if err != nil {
+		return fmt.Errorf("get MachineHealthChecks: %s", err)
+	}
+	for _, machineHealthCheck := range machineHealthChecks {
+		maxUnhealthy, ok := savedMaxUnhealthy(machineHealthCheck)
+		if !ok {
+			continue
+		}
+		if err := patchMachineHealthCheck(namespace, machineHealthCheck.GetName(), maxUnhealthy, "0%"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func enableMachineHealthChecks(namespace string, clusterName string) error {
+	machineHealthChecks, err := getClusterOwnedObjects("machinehealthchecks.cluster.x-k8s.io", namespace, clusterName)
+	if err != nil {
+		return fmt.Errorf("get MachineHealthChecks: %s", err)
+	}
+	for _, machineHealthCheck := range machineHealthChecks {
+		maxUnhealthy, ok := restoredMaxUnhealthy(machineHealthCheck)
+		if !ok {
+			continue
+		}
+		if err := patchMachineHealthCheck(namespace, machineHealthCheck.GetName(), nil, maxUnhealthy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// savedMaxUnhealthy returns maxUnhealthy of a MachineHealthCheck to keep in the annotation, or false if it is already disabled.
+func savedMaxUnhealthy(machineHealthCheck *unstructured.Unstructured) (string, bool) {
+	if _, ok := machineHealthCheck.GetAnnotations()[maxUnhealthyAnnotation]; ok {
+		return "", false
+	}
+	value, ok, _ := unstructured.NestedFieldNoCopy(machineHealthCheck.Object, "spec", "maxUnhealthy")
+	if !ok || value == nil {
+		return "", true
+	}
+	return fmt.Sprint(value), true
+}
+
+// restoredMaxUnhealthy returns maxUnhealthy kept in the annotation of a MachineHealthCheck, where nil unsets it, or false if it isn't disabled.
+func restoredMaxUnhealthy(machineHealthCheck *unstructured.Unstructured) (interface{}, bool) {
+	maxUnhealthy, ok := machineHealthCheck.GetAnnotations()[maxUnhealthyAnnotation]
+	if !ok {
+		return nil, false
+	}
+	if count, err := strconv.Atoi(maxUnhealthy); err == nil {
+		return count, true
+	}
+	if maxUnhealthy != "" {
+		return maxUnhealthy, true
+	}
+	return nil, true
+}
+
+// patchMachineHealthCheck sets the maxUnhealthy annotation and field of a MachineHealthCheck, where nil removes them.
+func patchMachineHealthCheck(namespace string, name string, annotation interface{}, maxUnhealthy interface{}) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				maxUnhealthyAnnotation: annotation,
+			},
+		},
+		"spec": map[string]interface{}{
+			"maxUnhealthy": maxUnhealthy,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if err := runCommand(exec.Command("kubectl", "patch", "machinehealthchecks.cluster.x-k8s.io", name, "--namespace", namespace, "--type", "merge", "--patch", string(patch))); err != nil {
+		return fmt.Errorf("patch MachineHealthCheck %q: %s", name, err)
+	}
+	return nil
+}
+
+// waitForNestedNodesReady waits for all nodes of a nested cluster to be ready.
+func waitForNestedNodesReady(kubeconfig *clientcmdapi.Config, timeout time.Duration) error {
+	return withKubeconfigFile(kubeconfig, func(kubeconfigFilePath string) error {
+		deadline := time.Now().Add(timeout)
+		for time.Now().Before(deadline) {
+			if err := exec.Command("kubectl", "--kubeconfig", kubeconfigFilePath, "wait", "nodes", "--all", "--for", "condition=Ready", "--timeout", "30s").Run(); err == nil {
+				return nil
+			}
+			time.Sleep(5 * time.Second)
+		}
+		return fmt.Errorf("timed out waiting for nodes to be ready")
+	})
+}
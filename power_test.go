@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func newTestMachineHealthCheck(maxUnhealthy interface{}) *unstructured.Unstructured {
+	machineHealthCheck := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cluster.x-k8s.io/v1beta1",
+		"kind":       "MachineHealthCheck",
+		"metadata":   map[string]interface{}{"name": "foo-md-0", "namespace": "default"},
+		"spec":       map[string]interface{}{"clusterName": "foo"},
+	}}
+	if maxUnhealthy != nil {
+		unstructured.SetNestedField(machineHealthCheck.Object, maxUnhealthy, "spec", "maxUnhealthy")
+	}
+	return machineHealthCheck
+}
+
+// applyMaxUnhealthyPatch applies what patchMachineHealthCheck would merge into the MachineHealthCheck.
+func applyMaxUnhealthyPatch(machineHealthCheck *unstructured.Unstructured, annotation interface{}, maxUnhealthy interface{}) {
+	annotations := machineHealthCheck.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	if annotation == nil {
+		delete(annotations, maxUnhealthyAnnotation)
+	} else {
+		annotations[maxUnhealthyAnnotation] = annotation.(string)
+	}
+	machineHealthCheck.SetAnnotations(annotations)
+	if maxUnhealthy == nil {
+		unstructured.RemoveNestedField(machineHealthCheck.Object, "spec", "maxUnhealthy")
+	} else {
+		machineHealthCheck.Object["spec"].(map[string]interface{})["maxUnhealthy"] = maxUnhealthy
+	}
+}
+
+func TestMaxUnhealthyRoundTrip(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxUnhealthy   interface{}
+		wantAnnotation string
+	}{{
+		name:           "unset",
+		wantAnnotation: "",
+	}, {
+		name:           "percentage",
+		maxUnhealthy:   "40%",
+		wantAnnotation: "40%",
+	}, {
+		name:           "count",
+		maxUnhealthy:   int64(2),
+		wantAnnotation: "2",
+	}, {
+		name:           "already disabled",
+		maxUnhealthy:   "0%",
+		wantAnnotation: "0%",
+	}}
+
+	for _, tt := range tests {
+		machineHealthCheck := newTestMachineHealthCheck(tt.maxUnhealthy)
+		if _, ok := restoredMaxUnhealthy(machineHealthCheck); ok {
+			t.Errorf("%s: restoredMaxUnhealthy() of an enabled MachineHealthCheck = true, want false", tt.name)
+		}
+
+		annotation, ok := savedMaxUnhealthy(machineHealthCheck)
+		if !ok || annotation != tt.wantAnnotation {
+			t.Errorf("%s: savedMaxUnhealthy() = %q, %t, want %q, true", tt.name, annotation, ok, tt.wantAnnotation)
+		}
+		applyMaxUnhealthyPatch(machineHealthCheck, annotation, "0%")
+		if _, ok := savedMaxUnhealthy(machineHealthCheck); ok {
+			t.Errorf("%s: savedMaxUnhealthy() of a disabled MachineHealthCheck = true, want false", tt.name)
+		}
+
+		maxUnhealthy, ok := restoredMaxUnhealthy(machineHealthCheck)
+		if !ok {
+			t.Errorf("%s: restoredMaxUnhealthy() of a disabled MachineHealthCheck = false, want true", tt.name)
+		}
+		applyMaxUnhealthyPatch(machineHealthCheck, nil, maxUnhealthy)
+		got, found, _ := unstructured.NestedFieldNoCopy(machineHealthCheck.Object, "spec", "maxUnhealthy")
+		if found != (tt.maxUnhealthy != nil) || fmt.Sprint(got) != fmt.Sprint(tt.maxUnhealthy) {
+			t.Errorf("%s: restored maxUnhealthy = %v (found %t), want %v", tt.name, got, found, tt.maxUnhealthy)
+		}
+		if _, ok := machineHealthCheck.GetAnnotations()[maxUnhealthyAnnotation]; ok {
+			t.Errorf("%s: annotation %s is kept after restoring", tt.name, maxUnhealthyAnnotation)
+		}
+	}
+}
+
+func TestRestoredMaxUnhealthy(t *testing.T) {
+	tests := []struct {
+		annotation string
+		want       interface{}
+	}{{
+		annotation: "",
+		want:       nil,
+	}, {
+		annotation: "3",
+		want:       3,
+	}, {
+		annotation: "100%",
+		want:       "100%",
+	}}
+
+	for _, tt := range tests {
+		machineHealthCheck := newTestMachineHealthCheck("0%")
+		machineHealthCheck.SetAnnotations(map[string]string{maxUnhealthyAnnotation: tt.annotation})
+		got, ok := restoredMaxUnhealthy(machineHealthCheck)
+		if !ok || got != tt.want {
+			t.Errorf("restoredMaxUnhealthy() of annotation %q = %#v, %t, want %#v, true", tt.annotation, got, ok, tt.want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// buildProxyKubeconfig builds the kubeconfig of a nested cluster accessed through a local port-forward.
+func buildProxyKubeconfig(namespace string, clusterName string, localAddress string, localPort int) (*clientcmdapi.Config, int64, error) {
+	kubeconfig, err := getAdminKubeconfig(namespace, clusterName)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	service, err := getObject("service", clusterName, "--namespace", namespace)
+	if err != nil {
+		return nil, 0, fmt.Errorf("get control plane service: %s", err)
+	}
+	servicePort, err := useProxyServer(kubeconfig, service, localAddress, localPort)
+	if err != nil {
+		return nil, 0, err
+	}
+	return kubeconfig, servicePort, nil
+}
+
+// useProxyServer points all clusters of the kubeconfig to the local port forwarded to the control plane service, returning the service port.
+func useProxyServer(kubeconfig *clientcmdapi.Config, service *unstructured.Unstructured, localAddress string, localPort int) (int64, error) {
+	clusterIP, _, _ := unstructured.NestedString(service.Object, "spec", "clusterIP")
+	ports, _, _ := unstructured.NestedSlice(service.Object, "spec", "ports")
+	if len(ports) == 0 {
+		return 0, fmt.Errorf("no port found in control plane service")
+	}
+	servicePort, _, _ := unstructured.NestedInt64(ports[0].(map[string]interface{}), "port")
+
+	for _, cluster := range kubeconfig.Clusters {
+		cluster.Server = fmt.Sprintf("https://%s", net.JoinHostPort(localAddress, fmt.Sprint(localPort)))
+		cluster.TLSServerName = clusterIP
+	}
+	return servicePort, nil
+}
+
+func getFreePort(address string) (int, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(address, "0"))
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
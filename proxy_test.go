@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func TestUseProxyServer(t *testing.T) {
+	newService := func(ports ...interface{}) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"spec": map[string]interface{}{
+				"clusterIP": "10.96.0.10",
+				"ports":     ports,
+			},
+		}}
+	}
+
+	tests := []struct {
+		name            string
+		service         *unstructured.Unstructured
+		localAddress    string
+		wantServer      string
+		wantServicePort int64
+		wantErr         bool
+	}{{
+		name:            "IPv4",
+		service:         newService(map[string]interface{}{"port": int64(6443)}),
+		localAddress:    "127.0.0.1",
+		wantServer:      "https://127.0.0.1:16443",
+		wantServicePort: 6443,
+	}, {
+		name:            "IPv6",
+		service:         newService(map[string]interface{}{"port": int64(443)}, map[string]interface{}{"port": int64(8443)}),
+		localAddress:    "::1",
+		wantServer:      "https://[::1]:16443",
+		wantServicePort: 443,
+	}, {
+		name:         "no port",
+		service:      newService(),
+		localAddress: "127.0.0.1",
+		wantErr:      true,
+	}}
+
+	for _, tt := range tests {
+		kubeconfig := clientcmdapi.NewConfig()
+		kubeconfig.Clusters["foo"] = &clientcmdapi.Cluster{Server: "https://10.96.0.10:6443"}
+		servicePort, err := useProxyServer(kubeconfig, tt.service, tt.localAddress, 16443)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: useProxyServer() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if servicePort != tt.wantServicePort {
+			t.Errorf("%s: useProxyServer() = %d, want %d", tt.name, servicePort, tt.wantServicePort)
+		}
+		if cluster := kubeconfig.Clusters["foo"]; cluster.Server != tt.wantServer || cluster.TLSServerName != "10.96.0.10" {
+			t.Errorf("%s: cluster = %s with server name %q, want %s with server name %q", tt.name, cluster.Server, cluster.TLSServerName, tt.wantServer, "10.96.0.10")
+		}
+	}
+}
+
+func TestGetFreePort(t *testing.T) {
+	port, err := getFreePort("127.0.0.1")
+	if err != nil {
+		t.Fatalf("getFreePort() error = %v", err)
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("port %d returned by getFreePort() is not free: %v", port, err)
+	}
+	listener.Close()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	controlPlaneHostAnnotation = "knest.smartx.com/control-plane-host"
+	controlPlanePortAnnotation = "knest.smartx.com/control-plane-port"
+)
+
+type controlPlaneRoute struct {
+	Type         string
+	BaseDomain   string
+	Port         int
+	IngressClass string
+	Gateway      string
+}
+
+func (r *controlPlaneRoute) Host(namespace string, clusterName string) string {
+	return fmt.Sprintf("%s.%s.%s", clusterName, namespace, r.BaseDomain)
+}
+
+// createControlPlaneRoute publishes the nested API server by an Ingress or TLSRoute owned by the Cluster.
+func createControlPlaneRoute(namespace string, clusterName string, route *controlPlaneRoute) error {
+	cluster, err := getObject("clusters.cluster.x-k8s.io", clusterName, "--namespace", namespace)
+	if err != nil {
+		return fmt.Errorf("get cluster: %s", err)
+	}
+	service, err := getObject("service", clusterName, "--namespace", namespace)
+	if err != nil {
+		return fmt.Errorf("get control plane service: %s", err)
+	}
+	ports, _, _ := unstructured.NestedSlice(service.Object, "spec", "ports")
+	if len(ports) == 0 {
+		return fmt.Errorf("no port found in control plane service")
+	}
+	servicePort, _, _ := unstructured.NestedInt64(ports[0].(map[string]interface{}), "port")
+
+	routeDataBuf, err := renderControlPlaneRoute(namespace, clusterName, string(cluster.GetUID()), servicePort, route)
+	if err != nil {
+		return err
+	}
+
+	applyCmd := exec.Command("kubectl", "apply", "-f", "-")
+	applyCmd.Stdin = routeDataBuf
+	return runCommand(applyCmd)
+}
+
+func renderControlPlaneRoute(namespace string, clusterName string, clusterUID string, servicePort int64, route *controlPlaneRoute) (*bytes.Buffer, error) {
+	routeTemplateData := struct {
+		Type             string
+		Name             string
+		Namespace        string
+		ClusterUID       string
+		Host             string
+		ServicePort      int64
+		IngressClass     string
+		GatewayName      string
+		GatewayNamespace string
+	}{
+		Type:         route.Type,
+		Name:         clusterName,
+		Namespace:    namespace,
+		ClusterUID:   clusterUID,
+		Host:         route.Host(namespace, clusterName),
+		ServicePort:  servicePort,
+		IngressClass: route.IngressClass,
+	}
+	if route.Type == "gateway" {
+		if gatewayNamespace, gatewayName, ok := strings.Cut(route.Gateway, "/"); ok {
+			routeTemplateData.GatewayNamespace = gatewayNamespace
+			routeTemplateData.GatewayName = gatewayName
+		} else {
+			routeTemplateData.GatewayName = route.Gateway
+		}
+	}
+
+	routeDataBuf := &bytes.Buffer{}
+	if err := template.Must(template.New("control-plane-route.yaml").ParseFS(templatesFS, "templates/control-plane-route.yaml")).Execute(routeDataBuf, routeTemplateData); err != nil {
+		return nil, err
+	}
+	return routeDataBuf, nil
+}
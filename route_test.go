@@ -0,0 +1,103 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestRenderControlPlaneRoute(t *testing.T) {
+	ingressRules := []interface{}{map[string]interface{}{
+		"host": "foo.default.knest.example.com",
+		"http": map[string]interface{}{
+			"paths": []interface{}{map[string]interface{}{
+				"path":     "/",
+				"pathType": "Prefix",
+				"backend": map[string]interface{}{
+					"service": map[string]interface{}{
+						"name": "foo",
+						"port": map[string]interface{}{"number": int64(6443)},
+					},
+				},
+			}},
+		},
+	}}
+	ingressAnnotations := map[string]string{
+		"nginx.ingress.kubernetes.io/ssl-passthrough":  "true",
+		"nginx.ingress.kubernetes.io/backend-protocol": "HTTPS",
+	}
+	tlsRouteSpec := func(parentRef map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"parentRefs": []interface{}{parentRef},
+			"hostnames":  []interface{}{"foo.default.knest.example.com"},
+			"rules": []interface{}{map[string]interface{}{
+				"backendRefs": []interface{}{map[string]interface{}{"name": "foo", "port": int64(6443)}},
+			}},
+		}
+	}
+
+	tests := []struct {
+		name            string
+		route           *controlPlaneRoute
+		wantKind        string
+		wantAnnotations map[string]string
+		wantSpec        map[string]interface{}
+	}{{
+		name:            "ingress",
+		route:           &controlPlaneRoute{Type: "ingress", BaseDomain: "knest.example.com", Port: 443},
+		wantKind:        "Ingress",
+		wantAnnotations: ingressAnnotations,
+		wantSpec:        map[string]interface{}{"rules": ingressRules},
+	}, {
+		name:            "ingress with class",
+		route:           &controlPlaneRoute{Type: "ingress", BaseDomain: "knest.example.com", IngressClass: "nginx"},
+		wantKind:        "Ingress",
+		wantAnnotations: ingressAnnotations,
+		wantSpec:        map[string]interface{}{"ingressClassName": "nginx", "rules": ingressRules},
+	}, {
+		name:     "gateway in the same namespace",
+		route:    &controlPlaneRoute{Type: "gateway", BaseDomain: "knest.example.com", Gateway: "tls"},
+		wantKind: "TLSRoute",
+		wantSpec: tlsRouteSpec(map[string]interface{}{"name": "tls"}),
+	}, {
+		name:     "gateway in another namespace",
+		route:    &controlPlaneRoute{Type: "gateway", BaseDomain: "knest.example.com", Gateway: "gateways/tls"},
+		wantKind: "TLSRoute",
+		wantSpec: tlsRouteSpec(map[string]interface{}{"name": "tls", "namespace": "gateways"}),
+	}}
+
+	for _, tt := range tests {
+		buf, err := renderControlPlaneRoute("default", "foo", "5f2c6a3e-8d4b-4c1a-9e7f-0b1d2c3e4f5a", 6443, tt.route)
+		if err != nil {
+			t.Fatalf("%s: renderControlPlaneRoute() error = %v", tt.name, err)
+		}
+		objs, err := decodeObjects(buf)
+		if err != nil || len(objs) != 1 {
+			t.Fatalf("%s: renderControlPlaneRoute() = %s, want a single object: %v", tt.name, buf, err)
+		}
+		obj := objs[0]
+		if obj.GetKind() != tt.wantKind || obj.GetName() != "foo-apiserver" || obj.GetNamespace() != "default" {
+			t.Errorf("%s: rendered %s %s/%s, want %s default/foo-apiserver", tt.name, obj.GetKind(), obj.GetNamespace(), obj.GetName(), tt.wantKind)
+		}
+		if obj.GetLabels()[clusterNameLabel] != "foo" {
+			t.Errorf("%s: labels = %v, want the cluster name label", tt.name, obj.GetLabels())
+		}
+		if ownerRefs := obj.GetOwnerReferences(); len(ownerRefs) != 1 || ownerRefs[0].Kind != "Cluster" || ownerRefs[0].Name != "foo" || ownerRefs[0].UID != "5f2c6a3e-8d4b-4c1a-9e7f-0b1d2c3e4f5a" {
+			t.Errorf("%s: owner references = %v, want the cluster", tt.name, ownerRefs)
+		}
+		if annotations := obj.GetAnnotations(); !reflect.DeepEqual(annotations, tt.wantAnnotations) {
+			t.Errorf("%s: annotations = %v, want %v", tt.name, annotations, tt.wantAnnotations)
+		}
+		if spec, _, _ := unstructured.NestedMap(obj.Object, "spec"); !reflect.DeepEqual(spec, tt.wantSpec) {
+			t.Errorf("%s: spec = %v, want %v", tt.name, spec, tt.wantSpec)
+		}
+	}
+}
+
+func TestControlPlaneRouteHost(t *testing.T) {
+	route := &controlPlaneRoute{BaseDomain: "knest.example.com"}
+	if got, want := route.Host("default", "foo"), "foo.default.knest.example.com"; got != want {
+		t.Errorf("Host() = %q, want %q", got, want)
+	}
+}
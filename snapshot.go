@@ -0,0 +1,391 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	snapshotLabel        = "knest.smartx.com/snapshot"
+	snapshotClusterLabel = "knest.smartx.com/snapshot-cluster"
+
+	snapshotMachineAnnotation = "knest.smartx.com/machine"
+
+	dataVolumeTimeout     = 30 * time.Minute
+	volumeSnapshotTimeout = 30 * time.Minute
+)
+
+// snapshotVolume records the Machine a snapshotted volume belongs to.
+type snapshotVolume struct {
+	Machine        string `json:"machine"`
+	VirtualMachine string `json:"virtualMachine"`
+	DataVolume     string `json:"dataVolume"`
+	VolumeSnapshot string `json:"volumeSnapshot"`
+}
+
+func snapshotConfigMapName(clusterName string, snapshotName string) string {
+	return fmt.Sprintf("knest-snapshot-%s-%s", clusterName, snapshotName)
+}
+
+// newSnapshotConfigMap returns the ConfigMap recording the volumes of a snapshot.
+func newSnapshotConfigMap(namespace string, clusterName string, snapshotName string, volumes []*snapshotVolume) (*unstructured.Unstructured, error) {
+	volumesData, err := json.Marshal(volumes)
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":      snapshotConfigMapName(clusterName, snapshotName),
+				"namespace": namespace,
+				"labels": map[string]interface{}{
+					snapshotLabel:        snapshotName,
+					snapshotClusterLabel: clusterName,
+				},
+			},
+			"data": map[string]interface{}{
+				"volumes": string(volumesData),
+			},
+		},
+	}, nil
+}
+
+// decodeSnapshotConfigMap returns the volumes recorded by the ConfigMap of a snapshot of the cluster.
+func decodeSnapshotConfigMap(configMap *unstructured.Unstructured, clusterName string, snapshotName string) ([]*snapshotVolume, error) {
+	labels := configMap.GetLabels()
+	if labels[snapshotClusterLabel] != clusterName || labels[snapshotLabel] != snapshotName {
+		return nil, fmt.Errorf("snapshot %q doesn't belong to cluster %q", snapshotName, clusterName)
+	}
+	volumesData, _, _ := unstructured.NestedString(configMap.Object, "data", "volumes")
+	var volumes []*snapshotVolume
+	if err := json.Unmarshal([]byte(volumesData), &volumes); err != nil {
+		return nil, fmt.Errorf("decode snapshot %q: %s", snapshotName, err)
+	}
+	return volumes, nil
+}
+
+// createSnapshot takes a VolumeSnapshot of every DataVolume of the cluster's machines.
+func createSnapshot(namespace string, clusterName string, snapshotName string, volumeSnapshotClass string) error {
+	machineVMs, err := getClusterMachineVMs(namespace, clusterName)
+	if err != nil {
+		return err
+	}
+
+	var volumes []*snapshotVolume
+	for _, m := range machineVMs {
+		if m.VM == nil {
+			return fmt.Errorf("VM of VirtinkMachine %q not found", m.VirtinkMachine.GetName())
+		}
+		for _, dataVolume := range getVMDataVolumes(m.VM) {
+			volumes = append(volumes, &snapshotVolume{
+				Machine:        m.Machine,
+				VirtualMachine: m.VM.GetName(),
+				DataVolume:     dataVolume,
+				VolumeSnapshot: fmt.Sprintf("%s-%s", snapshotName, dataVolume),
+			})
+		}
+	}
+	if len(volumes) == 0 {
+		return fmt.Errorf("no persistent volume found in cluster %q", clusterName)
+	}
+
+	paused, err := isClusterPaused(namespace, clusterName)
+	if err != nil {
+		return err
+	}
+	if !paused {
+		if err := setClusterPaused(namespace, clusterName, true); err != nil {
+			return err
+		}
+		defer setClusterPaused(namespace, clusterName, false)
+	}
+
+	configMap, err := newSnapshotConfigMap(namespace, clusterName, snapshotName, volumes)
+	if err != nil {
+		return err
+	}
+	objs := []*unstructured.Unstructured{configMap}
+	for _, volume := range volumes {
+		spec := map[string]interface{}{
+			"source": map[string]interface{}{
+				"persistentVolumeClaimName": volume.DataVolume,
+			},
+		}
+		if volumeSnapshotClass != "" {
+			spec["volumeSnapshotClassName"] = volumeSnapshotClass
+		}
+		objs = append(objs, &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": "snapshot.storage.k8s.io/v1",
+				"kind":       "VolumeSnapshot",
+				"metadata": map[string]interface{}{
+					"name":      volume.VolumeSnapshot,
+					"namespace": namespace,
+					"labels": map[string]interface{}{
+						snapshotLabel:        snapshotName,
+						snapshotClusterLabel: clusterName,
+					},
+					"annotations": map[string]interface{}{
+						snapshotMachineAnnotation: volume.Machine,
+					},
+				},
+				"spec": spec,
+			},
+		})
+	}
+
+	fmt.Printf("Taking snapshot %q of %d volumes\n", snapshotName, len(volumes))
+	if err := applyObjects(objs); err != nil {
+		return fmt.Errorf("create VolumeSnapshots: %s", err)
+	}
+
+	fmt.Println("Waiting for VolumeSnapshots to be ready...")
+	for _, volume := range volumes {
+		if err := waitForVolumeSnapshotReady(namespace, volume.VolumeSnapshot, volumeSnapshotTimeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func waitForVolumeSnapshotReady(namespace string, name string, timeout time.Duration) error {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(5 * time.Second) {
+		volumeSnapshot, err := getObject("volumesnapshots.snapshot.storage.k8s.io", name, "--namespace", namespace)
+		if err != nil {
+			return fmt.Errorf("get VolumeSnapshot: %s", err)
+		}
+		if readyToUse, _, _ := unstructured.NestedBool(volumeSnapshot.Object, "status", "readyToUse"); readyToUse {
+			return nil
+		}
+		if message, _, _ := unstructured.NestedString(volumeSnapshot.Object, "status", "error", "message"); message != "" {
+			return fmt.Errorf("VolumeSnapshot %q failed: %s", name, message)
+		}
+	}
+	return fmt.Errorf("timed out waiting for VolumeSnapshot %q to be ready", name)
+}
+
+func getSnapshotVolumes(namespace string, clusterName string, snapshotName string) ([]*snapshotVolume, error) {
+	configMap, err := getObject("configmap", snapshotConfigMapName(clusterName, snapshotName), "--namespace", namespace)
+	if err != nil {
+		return nil, fmt.Errorf("get snapshot %q: %s", snapshotName, err)
+	}
+	return decodeSnapshotConfigMap(configMap, clusterName, snapshotName)
+}
+
+func getSnapshots(namespace string, clusterName string) ([]*unstructured.Unstructured, error) {
+	snapshots, err := getObjects("configmaps", "--namespace", namespace, "--selector", fmt.Sprintf("%s=%s", snapshotClusterLabel, clusterName))
+	if err != nil {
+		return nil, fmt.Errorf("get snapshots: %s", err)
+	}
+	return snapshots, nil
+}
+
+func deleteSnapshot(namespace string, clusterName string, snapshotName string) error {
+	if err := runCommand(exec.Command("kubectl", "delete", "volumesnapshots.snapshot.storage.k8s.io,configmaps", "--namespace", namespace,
+		"--selector", fmt.Sprintf("%s=%s,%s=%s", snapshotLabel, snapshotName, snapshotClusterLabel, clusterName), "--ignore-not-found")); err != nil {
+		return fmt.Errorf("delete snapshot %q: %s", snapshotName, err)
+	}
+	return nil
+}
+
+// restoreSnapshot recreates the DataVolumes of the halted machines from a snapshot.
+func restoreSnapshot(namespace string, clusterName string, snapshotName string) error {
+	if err := checkClusterNotStopped(namespace, clusterName); err != nil {
+		return err
+	}
+	volumes, err := getSnapshotVolumes(namespace, clusterName, snapshotName)
+	if err != nil {
+		return err
+	}
+	machineVMs, err := getClusterMachineVMs(namespace, clusterName)
+	if err != nil {
+		return err
+	}
+	vmsByMachine := map[string]*unstructured.Unstructured{}
+	for _, m := range machineVMs {
+		vmsByMachine[m.Machine] = m.VM
+	}
+	for _, volume := range volumes {
+		vm := vmsByMachine[volume.Machine]
+		if vm == nil || vm.GetName() != volume.VirtualMachine {
+			return fmt.Errorf("machine %q of snapshot %q no longer exists in cluster %q, the snapshot can't be restored", volume.Machine, snapshotName, clusterName)
+		}
+	}
+
+	paused, err := isClusterPaused(namespace, clusterName)
+	if err != nil {
+		return err
+	}
+	if !paused {
+		if err := setClusterPaused(namespace, clusterName, true); err != nil {
+			return err
+		}
+		defer setClusterPaused(namespace, clusterName, false)
+	}
+
+	vmNames := map[string]bool{}
+	for _, volume := range volumes {
+		vmNames[volume.VirtualMachine] = true
+	}
+	runPolicies := map[string]string{}
+	defer func() {
+		for vmName, runPolicy := range runPolicies {
+			setVMRunPolicy(namespace, vmName, runPolicy)
+		}
+	}()
+	for vmName := range vmNames {
+		runPolicy, err := stopVM(namespace, vmName)
+		if err != nil {
+			return err
+		}
+		runPolicies[vmName] = runPolicy
+	}
+
+	for _, volume := range volumes {
+		fmt.Printf("Restoring DataVolume %q of machine %q\n", volume.DataVolume, volume.Machine)
+		if err := restoreDataVolume(namespace, volume.DataVolume, volume.VolumeSnapshot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreDataVolume recreates a DataVolume with the same name and storage from a VolumeSnapshot.
+// The snapshot is restored under a temporary name first, so the live DataVolume is only replaced by restored data.
+func restoreDataVolume(namespace string, name string, volumeSnapshotName string) error {
+	dataVolume, err := getObject("datavolumes.cdi.kubevirt.io", name, "--namespace", namespace)
+	if err != nil {
+		return fmt.Errorf("get DataVolume: %s", err)
+	}
+	pvc, _, _ := unstructured.NestedMap(dataVolume.Object, "spec", "pvc")
+
+	restoringName := fmt.Sprintf("%s-restoring", name)
+	if err := runCommand(exec.Command("kubectl", "delete", "datavolumes.cdi.kubevirt.io", restoringName, "--namespace", namespace, "--wait", "--ignore-not-found")); err != nil {
+		return fmt.Errorf("delete DataVolume: %s", err)
+	}
+	restoringDataVolume := newRestoredDataVolume(namespace, restoringName, pvc, map[string]interface{}{
+		"snapshot": map[string]interface{}{
+			"namespace": namespace,
+			"name":      volumeSnapshotName,
+		},
+	})
+	restoringDataVolume.SetLabels(dataVolume.GetLabels())
+	if err := applyObjects([]*unstructured.Unstructured{restoringDataVolume}); err != nil {
+		return fmt.Errorf("create DataVolume: %s", err)
+	}
+	if err := waitForDataVolumeReady(namespace, restoringName, dataVolumeTimeout); err != nil {
+		return err
+	}
+
+	restoredDataVolume := newRestoredDataVolume(namespace, name, pvc, map[string]interface{}{
+		"pvc": map[string]interface{}{
+			"namespace": namespace,
+			"name":      restoringName,
+		},
+	})
+	restoredDataVolume.SetLabels(dataVolume.GetLabels())
+	restoredDataVolume.SetOwnerReferences(dataVolume.GetOwnerReferences())
+	if err := runCommand(exec.Command("kubectl", "delete", "datavolumes.cdi.kubevirt.io", name, "--namespace", namespace, "--wait")); err != nil {
+		return fmt.Errorf("delete DataVolume: %s", err)
+	}
+	if err := applyObjects([]*unstructured.Unstructured{restoredDataVolume}); err != nil {
+		return fmt.Errorf("create DataVolume: %s", err)
+	}
+	if err := waitForDataVolumeReady(namespace, name, dataVolumeTimeout); err != nil {
+		return err
+	}
+
+	if err := runCommand(exec.Command("kubectl", "delete", "datavolumes.cdi.kubevirt.io", restoringName, "--namespace", namespace, "--ignore-not-found")); err != nil {
+		return fmt.Errorf("delete DataVolume: %s", err)
+	}
+	return nil
+}
+
+func newRestoredDataVolume(namespace string, name string, pvc map[string]interface{}, source map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "cdi.kubevirt.io/v1beta1",
+			"kind":       "DataVolume",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": namespace,
+			},
+			"spec": map[string]interface{}{
+				"pvc":    pvc,
+				"source": source,
+			},
+		},
+	}
+}
+
+// waitForDataVolumeReady waits for a DataVolume to be populated, or bound while waiting for its consumer.
+func waitForDataVolumeReady(namespace string, name string, timeout time.Duration) error {
+	var phase string
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(5 * time.Second) {
+		dataVolume, err := getObject("datavolumes.cdi.kubevirt.io", name, "--namespace", namespace)
+		if err != nil {
+			return fmt.Errorf("get DataVolume: %s", err)
+		}
+		phase, _, _ = unstructured.NestedString(dataVolume.Object, "status", "phase")
+		switch phase {
+		case "Succeeded":
+			return nil
+		case "Failed":
+			return fmt.Errorf("DataVolume %q failed", name)
+		case "WaitForFirstConsumer", "PendingPopulation":
+			pvcOutput, err := getCommandOutput(exec.Command("kubectl", "get", "persistentvolumeclaim", name, "--namespace", namespace, "--ignore-not-found", "-o", "name"))
+			if err == nil && len(pvcOutput) > 0 {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("timed out waiting for DataVolume %q, last phase %q", name, phase)
+}
+
+// stopVM halts a Virtink VM and waits for it to stop, returning its original run policy.
+func stopVM(namespace string, name string) (string, error) {
+	vm, err := getObject("virtualmachines.virt.virtink.smartx.com", name, "--namespace", namespace)
+	if err != nil {
+		return "", fmt.Errorf("get VM: %s", err)
+	}
+	runPolicy, _, _ := unstructured.NestedString(vm.Object, "spec", "runPolicy")
+	if runPolicy == "" {
+		runPolicy = "Once"
+	}
+	if err := setVMRunPolicy(namespace, name, "Halted"); err != nil {
+		return "", err
+	}
+	if err := waitForVMStopped(namespace, name); err != nil {
+		return "", err
+	}
+	return runPolicy, nil
+}
+
+func waitForVMStopped(namespace string, name string) error {
+	fmt.Printf("Waiting for VM %q to stop...\n", name)
+	for {
+		vm, err := getObject("virtualmachines.virt.virtink.smartx.com", name, "--namespace", namespace)
+		if err != nil {
+			return fmt.Errorf("get VM: %s", err)
+		}
+		phase, _, _ := unstructured.NestedString(vm.Object, "status", "phase")
+		if phase != "Running" && phase != "Scheduling" && phase != "Scheduled" {
+			return nil
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func setVMRunPolicy(namespace string, name string, runPolicy string) error {
+	if err := runCommand(exec.Command("kubectl", "patch", "virtualmachines.virt.virtink.smartx.com", name, "--namespace", namespace,
+		"--type", "merge", "--patch", fmt.Sprintf("{\"spec\":{\"runPolicy\":%q}}", runPolicy))); err != nil {
+		return fmt.Errorf("set run policy of VM %q: %s", name, err)
+	}
+	return nil
+}
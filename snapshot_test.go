@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSnapshotConfigMap(t *testing.T) {
+	volumes := []*snapshotVolume{{
+		Machine:        "foo-md-0-abcde",
+		VirtualMachine: "foo-md-0-xyz",
+		DataVolume:     "foo-md-0-xyz-rootfs",
+		VolumeSnapshot: "before-upgrade-foo-md-0-xyz-rootfs",
+	}, {
+		Machine:        "foo-cp-fghij",
+		VirtualMachine: "foo-cp-uvw",
+		DataVolume:     "foo-cp-uvw-rootfs",
+		VolumeSnapshot: "before-upgrade-foo-cp-uvw-rootfs",
+	}}
+	configMap, err := newSnapshotConfigMap("default", "foo", "before-upgrade", volumes)
+	if err != nil {
+		t.Fatalf("newSnapshotConfigMap() error = %v", err)
+	}
+	if got, want := configMap.GetName(), "knest-snapshot-foo-before-upgrade"; got != want {
+		t.Errorf("newSnapshotConfigMap() name = %q, want %q", got, want)
+	}
+
+	tests := []struct {
+		name         string
+		clusterName  string
+		snapshotName string
+		want         []*snapshotVolume
+		wantErr      bool
+	}{{
+		name:         "same cluster",
+		clusterName:  "foo",
+		snapshotName: "before-upgrade",
+		want:         volumes,
+	}, {
+		name:         "other cluster",
+		clusterName:  "bar",
+		snapshotName: "before-upgrade",
+		wantErr:      true,
+	}, {
+		name:         "other snapshot",
+		clusterName:  "foo",
+		snapshotName: "after-upgrade",
+		wantErr:      true,
+	}}
+
+	for _, tt := range tests {
+		got, err := decodeSnapshotConfigMap(configMap, tt.clusterName, tt.snapshotName)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: decodeSnapshotConfigMap() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: decodeSnapshotConfigMap() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	configMap.Object["data"] = map[string]interface{}{"volumes": "not json"}
+	if _, err := decodeSnapshotConfigMap(configMap, "foo", "before-upgrade"); err == nil {
+		t.Errorf("decodeSnapshotConfigMap() of invalid volumes succeeded, want error")
+	}
+}
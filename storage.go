@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type storageOptions struct {
+	StorageClass    string
+	VolumeMode      string
+	AccessMode      string
+	WorkerDataDisks []*dataDisk
+}
+
+type dataDisk struct {
+	Size         resource.Quantity
+	StorageClass string
+	Count        int
+}
+
+func (o *storageOptions) IsEmpty() bool {
+	return o.StorageClass == "" && o.VolumeMode == "" && o.AccessMode == "" && len(o.WorkerDataDisks) == 0
+}
+
+func validateStorageOptions(options *storageOptions) error {
+	switch options.VolumeMode {
+	case "", "Filesystem", "Block":
+	default:
+		return fmt.Errorf("unsupported volume mode: %s", options.VolumeMode)
+	}
+	switch options.AccessMode {
+	case "", "ReadWriteOnce", "ReadWriteMany", "ReadOnlyMany", "ReadWriteOncePod":
+	default:
+		return fmt.Errorf("unsupported access mode: %s", options.AccessMode)
+	}
+	return nil
+}
+
+// parseDataDisk parses a data disk in the form of "size=SIZE,storageClass=NAME,count=N".
+func parseDataDisk(spec string) (*dataDisk, error) {
+	disk := &dataDisk{
+		Count: 1,
+	}
+	sizeSet := false
+	for _, field := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid data disk field: %q", field)
+		}
+
+		switch key {
+		case "size":
+			size, err := resource.ParseQuantity(value)
+			if err != nil || size.Sign() <= 0 {
+				return nil, fmt.Errorf("invalid data disk size: %q", value)
+			}
+			disk.Size = size
+			sizeSet = true
+		case "storageClass":
+			disk.StorageClass = value
+		case "count":
+			count, err := strconv.Atoi(value)
+			if err != nil || count <= 0 {
+				return nil, fmt.Errorf("invalid data disk count: %q", value)
+			}
+			disk.Count = count
+		default:
+			return nil, fmt.Errorf("unknown data disk field: %q", key)
+		}
+	}
+
+	if !sizeSet {
+		return nil, fmt.Errorf("data disk size is required: %q", spec)
+	}
+	return disk, nil
+}
+
+// applyStorageOptionsToClusterTemplate applies the storage options to the VirtinkMachineTemplates.
+func applyStorageOptionsToClusterTemplate(clusterTemplateFilePath string, options *storageOptions) error {
+	clusterTemplateFile, err := os.Open(clusterTemplateFilePath)
+	if err != nil {
+		return err
+	}
+	objs, err := decodeObjects(clusterTemplateFile)
+	clusterTemplateFile.Close()
+	if err != nil {
+		return fmt.Errorf("decode cluster template: %s", err)
+	}
+
+	workerMachineTemplateNames := map[string]bool{}
+	for _, obj := range objs {
+		if obj.GetKind() == "MachineDeployment" {
+			name, _, _ := unstructured.NestedString(obj.Object, "spec", "template", "spec", "infrastructureRef", "name")
+			workerMachineTemplateNames[name] = true
+		}
+	}
+
+	found := false
+	for _, obj := range objs {
+		if obj.GetKind() != "VirtinkMachineTemplate" {
+			continue
+		}
+		claimTemplatesPath := []string{"spec", "template", "spec", "volumeClaimTemplates"}
+		claimTemplates, _, err := unstructured.NestedSlice(obj.Object, claimTemplatesPath...)
+		if err != nil {
+			return err
+		}
+		if len(claimTemplates) == 0 {
+			continue
+		}
+		found = true
+
+		if workerMachineTemplateNames[obj.GetName()] {
+			if err := addDataDisks(obj, options); err != nil {
+				return fmt.Errorf("add data disks to VirtinkMachineTemplate %q: %s", obj.GetName(), err)
+			}
+			claimTemplates, _, _ = unstructured.NestedSlice(obj.Object, claimTemplatesPath...)
+		}
+
+		for _, claimTemplate := range claimTemplates {
+			c, ok := claimTemplate.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if err := setPVCOptions(c, options); err != nil {
+				return err
+			}
+		}
+		if err := unstructured.SetNestedSlice(obj.Object, claimTemplates, claimTemplatesPath...); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return fmt.Errorf("no VirtinkMachineTemplate with volumeClaimTemplates found in cluster template, storage options require --persistent")
+	}
+
+	clusterTemplateFile, err = os.Create(clusterTemplateFilePath)
+	if err != nil {
+		return err
+	}
+	defer clusterTemplateFile.Close()
+	return encodeObjects(clusterTemplateFile, objs)
+}
+
+// setPVCOptions sets the storage options of a volume claim template, keeping its StorageClass if set.
+func setPVCOptions(claimTemplate map[string]interface{}, options *storageOptions) error {
+	if options.StorageClass != "" {
+		if existing, _, _ := unstructured.NestedString(claimTemplate, "spec", "pvc", "storageClassName"); existing == "" {
+			if err := unstructured.SetNestedField(claimTemplate, options.StorageClass, "spec", "pvc", "storageClassName"); err != nil {
+				return err
+			}
+		}
+	}
+	if options.VolumeMode != "" {
+		if err := unstructured.SetNestedField(claimTemplate, options.VolumeMode, "spec", "pvc", "volumeMode"); err != nil {
+			return err
+		}
+	}
+	if options.AccessMode != "" {
+		if err := unstructured.SetNestedStringSlice(claimTemplate, []string{options.AccessMode}, "spec", "pvc", "accessModes"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addDataDisks(machineTemplate *unstructured.Unstructured, options *storageOptions) error {
+	claimTemplatesPath := []string{"spec", "template", "spec", "volumeClaimTemplates"}
+	disksPath := []string{"spec", "template", "spec", "virtualMachineTemplate", "spec", "instance", "disks"}
+	volumesPath := []string{"spec", "template", "spec", "virtualMachineTemplate", "spec", "volumes"}
+	claimTemplates, _, err := unstructured.NestedSlice(machineTemplate.Object, claimTemplatesPath...)
+	if err != nil {
+		return err
+	}
+	disks, _, err := unstructured.NestedSlice(machineTemplate.Object, disksPath...)
+	if err != nil {
+		return err
+	}
+	volumes, _, err := unstructured.NestedSlice(machineTemplate.Object, volumesPath...)
+	if err != nil {
+		return err
+	}
+
+	index := 0
+	for _, disk := range options.WorkerDataDisks {
+		for i := 0; i < disk.Count; i++ {
+			volumeName := fmt.Sprintf("data-%d", index)
+			claimName := fmt.Sprintf("%s-data-%d", machineTemplate.GetName(), index)
+			index++
+
+			pvc := map[string]interface{}{
+				"accessModes": []interface{}{"ReadWriteOnce"},
+				"resources": map[string]interface{}{
+					"requests": map[string]interface{}{
+						"storage": disk.Size.String(),
+					},
+				},
+			}
+			if disk.StorageClass != "" {
+				pvc["storageClassName"] = disk.StorageClass
+			}
+			claimTemplates = append(claimTemplates, map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"name": claimName,
+				},
+				"spec": map[string]interface{}{
+					"pvc": pvc,
+					"source": map[string]interface{}{
+						"blank": map[string]interface{}{},
+					},
+				},
+			})
+			disks = append(disks, map[string]interface{}{
+				"name": volumeName,
+			})
+			volumes = append(volumes, map[string]interface{}{
+				"name": volumeName,
+				"dataVolume": map[string]interface{}{
+					"volumeName": claimName,
+				},
+			})
+		}
+	}
+
+	if err := unstructured.SetNestedSlice(machineTemplate.Object, claimTemplates, claimTemplatesPath...); err != nil {
+		return err
+	}
+	if err := unstructured.SetNestedSlice(machineTemplate.Object, disks, disksPath...); err != nil {
+		return err
+	}
+	return unstructured.SetNestedSlice(machineTemplate.Object, volumes, volumesPath...)
+}
@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestParseDataDisk(t *testing.T) {
+	tests := []struct {
+		spec             string
+		wantSize         string
+		wantStorageClass string
+		wantCount        int
+		wantErr          bool
+	}{{
+		spec:      "size=10Gi",
+		wantSize:  "10Gi",
+		wantCount: 1,
+	}, {
+		spec:             "size=100Gi,storageClass=fast,count=2",
+		wantSize:         "100Gi",
+		wantStorageClass: "fast",
+		wantCount:        2,
+	}, {
+		spec:    "storageClass=fast",
+		wantErr: true,
+	}, {
+		spec:    "size=0",
+		wantErr: true,
+	}, {
+		spec:    "size=-1Gi",
+		wantErr: true,
+	}, {
+		spec:    "size=large",
+		wantErr: true,
+	}, {
+		spec:    "size=10Gi,count=0",
+		wantErr: true,
+	}, {
+		spec:    "size=10Gi,count=two",
+		wantErr: true,
+	}, {
+		spec:    "size=10Gi,mode=Block",
+		wantErr: true,
+	}, {
+		spec:    "10Gi",
+		wantErr: true,
+	}}
+
+	for _, tt := range tests {
+		got, err := parseDataDisk(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseDataDisk(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if got.Size.String() != tt.wantSize || got.StorageClass != tt.wantStorageClass || got.Count != tt.wantCount {
+			t.Errorf("parseDataDisk(%q) = size %s, storageClass %q, count %d, want size %s, storageClass %q, count %d",
+				tt.spec, got.Size.String(), got.StorageClass, got.Count, tt.wantSize, tt.wantStorageClass, tt.wantCount)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	clusterTemplatesDir    = "templates/clusters"
+	embeddedTemplateScheme = "embedded://"
+)
+
+type clusterTemplate struct {
+	Name        string
+	Description string
+}
+
+// listClusterTemplates returns the embedded cluster templates, described by their first line comments.
+func listClusterTemplates() ([]*clusterTemplate, error) {
+	entries, err := fs.ReadDir(templatesFS, clusterTemplatesDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var clusterTemplates []*clusterTemplate
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".yaml" {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), ".yaml")
+		data, err := readClusterTemplate(name)
+		if err != nil {
+			return nil, err
+		}
+		clusterTemplates = append(clusterTemplates, &clusterTemplate{
+			Name:        name,
+			Description: parseClusterTemplateDescription(data),
+		})
+	}
+	return clusterTemplates, nil
+}
+
+// parseClusterTemplateDescription returns the comment on the first line of a cluster template, if any.
+func parseClusterTemplateDescription(data []byte) string {
+	firstLine, _, _ := bufio.NewReader(bytes.NewReader(data)).ReadLine()
+	if !strings.HasPrefix(string(firstLine), "#") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(string(firstLine), "#"))
+}
+
+func readClusterTemplate(name string) ([]byte, error) {
+	data, err := templatesFS.ReadFile(path.Join(clusterTemplatesDir, name+".yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("unknown cluster template %q", name)
+	}
+	return data, nil
+}
+
+// resolveClusterTemplateSource turns the value of "--from" into something clusterctl understands.
+func resolveClusterTemplateSource(from string) (string, func(), error) {
+	cleanup := func() {}
+	if strings.HasPrefix(from, embeddedTemplateScheme) {
+		data, err := readClusterTemplate(strings.TrimPrefix(from, embeddedTemplateScheme))
+		if err != nil {
+			return "", cleanup, err
+		}
+		file, err := os.CreateTemp("", "knest-cluster-template-*.yaml")
+		if err != nil {
+			return "", cleanup, err
+		}
+		defer file.Close()
+		cleanup = func() {
+			os.Remove(file.Name())
+		}
+		if _, err := file.Write(data); err != nil {
+			cleanup()
+			return "", func() {}, err
+		}
+		return file.Name(), cleanup, nil
+	}
+
+	if strings.Contains(from, "://") {
+		return from, cleanup, nil
+	}
+	if _, err := os.Stat(from); err != nil {
+		return "", cleanup, fmt.Errorf("read cluster template %q: %s", from, err)
+	}
+	absPath, err := filepath.Abs(from)
+	if err != nil {
+		return "", cleanup, err
+	}
+	return absPath, cleanup, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseClusterTemplateDescription(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{{
+		data: "# A single control plane machine.\napiVersion: v1\n",
+		want: "A single control plane machine.",
+	}, {
+		data: "#No space  \r\napiVersion: v1\n",
+		want: "No space",
+	}, {
+		data: "# Only a comment",
+		want: "Only a comment",
+	}, {
+		data: "apiVersion: v1\n# Not on the first line\n",
+		want: "",
+	}, {
+		data: "\n# After an empty line\n",
+		want: "",
+	}, {
+		data: "",
+		want: "",
+	}}
+
+	for _, tt := range tests {
+		if got := parseClusterTemplateDescription([]byte(tt.data)); got != tt.want {
+			t.Errorf("parseClusterTemplateDescription(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestListClusterTemplates(t *testing.T) {
+	clusterTemplates, err := listClusterTemplates()
+	if err != nil {
+		t.Fatalf("listClusterTemplates() error = %v", err)
+	}
+	if len(clusterTemplates) == 0 {
+		t.Fatalf("listClusterTemplates() returned no templates")
+	}
+	for _, clusterTemplate := range clusterTemplates {
+		if clusterTemplate.Description == "" {
+			t.Errorf("cluster template %q has no description", clusterTemplate.Name)
+		}
+	}
+}
+
+func TestResolveClusterTemplateSource(t *testing.T) {
+	dir := t.TempDir()
+	localTemplate := filepath.Join(dir, "local.yaml")
+	if err := os.WriteFile(localTemplate, []byte("apiVersion: v1\n"), 0644); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	tests := []struct {
+		from    string
+		want    string
+		wantErr bool
+	}{{
+		from: "https://example.com/cluster-template.yaml",
+		want: "https://example.com/cluster-template.yaml",
+	}, {
+		from: localTemplate,
+		want: localTemplate,
+	}, {
+		from:    filepath.Join(dir, "missing.yaml"),
+		wantErr: true,
+	}, {
+		from:    "embedded://missing",
+		wantErr: true,
+	}}
+
+	for _, tt := range tests {
+		got, cleanup, err := resolveClusterTemplateSource(tt.from)
+		cleanup()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("resolveClusterTemplateSource(%q) error = %v, wantErr %v", tt.from, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("resolveClusterTemplateSource(%q) = %q, want %q", tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestResolveEmbeddedClusterTemplateSource(t *testing.T) {
+	want, err := readClusterTemplate("single-node")
+	if err != nil {
+		t.Fatalf("readClusterTemplate() error = %v", err)
+	}
+	got, cleanup, err := resolveClusterTemplateSource("embedded://single-node")
+	if err != nil {
+		t.Fatalf("resolveClusterTemplateSource() error = %v", err)
+	}
+	data, err := os.ReadFile(got)
+	if err != nil {
+		t.Fatalf("read resolved template: %v", err)
+	}
+	if string(data) != string(want) {
+		t.Errorf("resolved template differs from the embedded one")
+	}
+	cleanup()
+	if _, err := os.Stat(got); !os.IsNotExist(err) {
+		t.Errorf("resolved template %q still exists after cleanup", got)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+	"k8s.io/client-go/util/homedir"
+)
+
+// clusterctlVariables are the template variables supplied by clusterctl itself from its flags.
+var clusterctlVariables = []string{"CLUSTER_NAME", "NAMESPACE", "KUBERNETES_VERSION", "CONTROL_PLANE_MACHINE_COUNT", "WORKER_MACHINE_COUNT"}
+
+// readTemplateVariables reads template variables from values files and "KEY=VALUE" pairs.
+func readTemplateVariables(valuesFiles []string, sets []string) ([]string, error) {
+	var variables []string
+	for _, valuesFile := range valuesFiles {
+		data, err := os.ReadFile(valuesFile)
+		if err != nil {
+			return nil, fmt.Errorf("read values file %q: %s", valuesFile, err)
+		}
+		values := map[string]interface{}{}
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("parse values file %q: %s", valuesFile, err)
+		}
+		for key, value := range values {
+			switch value.(type) {
+			case map[string]interface{}, []interface{}:
+				return nil, fmt.Errorf("invalid value of %q in values file %q: only scalar values are supported", key, valuesFile)
+			case nil:
+				value = ""
+			}
+			variables = append(variables, fmt.Sprintf("%s=%v", key, value))
+		}
+	}
+
+	for _, set := range sets {
+		if key, _, ok := strings.Cut(set, "="); !ok || key == "" {
+			return nil, fmt.Errorf("invalid template variable %q, should be in the form of 'KEY=VALUE'", set)
+		}
+		variables = append(variables, set)
+	}
+	return variables, nil
+}
+
+// checkTemplateVariables reports the required variables of the cluster template which are not supplied.
+func checkTemplateVariables(generateCmd *exec.Cmd) error {
+	listCmd := exec.Command(generateCmd.Args[0], append(generateCmd.Args[1:], "--list-variables")...)
+	listCmd.Env = generateCmd.Env
+	buf := &bytes.Buffer{}
+	listCmd.Stdout = buf
+	if err := runCommand(listCmd); err != nil {
+		return fmt.Errorf("list template variables: %s", err)
+	}
+
+	provided := map[string]bool{}
+	for _, variable := range clusterctlVariables {
+		provided[variable] = true
+	}
+	for _, env := range listCmd.Env {
+		if key, value, ok := strings.Cut(env, "="); ok && value != "" {
+			provided[key] = true
+		}
+	}
+	clusterctlConfig := viper.New()
+	clusterctlConfig.SetConfigFile(filepath.Join(homedir.HomeDir(), ".cluster-api", "clusterctl.yaml"))
+	if err := clusterctlConfig.ReadInConfig(); err == nil {
+		for _, key := range clusterctlConfig.AllKeys() {
+			provided[strings.ToUpper(key)] = true
+		}
+	}
+
+	var missing []string
+	inRequired := false
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "Required Variables:"):
+			inRequired = true
+		case strings.HasSuffix(line, "Variables:"):
+			inRequired = false
+		case inRequired && strings.HasPrefix(line, "- "):
+			variable := strings.Fields(strings.TrimPrefix(line, "- "))[0]
+			if !provided[variable] {
+				missing = append(missing, variable)
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing template variables %s, use --set or --values to specify them", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadTemplateVariables(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"base.yaml":     "FOO: foo\nCOUNT: 3\nENABLED: true\nEMPTY:\n",
+		"override.yaml": "FOO: bar\n",
+		"nested.yaml":   "FOO:\n  BAR: baz\n",
+		"invalid.yaml":  "- FOO\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		valuesFiles []string
+		sets        []string
+		want        map[string]string
+		wantErr     bool
+	}{{
+		name: "none",
+		want: map[string]string{},
+	}, {
+		name:        "values file",
+		valuesFiles: []string{"base.yaml"},
+		want:        map[string]string{"FOO": "foo", "COUNT": "3", "ENABLED": "true", "EMPTY": ""},
+	}, {
+		name:        "later values file takes precedence",
+		valuesFiles: []string{"base.yaml", "override.yaml"},
+		want:        map[string]string{"FOO": "bar", "COUNT": "3", "ENABLED": "true", "EMPTY": ""},
+	}, {
+		name:        "set takes precedence",
+		valuesFiles: []string{"base.yaml", "override.yaml"},
+		sets:        []string{"FOO=baz", "BAR=a=b", "EMPTY="},
+		want:        map[string]string{"FOO": "baz", "BAR": "a=b", "COUNT": "3", "ENABLED": "true", "EMPTY": ""},
+	}, {
+		name:        "nested value",
+		valuesFiles: []string{"nested.yaml"},
+		wantErr:     true,
+	}, {
+		name:        "invalid values file",
+		valuesFiles: []string{"invalid.yaml"},
+		wantErr:     true,
+	}, {
+		name:        "missing values file",
+		valuesFiles: []string{"missing.yaml"},
+		wantErr:     true,
+	}, {
+		name:    "set without value",
+		sets:    []string{"FOO"},
+		wantErr: true,
+	}, {
+		name:    "set without key",
+		sets:    []string{"=foo"},
+		wantErr: true,
+	}}
+
+	for _, tt := range tests {
+		var valuesFiles []string
+		for _, valuesFile := range tt.valuesFiles {
+			valuesFiles = append(valuesFiles, filepath.Join(dir, valuesFile))
+		}
+		variables, err := readTemplateVariables(valuesFiles, tt.sets)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: readTemplateVariables() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+
+		// The variables are appended to the environment of clusterctl, where later ones take precedence.
+		got := map[string]string{}
+		for _, variable := range variables {
+			key, value, _ := strings.Cut(variable, "=")
+			got[key] = value
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: readTemplateVariables() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}